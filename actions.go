@@ -0,0 +1,210 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/telebot.v3"
+)
+
+var (
+	// Buttons under replies which run the job again with other parameters,
+	// send the transcript in another format, or delete the reply. Their data
+	// is the ID of the reply in Replies
+	btnLarger     = telebot.Btn{Unique: "larger"}
+	btnEnglish    = telebot.Btn{Unique: "english"}
+	btnSRT        = telebot.Btn{Unique: "srt"}
+	btnTimestamps = telebot.Btn{Unique: "timestamps"}
+	btnDelete     = telebot.Btn{Unique: "delete"}
+
+	// Model sizes, smallest first
+	modelSizes = []string{"tiny", "base", "small", "medium", "large"}
+)
+
+// OnLarger transcribes the job of a reply again with the next larger model
+func (b *Bot) OnLarger(c telebot.Context) error {
+	return b.rerun(c, func(job *Job) {
+		if model := largerModel(job.Model, b.choices); model != "" {
+			job.Model = model
+		}
+	})
+}
+
+// OnEnglish transcribes the job of a reply again, translated to english
+func (b *Bot) OnEnglish(c telebot.Context) error {
+	return b.rerun(c, func(job *Job) {
+		job.Translate = true
+	})
+}
+
+// OnSRT sends the transcript of a reply as SubRip subtitles
+func (b *Bot) OnSRT(c telebot.Context) error {
+	return b.view(c, FormatSRT)
+}
+
+// OnTimestamps sends the transcript of a reply with the time of each segment
+func (b *Bot) OnTimestamps(c telebot.Context) error {
+	return b.view(c, FormatTimestamps)
+}
+
+// OnDelete deletes a reply and its record in the history, when pressed by
+// the user who sent the media or chat admins
+func (b *Bot) OnDelete(c telebot.Context) error {
+	reply, ok := b.replyOf(c)
+	if !ok {
+		return nil
+	}
+	if c.Sender().ID != reply.Job.User && !b.isChatAdmin(c) {
+		return c.Respond(&telebot.CallbackResponse{Text: "Only the sender of the message or chat admins can delete this transcript", ShowAlert: true})
+	}
+	c.Respond()
+	if b.history != nil && reply.Job.Record != 0 {
+		if err := b.history.Delete(reply.Job.Record); err != nil && err != ErrRecordNotFound {
+			jobLogger(c).Warn("deleting history", "record", reply.Job.Record, "error", err)
+		}
+	}
+	return c.Delete()
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// PRIVATE METHODS
+
+// actions returns the buttons under the reply to a job
+func (b *Bot) actions(job *Job, transcript *Transcript, footer string) *telebot.ReplyMarkup {
+	markup := &telebot.ReplyMarkup{}
+	id := strconv.FormatUint(b.replies.Add(Reply{Job: *job, Transcript: transcript, Footer: footer}), 10)
+	var run, view []telebot.Btn
+	if model := largerModel(job.Model, b.choices); model != "" {
+		run = append(run, markup.Data("Try "+model, btnLarger.Unique, id))
+	}
+	if !job.Translate && transcript.Language != "en" {
+		run = append(run, markup.Data("English", btnEnglish.Unique, id))
+	}
+	if b.translator != nil {
+		run = append(run, markup.Data("Translate to…", btnTranslate.Unique, id))
+	}
+	format := b.format(job)
+	if format != FormatSRT {
+		view = append(view, markup.Data("SRT", btnSRT.Unique, id))
+	}
+	if format != FormatTimestamps {
+		view = append(view, markup.Data("Timestamps", btnTimestamps.Unique, id))
+	}
+	view = append(view, markup.Data("Delete", btnDelete.Unique, id))
+	if len(run) > 0 {
+		markup.Inline(markup.Row(run...), markup.Row(view...))
+	} else {
+		markup.Inline(markup.Row(view...))
+	}
+	return markup
+}
+
+// replyOf returns the reply a button is under, and tells the user when it
+// has been forgotten
+func (b *Bot) replyOf(c telebot.Context) (*Reply, bool) {
+	return b.replyWithID(c, c.Data())
+}
+
+// replyWithID returns the reply with an ID from the data of a button, and
+// tells the user when it has been forgotten
+func (b *Bot) replyWithID(c telebot.Context, data string) (*Reply, bool) {
+	id, err := strconv.ParseUint(data, 10, 64)
+	if err == nil {
+		if reply, ok := b.replies.Get(id); ok {
+			return reply, true
+		}
+	}
+	c.Respond(&telebot.CallbackResponse{Text: "This transcript is too old, please send the message again", ShowAlert: true})
+	return nil, false
+}
+
+// rerun transcribes the job of the reply a button is under again, after
+// changing it with fn. Only the sender of the media or chat admins can run
+// it again, so others cannot use up the queue
+func (b *Bot) rerun(c telebot.Context, fn func(job *Job)) error {
+	reply, ok := b.replyOf(c)
+	if !ok {
+		return nil
+	}
+	job := &reply.Job
+	if c.Sender().ID != job.User && !b.isChatAdmin(c) {
+		return c.Respond(&telebot.CallbackResponse{Text: "Only the sender of the message or chat admins can transcribe it again", ShowAlert: true})
+	}
+	c.Respond()
+	fn(job)
+	job.ID, job.Attempts, job.Created = 0, 0, time.Time{}
+	jobLogger(c).Info("running again", "model", job.Model, "translate", job.Translate, "format", job.Format)
+	return b.run(c, job)
+}
+
+// view sends the transcript of the reply a button is under again in another
+// format. The stored transcript is used, so it is not transcribed again
+func (b *Bot) view(c telebot.Context, format string) error {
+	reply, ok := b.replyOf(c)
+	if !ok {
+		return nil
+	}
+	c.Respond()
+	job := &reply.Job
+	job.Format = format
+	jobLogger(c).Info("sending again", "format", format)
+	c = &replyContext{Context: c, options: b.sendOptions(job)}
+	return b.reply(c, job, reply.Transcript, reply.Footer, nil)
+}
+
+// largerModel returns the smallest of the choices which is larger than
+// model, or an empty string if there is none. English-only models are not
+// chosen for multilingual ones
+func largerModel(model string, choices []string) string {
+	english := strings.HasSuffix(model, ".en")
+	size := modelSize(model)
+	if size < 0 {
+		return ""
+	}
+	result, resultSize := "", len(modelSizes)
+	for _, choice := range choices {
+		choiceSize := modelSize(choice)
+		choiceEnglish := strings.HasSuffix(choice, ".en")
+		switch {
+		case choiceSize <= size, choiceEnglish && !english:
+			continue
+		case choiceSize < resultSize, choiceSize == resultSize && choiceEnglish == english && strings.HasSuffix(result, ".en") != english:
+			result, resultSize = choice, choiceSize
+		}
+	}
+	return result
+}
+
+// modelSize returns the index of the size of a model in modelSizes, or -1
+// when it is not known
+func modelSize(model string) int {
+	for i, size := range modelSizes {
+		if strings.Contains(model, "-"+size) {
+			return i
+		}
+	}
+	return -1
+}
+
+// withoutLanguages returns the buttons under a reply without the rows of
+// languages to translate to
+func withoutLanguages(markup *telebot.ReplyMarkup) *telebot.ReplyMarkup {
+	result := &telebot.ReplyMarkup{InlineKeyboard: [][]telebot.InlineButton{}}
+	if markup == nil {
+		return result
+	}
+	for _, row := range markup.InlineKeyboard {
+		if len(row) > 0 && isButton(row[0], btnTranslateTo) {
+			continue
+		}
+		result.InlineKeyboard = append(result.InlineKeyboard, row)
+	}
+	return result
+}
+
+// isButton returns true when an inline button is a kind of button. Buttons
+// received from telegram only have their unique name in the data
+func isButton(button telebot.InlineButton, btn telebot.Btn) bool {
+	return button.Unique == btn.Unique || button.Data == "\f"+btn.Unique || strings.HasPrefix(button.Data, "\f"+btn.Unique+"|")
+}
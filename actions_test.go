@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	// Packages
+	assert "github.com/stretchr/testify/assert"
+	"gopkg.in/telebot.v3"
+)
+
+func Test_Actions_000(t *testing.T) {
+	assert := assert.New(t)
+
+	for model, larger := range map[string]string{
+		"ggml-tiny":      "ggml-base",
+		"ggml-tiny.en":   "ggml-base.en",
+		"ggml-small":     "ggml-medium",
+		"ggml-medium":    "ggml-large-v1",
+		"ggml-medium.en": "ggml-large-v1",
+		"ggml-large":     "",
+		"other":          "",
+	} {
+		assert.Equal(larger, largerModel(model, modelNames), model)
+	}
+	assert.Equal("ggml-base", largerModel("ggml-tiny.en", []string{"ggml-large", "ggml-base"}))
+	assert.Equal("", largerModel("ggml-tiny", []string{"ggml-base.en"}))
+}
+
+func Test_Actions_001(t *testing.T) {
+	assert := assert.New(t)
+	models, backend := newTestModels(t, "Hello", "world")
+	bot := NewBot(models, WhisperParams{language: "auto"}, func(fileID string) (string, error) {
+		return "https://example.com/" + fileID, nil
+	})
+
+	// Replies have buttons which run the job again
+	c := newFakeContext(&telebot.Message{Voice: &telebot.Voice{File: telebot.File{FileID: "voice"}}})
+	assert.NoError(bot.OnMedia(c))
+	if !assert.NotNil(c.markup) || !assert.Len(c.markup.InlineKeyboard, 2) {
+		return
+	}
+	var texts []string
+	for _, row := range c.markup.InlineKeyboard {
+		for _, button := range row {
+			texts = append(texts, button.Text)
+		}
+	}
+	assert.Equal([]string{"Try ggml-base", "English", "SRT", "Timestamps", "Delete"}, texts)
+	id := c.markup.InlineKeyboard[1][0].Data
+	press := func(user int64) *fakeContext {
+		c := newFakeContext(&telebot.Message{Sender: &telebot.User{ID: user}})
+		c.data = id
+		return c
+	}
+
+	// Other formats are sent from the transcript, without running the job
+	backend.ProcessErr = errors.New("not transcribed again")
+	c = press(42)
+	assert.NoError(bot.OnSRT(c))
+	if assert.Len(c.sent, 1) {
+		if document, ok := c.sent[0].(*telebot.Document); assert.True(ok) {
+			assert.Equal("transcript.srt", document.FileName)
+			assert.Regexp(`^[0-9.]+ seconds with ggml-tiny`, document.Caption)
+		}
+	}
+
+	c = press(42)
+	assert.NoError(bot.OnTimestamps(c))
+	if assert.Len(c.sent, 1) {
+		assert.Regexp(`^00:00 Hello world\n\n[0-9.]+ seconds with ggml-tiny`, c.sent[0])
+	}
+	backend.ProcessErr = nil
+
+	c = press(42)
+	assert.NoError(bot.OnLarger(c))
+	if assert.Len(c.sent, 1) {
+		assert.Regexp(`seconds with ggml-base`, c.sent[0])
+	}
+
+	c = press(42)
+	assert.NoError(bot.OnEnglish(c))
+	assert.Len(c.sent, 1)
+	if contexts := backend.Contexts; assert.NotEmpty(contexts) {
+		assert.True(contexts[len(contexts)-1].Translate())
+	}
+
+	c = press(42)
+	assert.NoError(bot.OnDelete(c))
+	assert.True(c.deleted)
+
+	// Forgotten replies are not run again
+	c = press(42)
+	c.data = "999"
+	assert.NoError(bot.OnSRT(c))
+	assert.Empty(c.sent)
+}
+
+func Test_Actions_002(t *testing.T) {
+	assert := assert.New(t)
+	bot := newTestBot(t, "Hello", "world")
+	bot.telegram = &fakeMessenger{admins: map[string]bool{"7": true}}
+	bot.history = newTestHistory(t)
+	group := &telebot.Chat{ID: -100, Type: telebot.ChatSuperGroup}
+
+	c := newFakeContext(&telebot.Message{ID: 10, Chat: group, Sender: &telebot.User{ID: 5}, Voice: &telebot.Voice{File: telebot.File{FileID: "voice"}}})
+	assert.NoError(bot.OnMedia(c))
+	if !assert.NotNil(c.markup) {
+		return
+	}
+	id := c.markup.InlineKeyboard[0][0].Data
+	press := func(user int64) *fakeContext {
+		c := newFakeContext(&telebot.Message{Chat: group, Sender: &telebot.User{ID: user}})
+		c.data = id
+		return c
+	}
+
+	// Only the sender of the media and chat admins run the job again
+	c = press(9)
+	assert.NoError(bot.OnLarger(c))
+	assert.Empty(c.sent)
+	c = press(5)
+	assert.NoError(bot.OnLarger(c))
+	assert.Len(c.sent, 1)
+	c = press(7)
+	assert.NoError(bot.OnEnglish(c))
+	assert.Len(c.sent, 1)
+
+	// Running the job again replaces its record in the history, so only the
+	// last run is recorded
+	records, err := bot.history.Recent(group.ID, 5)
+	assert.NoError(err)
+	assert.Len(records, 1)
+
+	// Only the sender of the media and chat admins delete the reply, which
+	// deletes its record
+	c = press(9)
+	assert.NoError(bot.OnDelete(c))
+	assert.False(c.deleted)
+	c = press(7)
+	assert.NoError(bot.OnDelete(c))
+	assert.True(c.deleted)
+	records, err = bot.history.Recent(group.ID, 5)
+	assert.NoError(err)
+	assert.Empty(records)
+}
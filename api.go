@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	// Maximum size of an uploaded audio file
+	maxUploadSize = 100 << 20
+)
+
+var (
+	// Content types of the output formats
+	contentTypes = map[string]string{
+		FormatText:       "text/plain; charset=utf-8",
+		FormatTimestamps: "text/plain; charset=utf-8",
+		FormatJSON:       "application/json",
+		FormatSRT:        "application/x-subrip; charset=utf-8",
+		FormatVTT:        "text/vtt; charset=utf-8",
+		FormatASS:        "text/x-ssa; charset=utf-8",
+	}
+)
+
+// API serves transcriptions over HTTP
+type API struct {
+	models  *ModelManager
+	router  *Router
+	metrics *Metrics
+	params  WhisperParams
+	mux     *http.ServeMux
+
+	// Models which requests can choose
+	choices []string
+
+	// Tracks jobs so they can finish when shutting down, or nil
+	lifecycle *Lifecycle
+
+	// Shared with the bot so API jobs take turns with chats, or nil. API
+	// jobs count as chat 0
+	scheduler *Scheduler
+}
+
+// NewAPI returns an HTTP handler which transcribes audio with the models,
+// using params unless overridden by the request
+func NewAPI(models *ModelManager, params WhisperParams) *API {
+	api := &API{models: models, params: params, mux: http.NewServeMux(), choices: modelNames}
+	api.mux.HandleFunc("/transcribe", api.handleTranscribe)
+	api.mux.HandleFunc("/stream", api.handleStream)
+	return api
+}
+
+func (api *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	api.mux.ServeHTTP(w, r)
+}
+
+// handleTranscribe transcribes the audio in the request body, which is
+// either the raw file or a multipart form with a "file" field. The query
+// parameters offset, duration, language, format, speakers, channels, filters
+// and model override the defaults
+func (api *API) handleTranscribe(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	job := newJobID()
+	log := slog.Default().With("job", job, "remote", r.RemoteAddr)
+	w.Header().Set("X-Job-ID", job)
+
+	var model string
+	params, err := api.requestParams(r)
+	if err == nil {
+		model, err = api.requestModel(r)
+	}
+	if err != nil {
+		log.Info("invalid request", "error", err)
+		api.metrics.Job(JobInvalid)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Save the upload to a temporary file for ffmpeg
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	var body io.Reader = r.Body
+	if file, _, err := r.FormFile("file"); err == nil {
+		defer file.Close()
+		body = file
+	}
+	tmpfile := tempFileName("upload-", "")
+	defer os.Remove(tmpfile)
+	if fh, err := os.Create(tmpfile); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	} else if n, err := io.Copy(fh, body); err != nil {
+		fh.Close()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else {
+		fh.Close()
+		log.Info("received upload", "size", n)
+		api.metrics.Downloaded("upload", n)
+	}
+
+	// Jobs are cancelled when the client goes away, or when they are still
+	// running at the end of the grace period during shutdown
+	lifetime, done, err := api.lifecycle.Begin(nil)
+	if err != nil {
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer done()
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	defer context.AfterFunc(lifetime, cancel)()
+
+	start := time.Now()
+	if model == "" {
+		model = api.router.Model(Route{Queue: api.models.Pending() + api.scheduler.Waiting()})
+	}
+	waited := time.Now()
+	finish, err := api.scheduler.Wait(ctx, &Ticket{Priority: PriorityNormal})
+	if err != nil {
+		log.Info("cancelled while waiting", "error", err)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer finish()
+	api.metrics.Stage("wait", time.Since(waited))
+	wp, release, err := api.models.Acquire(model)
+	if err != nil {
+		log.Error("acquiring model", "model", model, "error", err)
+		api.metrics.Job(JobFailed)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer release()
+	ctx = withLogger(ctx, log.With("model", wp.name))
+	transcript, err := wp.Process(ctx, params, tmpfile, nil)
+	if err != nil {
+		log.Error("transcribing", "model", wp.name, "error", err)
+		api.metrics.Job(JobFailed)
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if transcript.Cached {
+		api.metrics.Job(JobCached)
+	} else {
+		api.metrics.Job(JobDone)
+		api.metrics.Transcribed(transcript, time.Since(start))
+	}
+	log.Info("transcribed", "model", transcript.Model, "duration", transcript.Duration, "elapsed", time.Since(start), "cached", transcript.Cached)
+	data, err := Format(transcript, params.out)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypes[params.out])
+	w.Header().Set("X-Model", transcript.Model)
+	w.Header().Set("X-Processing-Time", fmt.Sprintf("%.2f", time.Since(start).Seconds()))
+	w.Write(data)
+}
+
+// requestParams returns the default parameters with any overrides from the
+// query string applied
+func (api *API) requestParams(r *http.Request) (WhisperParams, error) {
+	params := api.params
+	params.out = FormatJSON
+	query := r.URL.Query()
+	if v := query.Get("offset"); v != "" {
+		offset, err := parseTimestamp(v)
+		if err != nil {
+			return params, err
+		}
+		params.offset = offset
+	}
+	if v := query.Get("duration"); v != "" {
+		duration, err := parseTimestamp(v)
+		if err != nil {
+			return params, err
+		}
+		params.duration = duration
+	}
+	if v := query.Get("language"); v != "" {
+		params.language = v
+	}
+	if v := query.Get("speakers"); v != "" {
+		speakers, err := strconv.ParseBool(v)
+		if err != nil {
+			return params, fmt.Errorf("invalid speakers: %q", v)
+		}
+		params.speakers = speakers
+	}
+	if v := query.Get("channels"); v != "" {
+		channels, err := strconv.ParseBool(v)
+		if err != nil {
+			return params, fmt.Errorf("invalid channels: %q", v)
+		}
+		params.channels = channels
+	}
+	if v := query.Get("filters"); v != "" {
+		filters, err := parseFilters(v)
+		if err != nil {
+			return params, err
+		}
+		params.filters = filters
+	}
+	if v := query.Get("format"); v != "" {
+		if !isInSet(v, formats) {
+			return params, fmt.Errorf("unsupported output format: %q", v)
+		}
+		params.out = v
+	}
+	return params, nil
+}
+
+// requestModel returns the model chosen by the query of a request, or an
+// empty string when the router chooses it
+func (api *API) requestModel(r *http.Request) (string, error) {
+	model := r.URL.Query().Get("model")
+	if model != "" && !isInSet(model, api.choices) {
+		return "", fmt.Errorf("unsupported model: %q", model)
+	}
+	return model, nil
+}
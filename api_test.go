@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	// Packages
+	assert "github.com/stretchr/testify/assert"
+)
+
+func newTestAPI(t *testing.T, texts ...string) (*API, *[]string) {
+	models, _ := newTestModels(t, texts...)
+
+	// Record what was uploaded
+	var uploads []string
+	models.loader = func(_ context.Context, path string) ([]float32, error) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, string(data))
+		return seconds(10), nil
+	}
+	return NewAPI(models, WhisperParams{language: "auto", out: FormatText}), &uploads
+}
+
+func Test_API_000(t *testing.T) {
+	assert := assert.New(t)
+	api, uploads := newTestAPI(t, "one", "two", "three", "four", "five")
+
+	// Raw body, JSON by default
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/transcribe?offset=2&duration=0:04", bytes.NewBufferString("audio")))
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("application/json", w.Header().Get("Content-Type"))
+	var result jsonTranscript
+	assert.NoError(json.Unmarshal(w.Body.Bytes(), &result))
+	assert.Equal("two three", result.Text)
+	assert.Equal("ggml-tiny", result.Model)
+	assert.Equal("ggml-tiny", w.Header().Get("X-Model"))
+	assert.Equal([]string{"audio"}, *uploads)
+
+	// Multipart form
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("file", "voice.ogg")
+	fw.Write([]byte("form audio"))
+	mw.Close()
+	r := httptest.NewRequest(http.MethodPost, "/transcribe?format=vtt", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, r)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("text/vtt; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Contains(w.Body.String(), "00:00:08.000 --> 00:00:10.000")
+	assert.Equal([]string{"audio", "form audio"}, *uploads)
+}
+
+func Test_API_001(t *testing.T) {
+	assert := assert.New(t)
+	api, _ := newTestAPI(t, "one")
+
+	for _, test := range []struct {
+		method, url string
+		code        int
+		body        string
+	}{
+		{http.MethodGet, "/transcribe", http.StatusMethodNotAllowed, "method not allowed\n"},
+		{http.MethodPost, "/transcribe?offset=soon", http.StatusBadRequest, "invalid timestamp: \"soon\"\n"},
+		{http.MethodPost, "/transcribe?duration=x", http.StatusBadRequest, "invalid timestamp: \"x\"\n"},
+		{http.MethodPost, "/transcribe?format=doc", http.StatusBadRequest, "unsupported output format: \"doc\"\n"},
+		{http.MethodPost, "/transcribe?model=../../etc/passwd", http.StatusBadRequest, "unsupported model: \"../../etc/passwd\"\n"},
+		{http.MethodPost, "/transcribe?offset=0:30", http.StatusUnprocessableEntity, "offset 30s is beyond the end of the audio (10s)\n"},
+		{http.MethodPost, "/transcribe?language=xx", http.StatusUnprocessableEntity, "unsupported language\n"},
+		{http.MethodPost, "/unknown", http.StatusNotFound, "404 page not found\n"},
+	} {
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, httptest.NewRequest(test.method, test.url, bytes.NewBufferString("audio")))
+		assert.Equal(test.code, w.Code, test.url)
+		assert.Equal(test.body, w.Body.String(), test.url)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	// Package imports
+	whisper "github.com/ggerganov/whisper.cpp/bindings/go/pkg/whisper"
+	wav "github.com/go-audio/wav"
+	ffmpeg "github.com/u2takey/ffmpeg-go"
+)
+
+// AudioLoader returns 16kHz mono samples for the audio at path, which can be
+// a local file or a URL
+type AudioLoader func(ctx context.Context, path string) ([]float32, error)
+
+// ChannelLoader returns 16kHz samples for each channel of the audio at path,
+// which can be a local file or a URL
+type ChannelLoader func(ctx context.Context, path string) ([][]float32, error)
+
+// loadAudio converts the audio at path with ffmpeg, mixing down to mono,
+// and decodes the result
+func loadAudio(ctx context.Context, path string) ([]float32, error) {
+	var data []float32
+	err := withWav(ctx, path, 1, func(r io.ReadSeeker) (err error) {
+		data, err = decodeWav(r)
+		return err
+	})
+	return data, err
+}
+
+// loadChannels converts the audio at path with ffmpeg, keeping its
+// channels, and decodes the result
+func loadChannels(ctx context.Context, path string) ([][]float32, error) {
+	var channels [][]float32
+	err := withWav(ctx, path, 0, func(r io.ReadSeeker) (err error) {
+		channels, err = decodeChannels(r)
+		return err
+	})
+	return channels, err
+}
+
+// withWav converts the audio at path to a temporary 16kHz WAV file with the
+// number of channels, or the channels of the audio when zero, and calls fn
+// to decode it
+func withWav(ctx context.Context, path string, channels int, fn func(io.ReadSeeker) error) error {
+	tmpfile := tempFileName("", ".wav")
+	defer os.Remove(tmpfile)
+
+	// Convert the received audio to 16kHz WAV format
+	if err := convertToWav(ctx, path, tmpfile, channels); err != nil {
+		return err
+	}
+
+	// Open the file
+	loggerFrom(ctx).Debug("decoding audio", "file", tmpfile)
+	fh, err := os.Open(tmpfile)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	return fn(fh)
+}
+
+// decodeWav decodes a 16kHz mono WAV file into samples
+func decodeWav(r io.ReadSeeker) ([]float32, error) {
+	channels, err := decodeChannels(r)
+	if err != nil {
+		return nil, err
+	} else if len(channels) != 1 {
+		return nil, fmt.Errorf("unsupported number of channels: %d", len(channels))
+	}
+	return channels[0], nil
+}
+
+// decodeChannels decodes a 16kHz WAV file into the samples of each channel
+func decodeChannels(r io.ReadSeeker) ([][]float32, error) {
+	// Decode the WAV file - load the full buffer
+	dec := wav.NewDecoder(r)
+	buf, err := dec.FullPCMBuffer()
+	if err != nil {
+		return nil, err
+	} else if dec.SampleRate != whisper.SampleRate {
+		return nil, fmt.Errorf("unsupported sample rate: %d", dec.SampleRate)
+	} else if dec.NumChans < 1 {
+		return nil, fmt.Errorf("unsupported number of channels: %d", dec.NumChans)
+	}
+
+	// Samples are interleaved
+	data := buf.AsFloat32Buffer().Data
+	n := int(dec.NumChans)
+	channels := make([][]float32, n)
+	for i := range channels {
+		channels[i] = make([]float32, 0, len(data)/n)
+	}
+	for i, sample := range data {
+		channels[i%n] = append(channels[i%n], sample)
+	}
+	return channels, nil
+}
+
+// convertToWav converts an audio file to 16kHz WAV format with the number
+// of channels, or the channels of the input when zero. The command is run
+// directly rather than with Run, which logs the input URL and so the bot
+// token
+func convertToWav(ctx context.Context, input, output string, channels int) error {
+	kwargs := ffmpeg.KwArgs{"c:a": "pcm_s16le", "ar": "16000", "f": "wav"}
+	if channels > 0 {
+		kwargs["ac"] = channels
+	}
+	args := ffmpeg.Input(input).
+		Output(output, kwargs).
+		OverWriteOutput().
+		GetArgs()
+	loggerFrom(ctx).Debug("converting audio", "command", "ffmpeg "+strings.Join(args, " "))
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if lines := strings.Split(strings.TrimSpace(stderr.String()), "\n"); lines[len(lines)-1] != "" {
+			return fmt.Errorf("ffmpeg: %w: %s", err, lines[len(lines)-1])
+		}
+		return fmt.Errorf("ffmpeg: %w", err)
+	}
+	return nil
+}
+
+// startDecoder runs ffmpeg to decode the audio written to the returned
+// writer, such as an opus stream, into 16kHz mono 16-bit PCM which is read
+// from the returned reader. The wait function returns once ffmpeg has exited
+func startDecoder(ctx context.Context) (io.WriteCloser, io.Reader, func() error, error) {
+	args := ffmpeg.Input("pipe:0").
+		Output("pipe:1", ffmpeg.KwArgs{"f": "s16le", "ac": "1", "ar": "16000"}).
+		GetArgs()
+	loggerFrom(ctx).Debug("decoding stream", "command", "ffmpeg "+strings.Join(args, " "))
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", append([]string{"-loglevel", "error"}, args...)...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, nil, nil, fmt.Errorf("ffmpeg: %w", err)
+	}
+	return stdin, stdout, cmd.Wait, nil
+}
+
+// pcmDecoder converts 16-bit little endian PCM to samples, keeping a byte
+// left over when data is split in the middle of a sample
+type pcmDecoder struct {
+	rest []byte
+}
+
+// Decode returns the samples in data
+func (d *pcmDecoder) Decode(data []byte) []float32 {
+	if len(d.rest) > 0 {
+		data = append(d.rest, data...)
+		d.rest = nil
+	}
+	samples := make([]float32, len(data)/2)
+	for i := range samples {
+		samples[i] = float32(int16(binary.LittleEndian.Uint16(data[2*i:]))) / 32768
+	}
+	if len(data)%2 != 0 {
+		d.rest = []byte{data[len(data)-1]}
+	}
+	return samples
+}
+
+func tempFileName(prefix, suffix string) string {
+	randBytes := make([]byte, 16)
+	rand.Read(randBytes)
+	return filepath.Join(os.TempDir(), prefix+hex.EncodeToString(randBytes)+suffix)
+}
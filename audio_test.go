@@ -0,0 +1,97 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	// Packages
+	whisper "github.com/ggerganov/whisper.cpp/bindings/go/pkg/whisper"
+	"github.com/go-audio/audio"
+	wav "github.com/go-audio/wav"
+	assert "github.com/stretchr/testify/assert"
+)
+
+const SamplePath = "pkg/whisper/samples/jfk.wav"
+
+// writeWav writes a 16 bit WAV file with the given rate and channels
+func writeWav(t *testing.T, rate, channels int, data []int) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.wav")
+	fh, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Close()
+	enc := wav.NewEncoder(fh, rate, 16, channels, 1)
+	if err := enc.Write(&audio.IntBuffer{
+		Format:         &audio.Format{NumChannels: channels, SampleRate: rate},
+		Data:           data,
+		SourceBitDepth: 16,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func Test_Audio_000(t *testing.T) {
+	assert := assert.New(t)
+	fh, err := os.Open(SamplePath)
+	if err != nil {
+		t.Skip("Skipping test, sample not found:", SamplePath)
+	}
+	defer fh.Close()
+
+	data, err := decodeWav(fh)
+	assert.NoError(err)
+	assert.Greater(len(data), 10*whisper.SampleRate)
+}
+
+func Test_Audio_001(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, test := range []struct {
+		rate, channels int
+		err            string
+	}{
+		{whisper.SampleRate, 1, ""},
+		{whisper.SampleRate, 2, "unsupported number of channels: 2"},
+		{44100, 1, "unsupported sample rate: 44100"},
+	} {
+		fh, err := os.Open(writeWav(t, test.rate, test.channels, make([]int, 1600*test.channels)))
+		assert.NoError(err)
+		data, err := decodeWav(fh)
+		fh.Close()
+		if test.err == "" {
+			assert.NoError(err)
+			assert.Len(data, 1600)
+		} else {
+			assert.EqualError(err, test.err)
+		}
+	}
+}
+
+func Test_Audio_002(t *testing.T) {
+	assert := assert.New(t)
+	var decoder pcmDecoder
+
+	// Samples split between chunks are joined up
+	assert.Equal([]float32{0, 0.5}, decoder.Decode([]byte{0x00, 0x00, 0x00, 0x40, 0x00}))
+	assert.Equal([]float32{-1, 0.25}, decoder.Decode([]byte{0x80, 0x00, 0x20}))
+	assert.Empty(decoder.Decode(nil))
+}
+
+func Test_Audio_003(t *testing.T) {
+	assert := assert.New(t)
+
+	// Samples of the channels are interleaved
+	fh, err := os.Open(writeWav(t, whisper.SampleRate, 2, []int{16384, -16384, 8192, 0, 0, 8192}))
+	assert.NoError(err)
+	defer fh.Close()
+	channels, err := decodeChannels(fh)
+	assert.NoError(err)
+	assert.Equal([][]float32{{0.5, 0.25, 0}, {-0.5, 0, 0.25}}, channels)
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	// Packages
+	whisper "github.com/ggerganov/whisper.cpp/bindings/go/pkg/whisper"
+)
+
+// Backend loads whisper models. The whisper.cpp bindings are used when
+// running the bot, and the scripted backend in pkg/fake-whisper in tests
+type Backend interface {
+	Load(path string) (whisper.Model, error)
+}
+
+// cgoBackend loads models with the whisper.cpp bindings
+type cgoBackend struct{}
+
+func (cgoBackend) Load(path string) (whisper.Model, error) {
+	return whisper.New(path)
+}
@@ -0,0 +1,548 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"log/slog"
+	"strings"
+	"time"
+
+	whisper "github.com/ggerganov/whisper.cpp/bindings/go/pkg/whisper"
+	"gopkg.in/telebot.v3"
+)
+
+var (
+	// Buttons under replies, for choosing a language to translate the
+	// transcript to and then translating it. Their data is the ID of the reply
+	// in Replies, after the language for btnTranslateTo
+	btnTranslate   = telebot.Btn{Unique: "translate"}
+	btnTranslateTo = telebot.Btn{Unique: "translate_to"}
+
+	// Languages offered for translation by default
+	defaultTranslations = []string{"en", "de", "es", "fr", "it", "pt", "ru", "uk"}
+)
+
+// Bot transcribes voice, audio and video messages received over telegram
+type Bot struct {
+	models   *ModelManager
+	settings *Settings
+	history  *History
+	queue    *Queue
+	cache    *Cache
+	metrics  *Metrics
+	stats    *Stats
+	params   WhisperParams
+	fileURL  func(fileID string) (string, error)
+
+	// Decides the order of jobs waiting for a turn
+	scheduler *Scheduler
+
+	// Tracks jobs so they can finish when shutting down, or nil
+	lifecycle *Lifecycle
+
+	// Telegram user IDs which can run admin commands
+	admins map[int64]bool
+
+	// Models which can be chosen with the /model command
+	choices []string
+
+	// Chooses the model for jobs when the chat has not chosen one
+	router *Router
+
+	// Sends and edits replies in progress, which are edited at most once per
+	// editInterval. Zero disables replies in progress
+	telegram     messenger
+	editInterval time.Duration
+
+	// Translates transcripts on demand and for chats which choose a
+	// language, or nil to disable translation. The translate button offers
+	// the translations languages
+	translator   Translator
+	translations []string
+
+	// Jobs of the latest replies, for the buttons under them
+	replies *Replies
+
+	// Username of the bot, for finding mentions in groups, and the group
+	// mode of chats which have not chosen one
+	username  string
+	groupMode string
+
+	// Words with a probability below lowConfidence are wrapped in the
+	// lowConfidenceMarker format string in text replies
+	lowConfidence       float32
+	lowConfidenceMarker string
+}
+
+// NewBot returns a bot which transcribes media with the models. The fileURL
+// function resolves a telegram file ID to a URL which can be downloaded
+func NewBot(models *ModelManager, params WhisperParams, fileURL func(string) (string, error)) *Bot {
+	return &Bot{
+		models:              models,
+		settings:            NewSettings(),
+		stats:               NewStats(),
+		scheduler:           NewScheduler(1, 0, 0),
+		admins:              make(map[int64]bool),
+		choices:             modelNames,
+		translations:        defaultTranslations,
+		replies:             NewReplies(maxReplies),
+		groupMode:           GroupAuto,
+		params:              params,
+		fileURL:             fileURL,
+		lowConfidenceMarker: "<i>%s</i>",
+	}
+}
+
+// Register adds the bot handlers to a telebot instance
+func (b *Bot) Register(bot *telebot.Bot) {
+	b.telegram = bot
+	if bot.Me != nil {
+		b.username = bot.Me.Username
+	}
+	bot.Handle(telebot.OnVoice, b.OnMedia)
+	bot.Handle(telebot.OnVideoNote, b.OnMedia)
+	bot.Handle(telebot.OnAudio, b.OnMedia)
+	bot.Handle(telebot.OnVideo, b.OnMedia)
+	bot.Handle("/model", b.OnModel)
+	bot.Handle("/default", b.OnDefault)
+	bot.Handle("/history", b.OnHistory)
+	bot.Handle("/search", b.OnSearch)
+	bot.Handle("/forget", b.OnForget)
+	bot.Handle("/stats", b.OnStats)
+	bot.Handle("/queue", b.OnQueue)
+	bot.Handle("/translate", b.OnTranslate)
+	bot.Handle("/format", b.OnFormat)
+	bot.Handle("/transcribe", b.OnTranscribe)
+	bot.Handle("/group", b.OnGroup)
+	bot.Handle("/silent", b.OnSilent)
+	bot.Handle("/speakers", b.OnSpeakers)
+	bot.Handle("/channels", b.OnChannels)
+	bot.Handle("/filters", b.OnFilters)
+	bot.Handle(telebot.OnText, b.OnText)
+	bot.Handle(telebot.OnQuery, b.OnQuery)
+	bot.Handle(&btnTranslate, b.OnTranslateMenu)
+	bot.Handle(&btnTranslateTo, b.OnTranslateTo)
+	bot.Handle(&btnLarger, b.OnLarger)
+	bot.Handle(&btnEnglish, b.OnEnglish)
+	bot.Handle(&btnSRT, b.OnSRT)
+	bot.Handle(&btnTimestamps, b.OnTimestamps)
+	bot.Handle(&btnDelete, b.OnDelete)
+}
+
+// OnMedia transcribes the media attached to a message and replies with the
+// text. In groups, the group mode decides which media is transcribed
+func (b *Bot) OnMedia(c telebot.Context) error {
+	kind, file, length := mediaFile(c.Message())
+	if file == nil {
+		return nil
+	}
+	if !b.allowed(c, false) {
+		jobLogger(c).Debug("ignored media", "kind", kind, "file", file.FileID)
+		return nil
+	}
+	jobLogger(c).Info("received media", "kind", kind, "file", file.FileID, "size", file.FileSize, "length", length)
+	return b.process(c, c.Message(), file, length)
+}
+
+// process transcribes the file of the media message, for the sender of the
+// message in the context
+func (b *Bot) process(c telebot.Context, media *telebot.Message, file *telebot.File, length time.Duration) error {
+	// The transcript belongs to the sender of the media rather than whoever
+	// asked for it, so users only find and delete transcripts of their own
+	// media. Media posted by a channel belongs to nobody
+	var owner int64
+	if media.Sender != nil {
+		owner = media.Sender.ID
+	}
+
+	// A caption such as "from 1:30 to 3:00" selects part of the audio
+	job := &Job{
+		Chat:     c.Chat().ID,
+		User:     owner,
+		Message:  media.ID,
+		FileID:   file.FileID,
+		UniqueID: file.UniqueID,
+		Size:     file.FileSize,
+		Length:   length,
+		Offset:   b.params.offset,
+		Duration: b.params.duration,
+	}
+	if c.Message().TopicMessage {
+		job.Thread = c.Message().ThreadID
+	}
+	if offset, duration, ok, err := parseWindow(media.Caption); err != nil {
+		jobLogger(c).Info("invalid window", "error", err)
+		b.metrics.Job(JobInvalid)
+		return c.Send(err.Error())
+	} else if ok {
+		job.Offset, job.Duration = offset, duration
+	}
+	job.Model = b.modelFor(job.Chat, job.User, length)
+	return b.run(c, job)
+}
+
+// run transcribes the file of a job and replies with the text. The job is
+// kept in the queue until it is done, and if it is cancelled when shutting
+// down, so it can be resumed after a restart
+func (b *Bot) run(c telebot.Context, job *Job) error {
+	c = &replyContext{Context: c, options: b.sendOptions(job)}
+	log := jobLogger(c)
+	notice := "The bot restarted before this message was transcribed, please send it again"
+	if b.queue != nil {
+		notice = "The bot is restarting, this message will be transcribed when it is back"
+	}
+	ctx, done, err := b.lifecycle.Begin(func() {
+		c.Send(notice)
+	})
+	if err != nil {
+		return c.Send(err.Error())
+	}
+	defer done()
+	ctx = withLogger(ctx, log)
+	params := b.params
+	params.offset, params.duration = job.Offset, job.Duration
+	params.translate = params.translate || job.Translate
+	params.out = b.format(job)
+	params.speakers = params.speakers || b.settings.Get(job.Chat).Speakers
+	params.channels = params.channels || b.settings.Get(job.Chat).Channels
+	params.filters = b.filters(job.Chat)
+	file, model := job.File(), job.Model
+
+	// The same file with the same parameters is returned from the cache
+	var key string
+	if b.cache != nil && file.UniqueID != "" {
+		key = fileKey(file.UniqueID, params, model)
+		if transcript, ok := b.cache.Probe(key); ok {
+			log.Info("file found in cache", "model", model)
+			b.metrics.Job(JobCached)
+			if b.queue != nil && job.ID != 0 {
+				b.dequeue(log, job)
+			}
+			return b.replyTranscript(c, job, transcript, 0, nil)
+		}
+	}
+
+	if b.queue != nil {
+		if err := b.queue.Put(job); err != nil {
+			log.Warn("queueing job", "error", err)
+		}
+		defer func() {
+			if ctx.Err() == nil {
+				b.dequeue(log, job)
+			}
+		}()
+	}
+
+	// Wait for a turn, so one chat sending many files does not hold up the
+	// others. The user is told if the job is cancelled while waiting
+	waited := time.Now()
+	finish, err := b.scheduler.Wait(ctx, &Ticket{
+		Chat:     job.Chat,
+		User:     job.User,
+		Priority: b.scheduler.Priority(b.admins[job.User], job.Length),
+		Length:   job.Length,
+	})
+	if err != nil {
+		log.Warn("cancelled while waiting", "error", err)
+		return nil
+	}
+	defer finish()
+	b.metrics.Stage("wait", time.Since(waited))
+
+	fileURL, err := b.fileURL(file.FileID)
+	if err != nil {
+		log.Error("resolving file", "error", err)
+		b.stats.Failed()
+		b.metrics.Job(JobFailed)
+		return c.Send(err.Error())
+	}
+
+	start := time.Now()
+	wp, release, err := b.models.Acquire(model)
+	if err != nil {
+		log.Error("acquiring model", "model", model, "error", err)
+		b.stats.Failed()
+		b.metrics.Job(JobFailed)
+		return c.Send(err.Error())
+	}
+	defer release()
+	b.metrics.Downloaded("telegram", file.FileSize)
+
+	// Text replies are shown as segments are transcribed
+	var progress *Progress
+	var cb whisper.SegmentCallback
+	if b.telegram != nil && b.editInterval > 0 && params.out == FormatText {
+		progress = NewProgress(b.telegram, c.Chat(), b.sendOptions(job), b.editInterval)
+		cb = progress.Segment
+	}
+	transcript, err := wp.Process(withLogger(ctx, log.With("model", model)), params, fileURL, cb)
+	recorgise_duration := time.Since(start)
+	if err != nil && ctx.Err() != nil {
+		// The user was told when the job was cancelled
+		log.Warn("cancelled", "model", model, "error", err)
+		b.stats.Failed()
+		b.metrics.Job(JobFailed)
+		progress.Cancel()
+		return nil
+	} else if err != nil {
+		log.Error("transcribing", "model", model, "error", err)
+		b.stats.Failed()
+		b.metrics.Job(JobFailed)
+		text := fmt.Sprintf("%s\n\n%.2f seconds with %s", err, recorgise_duration.Seconds(), model)
+		if progress.Finish(text) {
+			return nil
+		}
+		return c.Send(text)
+	}
+	if transcript.Cached {
+		b.metrics.Job(JobCached)
+	} else {
+		b.metrics.Job(JobDone)
+		b.metrics.Transcribed(transcript, recorgise_duration)
+	}
+	if key != "" {
+		if err := b.cache.Put(key, transcript); err != nil {
+			log.Warn("caching transcript", "error", err)
+		} else {
+			transcript.addCacheKey(key)
+		}
+	}
+	return b.replyTranscript(c, job, transcript, recorgise_duration, progress)
+}
+
+// Resume runs the jobs left in the queue when the bot last stopped, one at
+// a time. The newContext function returns a telebot context for replying to
+// a job. Jobs older than expiry, or which have already been resumed
+// maxAttempts times, are dropped and their users asked to send them again
+func (b *Bot) Resume(newContext func(telebot.Update) telebot.Context, expiry time.Duration) error {
+	jobs, err := b.queue.Jobs()
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		if b.lifecycle.Context().Err() != nil {
+			return nil
+		}
+		c := newContext(telebot.Update{Message: job.message()})
+		log := slog.Default().With("job", newJobID(), "chat", job.Chat, "user", job.User, "queued", job.ID)
+		c.Set(loggerKey, log)
+		switch {
+		case expiry > 0 && time.Since(job.Created) > expiry:
+			log.Info("queued job expired", "created", job.Created)
+			b.dequeue(log, job)
+			c.Send("The bot was restarting for too long and your message has expired, please send it again")
+		case job.Attempts >= maxAttempts:
+			log.Warn("queued job failed too many times", "attempts", job.Attempts)
+			b.dequeue(log, job)
+			c.Send(fmt.Sprintf("Your message could not be transcribed after %d attempts, please send it again", job.Attempts+1))
+		default:
+			job.Attempts++
+			if err := b.queue.Put(job); err != nil {
+				log.Warn("queueing job", "error", err)
+			}
+			log.Info("resuming queued job", "attempt", job.Attempts, "created", job.Created)
+			c.Send("The bot has restarted, transcribing your message now")
+			if err := b.run(c, job); err != nil {
+				log.Error("replying", "error", err)
+			}
+		}
+	}
+	return nil
+}
+
+// replyTranscript records a transcript in the history and replies with it,
+// with a footer showing the time taken, the model and the confidence. The
+// reply replaces the one in progress, if any
+func (b *Bot) replyTranscript(c telebot.Context, job *Job, transcript *Transcript, elapsed time.Duration, progress *Progress) error {
+	footer := fmt.Sprintf("%.2f seconds with %s", elapsed.Seconds(), transcript.Model)
+	if transcript.Cached {
+		footer += " (cached)"
+	} else if timings := formatTimings(transcript.Timings, 1); timings != "" {
+		footer += " (per run: " + timings + ")"
+	}
+	b.stats.Done(transcript, elapsed)
+	log := jobLogger(c)
+	if b.history != nil {
+		if err := b.record(job, transcript, elapsed); err != nil {
+			log.Warn("recording history", "error", err)
+		}
+	}
+	confidence := transcript.Confidence()
+	log.Info("transcribed",
+		"model", transcript.Model,
+		"language", transcript.Language,
+		"duration", transcript.Duration,
+		"elapsed", elapsed,
+		"cached", transcript.Cached,
+		"segments", len(transcript.Segments),
+		"confidence", confidence.Mean,
+		"min_confidence", confidence.Min,
+	)
+	if len(transcript.Segments) > 0 {
+		footer += fmt.Sprintf(", confidence %.0f%%", confidence.Mean*100)
+	}
+
+	if err := b.reply(c, job, transcript, footer, progress); err != nil {
+		return err
+	}
+
+	// Chats can choose to have every transcript translated
+	source := transcript.Language
+	if job.Translate {
+		source = "en"
+	}
+	target := b.settings.Get(c.Chat().ID).Translate
+	if b.translator == nil || target == "" || target == source || transcript.Text() == "" {
+		return nil
+	}
+	return b.sendTranslation(c, transcript.Text(), source, target)
+}
+
+// record adds a transcript to the history, or replaces the record of the
+// job when it runs again. The job remembers the record, so the buttons under
+// the reply can run it again or delete it
+func (b *Bot) record(job *Job, transcript *Transcript, elapsed time.Duration) error {
+	record := NewRecord(job.Chat, job.User, job.Message, transcript, elapsed)
+	if record.ID = job.Record; record.ID != 0 {
+		if err := b.history.Replace(record); err != ErrRecordNotFound {
+			return err
+		}
+	}
+	if err := b.history.Add(record); err != nil {
+		return err
+	}
+	job.Record = record.ID
+	return nil
+}
+
+// reply sends the transcript as text, or as a document for the subtitle
+// and JSON output formats, with buttons for running the job again
+func (b *Bot) reply(c telebot.Context, job *Job, transcript *Transcript, footer string, progress *Progress) error {
+	markup := b.actions(job, transcript, footer)
+	segmentText := func(segment whisper.Segment) string {
+		return highlight(segment, b.lowConfidence, b.lowConfidenceMarker)
+	}
+	format := b.format(job)
+	switch format {
+	case FormatText:
+		var text string
+		if transcript.Labelled() {
+			text = formatLabels(transcript, segmentText)
+		} else {
+			texts := make([]string, 0, len(transcript.Segments))
+			for _, segment := range transcript.Segments {
+				texts = append(texts, segmentText(segment))
+			}
+			text = joinText(texts)
+		}
+		text += "\n\n" + html.EscapeString(footer)
+		if progress.Finish(text, telebot.ModeHTML, markup) {
+			return nil
+		}
+		return c.Send(text, telebot.ModeHTML, markup)
+	case FormatTimestamps:
+		// The times are clickable as the reply is to the media
+		text := formatParagraphs(transcript.Segments, segmentText) + "\n\n" + html.EscapeString(footer)
+		return c.Send(text, telebot.ModeHTML, markup)
+	}
+	data, err := Format(transcript, format)
+	if err != nil {
+		return c.Send(err.Error())
+	}
+	return c.Send(&telebot.Document{
+		File:     telebot.FromReader(bytes.NewReader(data)),
+		FileName: "transcript." + format,
+		Caption:  footer,
+	}, markup)
+}
+
+// format returns the output format of a job, which can be chosen for the
+// job, for the chat or for the bot
+func (b *Bot) format(job *Job) string {
+	switch {
+	case job.Format != "":
+		return job.Format
+	case b.settings.Get(job.Chat).Format != "":
+		return b.settings.Get(job.Chat).Format
+	default:
+		return b.defaultFormat()
+	}
+}
+
+// defaultFormat returns the output format of chats which have not chosen one
+func (b *Bot) defaultFormat() string {
+	if b.params.out != "" {
+		return b.params.out
+	}
+	return FormatText
+}
+
+// filters returns the comma-separated filters applied to audio from a chat,
+// which can be chosen for the chat or for the bot
+func (b *Bot) filters(chat int64) string {
+	switch filters := b.settings.Get(chat).Filters; filters {
+	case "":
+		return b.params.filters
+	case FilterNone:
+		return ""
+	default:
+		return filters
+	}
+}
+
+// modelFor returns the model to use for a job from a user in a chat, with
+// the audio length reported by telegram. The model chosen in the chat wins
+// over the routing rules
+func (b *Bot) modelFor(chat, user int64, length time.Duration) string {
+	if model := b.settings.Get(chat).Model; model != "" {
+		return model
+	}
+	if model := b.router.Model(Route{
+		Duration: length,
+		Queue:    b.models.Pending() + b.scheduler.Waiting(),
+		Tier:     b.router.Tier(user),
+	}); model != "" {
+		return model
+	}
+	return b.models.Default()
+}
+
+// sendTranslation translates text from the source language to the target
+// and sends it to the chat
+func (b *Bot) sendTranslation(c telebot.Context, text, source, target string) error {
+	log := jobLogger(c)
+	start := time.Now()
+	translation, err := b.translator.Translate(b.lifecycle.Context(), strings.TrimSpace(text), source, target)
+	if err != nil {
+		log.Error("translating", "source", source, "target", target, "error", err)
+		return c.Send(err.Error())
+	}
+	log.Info("translated", "source", source, "target", target, "elapsed", time.Since(start))
+	return c.Send(fmt.Sprintf("Translation to %s:\n\n%s", target, translation))
+}
+
+func (b *Bot) dequeue(log *slog.Logger, job *Job) {
+	if err := b.queue.Remove(job.ID); err != nil {
+		log.Warn("removing job from the queue", "error", err)
+	}
+}
+
+// mediaFile returns the kind, file and duration of the media attached to a
+// message, or nil if there is no media which can be transcribed
+func mediaFile(msg *telebot.Message) (string, *telebot.File, time.Duration) {
+	switch {
+	case msg == nil:
+		return "", nil, 0
+	case msg.Voice != nil:
+		return "voice", &msg.Voice.File, time.Duration(msg.Voice.Duration) * time.Second
+	case msg.VideoNote != nil:
+		return "VideoNote", &msg.VideoNote.File, time.Duration(msg.VideoNote.Duration) * time.Second
+	case msg.Audio != nil:
+		return "Audio", &msg.Audio.File, time.Duration(msg.Audio.Duration) * time.Second
+	case msg.Video != nil:
+		return "Video", &msg.Video.File, time.Duration(msg.Video.Duration) * time.Second
+	default:
+		return "", nil, 0
+	}
+}
@@ -0,0 +1,650 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	// Packages
+	whisper "github.com/ggerganov/whisper.cpp/bindings/go/pkg/whisper"
+	fakewhisper "github.com/skrashevich/whisper.cpp-telegram/pkg/fake-whisper"
+	assert "github.com/stretchr/testify/assert"
+	"gopkg.in/telebot.v3"
+)
+
+// fakeContext is a telebot.Context which records what is sent. Methods which
+// are not overridden panic when called
+type fakeContext struct {
+	telebot.Context
+	msg     *telebot.Message
+	data    string
+	sent    []interface{}
+	markup  *telebot.ReplyMarkup
+	options *telebot.SendOptions
+	edited  []interface{}
+	deleted bool
+	query   *telebot.Query
+	answer  *telebot.QueryResponse
+	values  map[string]interface{}
+}
+
+func newFakeContext(msg *telebot.Message) *fakeContext {
+	if msg.Sender == nil {
+		msg.Sender = &telebot.User{ID: 42, Username: "alice"}
+	}
+	if msg.Chat == nil {
+		msg.Chat = &telebot.Chat{ID: 42, Type: telebot.ChatPrivate}
+	}
+	return &fakeContext{msg: msg}
+}
+
+func (c *fakeContext) Message() *telebot.Message { return c.msg }
+func (c *fakeContext) Sender() *telebot.User     { return c.msg.Sender }
+func (c *fakeContext) Chat() *telebot.Chat       { return c.msg.Chat }
+
+func (c *fakeContext) Args() []string {
+	if c.msg.Payload == "" {
+		return nil
+	}
+	return strings.Fields(c.msg.Payload)
+}
+
+func (c *fakeContext) Get(key string) interface{} { return c.values[key] }
+
+func (c *fakeContext) Set(key string, value interface{}) {
+	if c.values == nil {
+		c.values = make(map[string]interface{})
+	}
+	c.values[key] = value
+}
+
+func (c *fakeContext) Send(what interface{}, opts ...interface{}) error {
+	c.sent = append(c.sent, what)
+	for _, opt := range opts {
+		switch opt := opt.(type) {
+		case *telebot.ReplyMarkup:
+			c.markup = opt
+		case *telebot.SendOptions:
+			c.options = opt
+		}
+	}
+	return nil
+}
+
+func (c *fakeContext) Query() *telebot.Query { return c.query }
+
+func (c *fakeContext) Answer(resp *telebot.QueryResponse) error {
+	c.answer = resp
+	return nil
+}
+
+func (c *fakeContext) Delete() error {
+	c.deleted = true
+	return nil
+}
+
+func (c *fakeContext) Data() string { return c.data }
+
+func (c *fakeContext) Respond(resp ...*telebot.CallbackResponse) error { return nil }
+
+func (c *fakeContext) Edit(what interface{}, opts ...interface{}) error {
+	c.edited = append(c.edited, what)
+	return nil
+}
+
+// fakeTranslator translates by prefixing the text with the languages
+type fakeTranslator struct{}
+
+func (fakeTranslator) Translate(ctx context.Context, text, source, target string) (string, error) {
+	if target == "xx" {
+		return "", errors.New("xx is not supported")
+	}
+	return source + ">" + target + " " + text, nil
+}
+
+func newTestBot(t *testing.T, texts ...string) *Bot {
+	models, _ := newTestModels(t, texts...)
+	return NewBot(models, WhisperParams{language: "auto"}, func(fileID string) (string, error) {
+		return "https://example.com/" + fileID, nil
+	})
+}
+
+func Test_Bot_000(t *testing.T) {
+	assert := assert.New(t)
+	bot := newTestBot(t, "Hello", "world")
+
+	for _, msg := range []*telebot.Message{
+		{Voice: &telebot.Voice{File: telebot.File{FileID: "voice"}}},
+		{Audio: &telebot.Audio{File: telebot.File{FileID: "audio"}}},
+		{Video: &telebot.Video{File: telebot.File{FileID: "video"}}},
+		{VideoNote: &telebot.VideoNote{File: telebot.File{FileID: "note"}}},
+	} {
+		c := newFakeContext(msg)
+		assert.NoError(bot.OnMedia(c))
+		if assert.Len(c.sent, 1) {
+			assert.Regexp(`^Hello world\n\n[0-9.]+ seconds with ggml-tiny, confidence 90%$`, c.sent[0])
+		}
+	}
+}
+
+func Test_Bot_001(t *testing.T) {
+	assert := assert.New(t)
+	bot := newTestBot(t, "Hello")
+	bot.fileURL = func(fileID string) (string, error) {
+		return "", errors.New("file is too big")
+	}
+
+	c := newFakeContext(&telebot.Message{Voice: &telebot.Voice{File: telebot.File{FileID: "voice"}}})
+	assert.NoError(bot.OnMedia(c))
+	assert.Equal([]interface{}{"file is too big"}, c.sent)
+}
+
+func Test_Bot_002(t *testing.T) {
+	assert := assert.New(t)
+	bot := newTestBot(t, "Hello")
+
+	// Messages without media are ignored
+	c := newFakeContext(&telebot.Message{Text: "hi"})
+	assert.NoError(bot.OnMedia(c))
+	assert.Empty(c.sent)
+
+	// Transcription errors are sent to the user
+	bot.models.loader = func(context.Context, string) ([]float32, error) {
+		return nil, fmt.Errorf("unsupported number of channels: %d", 2)
+	}
+	c = newFakeContext(&telebot.Message{Voice: &telebot.Voice{File: telebot.File{FileID: "voice"}}})
+	assert.NoError(bot.OnMedia(c))
+	if assert.Len(c.sent, 1) {
+		assert.Contains(c.sent[0], "unsupported number of channels: 2")
+	}
+}
+
+func Test_Bot_003(t *testing.T) {
+	assert := assert.New(t)
+	bot := newTestBot(t, "Hello", "world")
+	bot.params.out = FormatVTT
+
+	c := newFakeContext(&telebot.Message{Voice: &telebot.Voice{File: telebot.File{FileID: "voice"}}})
+	assert.NoError(bot.OnMedia(c))
+	if assert.Len(c.sent, 1) {
+		doc, ok := c.sent[0].(*telebot.Document)
+		if assert.True(ok) {
+			assert.Equal("transcript.vtt", doc.FileName)
+			assert.Regexp(`^[0-9.]+ seconds with ggml-tiny, confidence 90%$`, doc.Caption)
+		}
+	}
+}
+
+func Test_Bot_004(t *testing.T) {
+	assert := assert.New(t)
+	bot := newTestBot(t, "Hello <world>")
+	bot.lowConfidence = 0.95
+
+	// Every word is below the threshold and is escaped
+	c := newFakeContext(&telebot.Message{Voice: &telebot.Voice{File: telebot.File{FileID: "voice"}}})
+	assert.NoError(bot.OnMedia(c))
+	if assert.Len(c.sent, 1) {
+		assert.Regexp(`^<i>Hello</i> <i>&lt;world&gt;</i>\n\n`, c.sent[0])
+	}
+}
+
+func Test_Bot_005(t *testing.T) {
+	assert := assert.New(t)
+	bot := newTestBot(t, "one", "two", "three", "four", "five")
+
+	// The caption selects a window of the audio
+	c := newFakeContext(&telebot.Message{Caption: "from 0:02 to 0:06", Audio: &telebot.Audio{File: telebot.File{FileID: "audio"}}})
+	assert.NoError(bot.OnMedia(c))
+	if assert.Len(c.sent, 1) {
+		assert.Regexp(`^two three\n\n`, c.sent[0])
+	}
+
+	// Invalid windows are reported
+	c = newFakeContext(&telebot.Message{Caption: "from 0:20", Audio: &telebot.Audio{File: telebot.File{FileID: "audio"}}})
+	assert.NoError(bot.OnMedia(c))
+	if assert.Len(c.sent, 1) {
+		assert.Regexp(`^offset 20s is beyond the end of the audio \(10s\)`, c.sent[0])
+	}
+	c = newFakeContext(&telebot.Message{Caption: "from 0:20 to 0:10", Audio: &telebot.Audio{File: telebot.File{FileID: "audio"}}})
+	assert.NoError(bot.OnMedia(c))
+	assert.Equal([]interface{}{"end 10s is not after start 20s"}, c.sent)
+}
+
+func Test_Bot_006(t *testing.T) {
+	assert := assert.New(t)
+	bot := newTestBot(t, "Hello")
+	rules, err := ParseRules("ggml-base:duration<=30s;ggml-small:tier=premium")
+	assert.NoError(err)
+	bot.router = &Router{Rules: rules, Tiers: map[int64]string{7: "premium"}}
+
+	// Short audio uses the base model, premium users the small model
+	assert.Equal("ggml-base", bot.modelFor(42, 42, 10*time.Second))
+	assert.Equal("ggml-tiny", bot.modelFor(42, 42, time.Minute))
+	assert.Equal("ggml-tiny", bot.modelFor(42, 42, 0))
+	assert.Equal("ggml-small", bot.modelFor(42, 7, time.Minute))
+
+	// The model is reported in the footer
+	c := newFakeContext(&telebot.Message{Voice: &telebot.Voice{File: telebot.File{FileID: "voice"}, Duration: 10}})
+	assert.NoError(bot.OnMedia(c))
+	if assert.Len(c.sent, 1) {
+		assert.Regexp(`\n\n[0-9.]+ seconds with ggml-base, confidence 90%$`, c.sent[0])
+	}
+
+	// A model chosen in the chat always wins
+	c = newFakeContext(&telebot.Message{Payload: "ggml-small"})
+	assert.NoError(bot.OnModel(c))
+	assert.Equal([]interface{}{"Using model ggml-small"}, c.sent)
+	assert.Equal("ggml-small", bot.modelFor(42, 42, 10*time.Second))
+	assert.Equal("ggml-tiny", bot.modelFor(43, 43, time.Minute))
+
+	c = newFakeContext(&telebot.Message{Payload: "ggml-huge"})
+	assert.NoError(bot.OnModel(c))
+	assert.Regexp(`^Model must be one of: `, c.sent[0])
+
+	c = newFakeContext(&telebot.Message{Payload: "default"})
+	assert.NoError(bot.OnModel(c))
+	assert.Equal([]interface{}{"Using the default model ggml-tiny"}, c.sent)
+	assert.Equal("ggml-tiny", bot.modelFor(42, 42, time.Minute))
+}
+
+func Test_Bot_007(t *testing.T) {
+	assert := assert.New(t)
+	bot := newTestBot(t, "Hello")
+
+	// Only admins can change the default model
+	c := newFakeContext(&telebot.Message{Payload: "ggml-base"})
+	assert.NoError(bot.OnDefault(c))
+	assert.Equal([]interface{}{"This command is only available to admins"}, c.sent)
+	assert.Equal("ggml-tiny", bot.models.Default())
+
+	bot.admins[42] = true
+	c = newFakeContext(&telebot.Message{Payload: "ggml-base"})
+	assert.NoError(bot.OnDefault(c))
+	assert.Equal([]interface{}{"Loading ggml-base...", "Default model is now ggml-base"}, c.sent)
+	assert.Equal("ggml-base", bot.models.Default())
+	assert.Equal([]string{"ggml-base"}, bot.models.Loaded())
+
+	// Jobs use the new default
+	c = newFakeContext(&telebot.Message{Voice: &telebot.Voice{File: telebot.File{FileID: "voice"}}})
+	assert.NoError(bot.OnMedia(c))
+	assert.Equal([]string{"ggml-base"}, bot.models.Loaded())
+}
+
+func Test_Bot_008(t *testing.T) {
+	assert := assert.New(t)
+	bot := newTestBot(t, "Hello", "world")
+
+	// History is disabled by default
+	c := newFakeContext(&telebot.Message{})
+	assert.NoError(bot.OnHistory(c))
+	assert.Equal([]interface{}{"History is disabled"}, c.sent)
+
+	// Transcripts are recorded
+	bot.history = newTestHistory(t)
+	c = newFakeContext(&telebot.Message{ID: 5, Voice: &telebot.Voice{File: telebot.File{FileID: "voice"}}})
+	assert.NoError(bot.OnMedia(c))
+	record, err := bot.history.Get(1)
+	if assert.NoError(err) {
+		assert.Equal(int64(42), record.Chat)
+		assert.Equal(int64(42), record.User)
+		assert.Equal(5, record.Message)
+		assert.Equal("ggml-tiny", record.Model)
+		assert.Equal("Hello world", record.Text())
+	}
+
+	c = newFakeContext(&telebot.Message{})
+	assert.NoError(bot.OnHistory(c))
+	if assert.Len(c.sent, 1) {
+		assert.Regexp(`^#1 [0-9-]+ [0-9:]+, 0:10, ggml-tiny\nHello world$`, c.sent[0])
+	}
+	c = newFakeContext(&telebot.Message{Payload: "wor"})
+	assert.NoError(bot.OnSearch(c))
+	if assert.Len(c.sent, 1) {
+		assert.Regexp(`^#1 `, c.sent[0])
+	}
+	c = newFakeContext(&telebot.Message{Payload: "goodbye"})
+	assert.NoError(bot.OnSearch(c))
+	assert.Equal([]interface{}{"Nothing found"}, c.sent)
+
+	// Users can delete their transcripts
+	c = newFakeContext(&telebot.Message{})
+	assert.NoError(bot.OnForget(c))
+	assert.Equal([]interface{}{"Deleted 1 transcripts"}, c.sent)
+	c = newFakeContext(&telebot.Message{})
+	assert.NoError(bot.OnHistory(c))
+	assert.Equal([]interface{}{"No transcripts yet"}, c.sent)
+}
+
+func Test_Bot_009(t *testing.T) {
+	assert := assert.New(t)
+	bot := newTestBot(t, "Hello", "world")
+	cache, err := OpenCache(t.TempDir(), 0)
+	assert.NoError(err)
+	bot.cache, bot.models.cache = cache, cache
+	bot.history = newTestHistory(t)
+
+	// A forwarded file is answered from the cache without downloading it
+	var urls []string
+	bot.fileURL = func(fileID string) (string, error) {
+		urls = append(urls, fileID)
+		return "https://example.com/" + fileID, nil
+	}
+	for _, fileID := range []string{"voice", "forwarded"} {
+		c := newFakeContext(&telebot.Message{Voice: &telebot.Voice{File: telebot.File{FileID: fileID, UniqueID: "unique"}}})
+		assert.NoError(bot.OnMedia(c))
+		if assert.Len(c.sent, 1) {
+			assert.Regexp(`^Hello world\n\n`, c.sent[0])
+		}
+		if fileID == "forwarded" {
+			assert.Regexp(`with ggml-tiny \(cached\), confidence 90%$`, c.sent[0])
+		}
+	}
+	assert.Equal([]string{"voice"}, urls)
+
+	// A different file with the same audio is found by its content
+	c := newFakeContext(&telebot.Message{Voice: &telebot.Voice{File: telebot.File{FileID: "copy", UniqueID: "copy"}}})
+	assert.NoError(bot.OnMedia(c))
+	if assert.Len(c.sent, 1) {
+		assert.Regexp(`\(cached\), confidence 90%$`, c.sent[0])
+	}
+	assert.Equal([]string{"voice", "copy"}, urls)
+
+	// Each job is one hit or miss, whichever key it was found by
+	assert.Equal(uint64(2), cache.Stats().Hits)
+	assert.Equal(uint64(1), cache.Stats().Misses)
+
+	// Forgetting the transcripts removes them from the cache
+	assert.Equal(3, cache.Stats().Entries)
+	c = newFakeContext(&telebot.Message{})
+	assert.NoError(bot.OnForget(c))
+	assert.Equal([]interface{}{"Deleted 3 transcripts"}, c.sent)
+	assert.Equal(0, cache.Stats().Entries)
+}
+
+func Test_Bot_010(t *testing.T) {
+	assert := assert.New(t)
+	bot := newTestBot(t, "Hello", "world")
+	backend := bot.models.backend.(*fakewhisper.Backend)
+	backend.Timings = whisper.Timings{Encode: 1200 * time.Millisecond, Decode: 300 * time.Millisecond}
+
+	// Whisper timings are shown in the footer
+	c := newFakeContext(&telebot.Message{Voice: &telebot.Voice{File: telebot.File{FileID: "voice"}}})
+	assert.NoError(bot.OnMedia(c))
+	if assert.Len(c.sent, 1) {
+		assert.Regexp(`\n\n[0-9.]+ seconds with ggml-tiny \(per run: encode 1.2s, decode 300ms\), confidence 90%$`, c.sent[0])
+	}
+
+	// And averaged over the jobs in the stats
+	backend.ProcessErr = errors.New("out of memory")
+	assert.NoError(bot.OnMedia(newFakeContext(&telebot.Message{Voice: &telebot.Voice{File: telebot.File{FileID: "voice"}}})))
+	c = newFakeContext(&telebot.Message{})
+	assert.NoError(bot.OnStats(c))
+	if assert.Len(c.sent, 1) {
+		assert.Regexp(`^Uptime: [0-9a-z.]+
+Jobs: 2 \(0 cached, 1 failed\)
+Audio: 0:10, real time factor [0-9.]+
+Whisper per run: encode 1.2s, decode 300ms
+Models: ggml-tiny \(default ggml-tiny, 0 jobs pending\)$`, c.sent[0])
+	}
+}
+
+func Test_Bot_011(t *testing.T) {
+	assert := assert.New(t)
+	bot := newTestBot(t, "Hello")
+	bot.lifecycle = NewLifecycle(20 * time.Millisecond)
+	started := make(chan struct{})
+	bot.models.loader = func(ctx context.Context, path string) ([]float32, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	// A job still running when the grace period expires is cancelled, and
+	// the user is asked to send the message again
+	c := newFakeContext(&telebot.Message{Voice: &telebot.Voice{File: telebot.File{FileID: "voice"}}})
+	result := make(chan error)
+	go func() {
+		result <- bot.OnMedia(c)
+	}()
+	<-started
+	assert.Equal(1, bot.lifecycle.Shutdown())
+	assert.NoError(<-result)
+	assert.Equal([]interface{}{"The bot restarted before this message was transcribed, please send it again"}, c.sent)
+
+	// Media received while shutting down is refused
+	c = newFakeContext(&telebot.Message{Voice: &telebot.Voice{File: telebot.File{FileID: "voice"}}})
+	assert.NoError(bot.OnMedia(c))
+	assert.Equal([]interface{}{ErrShuttingDown.Error()}, c.sent)
+}
+
+func Test_Bot_012(t *testing.T) {
+	assert := assert.New(t)
+	bot := newTestBot(t, "Hello")
+	bot.queue = newTestQueue(t)
+	bot.lifecycle = NewLifecycle(20 * time.Millisecond)
+	started := make(chan struct{})
+	bot.models.loader = func(ctx context.Context, path string) ([]float32, error) {
+		select {
+		case <-started:
+			return make([]float32, whisper.SampleRate*2), nil
+		default:
+			close(started)
+		}
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	// A job cancelled when shutting down stays in the queue
+	c := newFakeContext(&telebot.Message{ID: 7, Voice: &telebot.Voice{File: telebot.File{FileID: "voice"}}})
+	result := make(chan error)
+	go func() {
+		result <- bot.OnMedia(c)
+	}()
+	<-started
+	assert.Equal(1, bot.lifecycle.Shutdown())
+	assert.NoError(<-result)
+	assert.Equal([]interface{}{"The bot is restarting, this message will be transcribed when it is back"}, c.sent)
+
+	// Along with an expired job and one which keeps failing
+	assert.NoError(bot.queue.Put(&Job{Chat: 43, User: 43, FileID: "old", Model: "ggml-tiny", Created: time.Now().Add(-2 * time.Hour)}))
+	assert.NoError(bot.queue.Put(&Job{Chat: 44, User: 44, FileID: "crash", Model: "ggml-tiny", Attempts: maxAttempts}))
+	jobs, err := bot.queue.Jobs()
+	assert.NoError(err)
+	assert.Len(jobs, 3)
+
+	// After a restart the jobs are resumed or dropped
+	bot.lifecycle = NewLifecycle(time.Second)
+	contexts := make(map[int64]*fakeContext)
+	assert.NoError(bot.Resume(func(update telebot.Update) telebot.Context {
+		c := newFakeContext(update.Message)
+		contexts[update.Message.Chat.ID] = c
+		return c
+	}, time.Hour))
+	if c := contexts[42]; assert.NotNil(c) && assert.Len(c.sent, 2) {
+		assert.Equal(7, c.msg.ID)
+		assert.Equal("The bot has restarted, transcribing your message now", c.sent[0])
+		assert.Regexp(`^Hello\n\n[0-9.]+ seconds with ggml-tiny`, c.sent[1])
+	}
+	if c := contexts[43]; assert.NotNil(c) {
+		assert.Equal([]interface{}{"The bot was restarting for too long and your message has expired, please send it again"}, c.sent)
+	}
+	if c := contexts[44]; assert.NotNil(c) {
+		assert.Equal([]interface{}{"Your message could not be transcribed after 4 attempts, please send it again"}, c.sent)
+	}
+	jobs, err = bot.queue.Jobs()
+	assert.NoError(err)
+	assert.Empty(jobs)
+}
+
+func Test_Bot_013(t *testing.T) {
+	assert := assert.New(t)
+	bot := newTestBot(t, "Hello")
+
+	c := newFakeContext(&telebot.Message{Text: "/queue"})
+	assert.NoError(bot.OnQueue(c))
+	assert.Equal([]interface{}{"Queue: 0 running, 0 waiting\nYou have no jobs in the queue"}, c.sent)
+
+	// Jobs of the sender are listed in order
+	hold, err := bot.scheduler.Wait(context.Background(), &Ticket{Chat: 42, User: 42, Length: time.Minute})
+	assert.NoError(err)
+	defer hold()
+	order := make(chan string, 1)
+	enqueue(t, bot.scheduler, &Ticket{Chat: 42, User: 42, Length: 90 * time.Second}, "next", order)
+	c = newFakeContext(&telebot.Message{Text: "/queue"})
+	assert.NoError(bot.OnQueue(c))
+	assert.Equal([]interface{}{"Queue: 1 running, 1 waiting\nTranscribing now, 1:00 of audio\nPosition 1, 1:30 of audio"}, c.sent)
+}
+
+func Test_Bot_014(t *testing.T) {
+	assert := assert.New(t)
+	models, backend := newTestModels(t, "Hello", "world")
+	bot := NewBot(models, WhisperParams{language: "auto"}, func(fileID string) (string, error) {
+		return "https://example.com/" + fileID, nil
+	})
+	telegram := new(fakeMessenger)
+	bot.telegram, bot.editInterval = telegram, time.Millisecond
+	backend.Delay = 20 * time.Millisecond
+
+	// The reply in progress is replaced with the transcript
+	c := newFakeContext(&telebot.Message{Voice: &telebot.Voice{File: telebot.File{FileID: "voice"}}})
+	assert.NoError(bot.OnMedia(c))
+	assert.Empty(c.sent)
+	if assert.Len(telegram.sent, 1) && assert.NotEmpty(telegram.edits) {
+		assert.Regexp(`^Hello( world)? …$`, telegram.sent[0])
+		assert.Regexp(`^Hello world\n\n[0-9.]+ seconds with ggml-tiny, confidence 90%$`, telegram.edits[len(telegram.edits)-1])
+	}
+
+	// Documents are not shown in progress
+	bot.params.out = FormatVTT
+	c = newFakeContext(&telebot.Message{Voice: &telebot.Voice{File: telebot.File{FileID: "other"}}})
+	assert.NoError(bot.OnMedia(c))
+	assert.Len(c.sent, 1)
+	assert.Len(telegram.sent, 1)
+}
+
+func Test_Bot_015(t *testing.T) {
+	assert := assert.New(t)
+	bot := newTestBot(t, "Hello", "world")
+
+	// Translation is disabled without a translator
+	c := newFakeContext(&telebot.Message{Text: "/translate de", Payload: "de"})
+	assert.NoError(bot.OnTranslate(c))
+	assert.Equal([]interface{}{"Translation is disabled"}, c.sent)
+	bot.translator = fakeTranslator{}
+
+	// Transcripts are not translated until the chat chooses a language
+	c = newFakeContext(&telebot.Message{Voice: &telebot.Voice{File: telebot.File{FileID: "voice"}}})
+	assert.NoError(bot.OnMedia(c))
+	assert.Len(c.sent, 1)
+
+	// Choosing a language for the chat translates every transcript
+	for _, test := range []struct{ payload, reply string }{
+		{"", "Transcripts are not translated"},
+		{"german", "Usage: /translate <language code>, for example /translate de, or /translate off"},
+		{"DE", "Transcripts are translated to de"},
+	} {
+		c = newFakeContext(&telebot.Message{Payload: test.payload})
+		assert.NoError(bot.OnTranslate(c))
+		assert.Equal([]interface{}{test.reply}, c.sent)
+	}
+	assert.Equal("de", bot.settings.Get(42).Translate)
+	c = newFakeContext(&telebot.Message{Voice: &telebot.Voice{File: telebot.File{FileID: "voice"}}})
+	assert.NoError(bot.OnMedia(c))
+	if assert.Len(c.sent, 2) {
+		assert.Equal("Translation to de:\n\nauto>de Hello world", c.sent[1])
+	}
+	c = newFakeContext(&telebot.Message{Payload: "off"})
+	assert.NoError(bot.OnTranslate(c))
+	assert.Equal("", bot.settings.Get(42).Translate)
+
+	// The translate button shows the languages, which translate the text of
+	// the stored transcript without its timestamps or footer
+	bot.translations = []string{"de", "fr", "xx"}
+	id := bot.replies.Add(Reply{Job: Job{Format: FormatTimestamps}, Transcript: &Transcript{
+		Language: "auto",
+		Segments: []whisper.Segment{{Text: "Hello"}, {Text: "world"}},
+	}})
+	c = newFakeContext(&telebot.Message{Text: "[0:00] Hello\n\n[0:01] world\n\n1.00 seconds with ggml-tiny"})
+	c.data = strconv.FormatUint(id, 10)
+	assert.NoError(bot.OnTranslateMenu(c))
+	if assert.Len(c.edited, 1) {
+		markup := c.edited[0].(*telebot.ReplyMarkup)
+		if assert.Len(markup.InlineKeyboard, 1) && assert.Len(markup.InlineKeyboard[0], 3) {
+			assert.Equal("fr", markup.InlineKeyboard[0][1].Text)
+			assert.Equal("translate_to", markup.InlineKeyboard[0][1].Unique)
+			assert.Equal("fr|"+c.data, markup.InlineKeyboard[0][1].Data)
+		}
+	}
+	c.data = "fr|" + c.data
+	assert.NoError(bot.OnTranslateTo(c))
+	assert.Equal([]interface{}{"Translation to fr:\n\nauto>fr Hello world"}, c.sent)
+	assert.Len(c.edited, 2)
+	c.data = "xx|" + strconv.FormatUint(id, 10)
+	assert.NoError(bot.OnTranslateTo(c))
+	assert.Equal("xx is not supported", c.sent[1])
+
+	// Forgotten replies cannot be translated
+	c = newFakeContext(&telebot.Message{})
+	c.data = "fr|999"
+	assert.NoError(bot.OnTranslateTo(c))
+	assert.Empty(c.sent)
+}
+
+func Test_Bot_016(t *testing.T) {
+	assert := assert.New(t)
+	bot := newTestBot(t, "Hello", "world")
+
+	for _, test := range []struct{ payload, reply string }{
+		{"", "Format: text (default)\nAvailable: text, timestamps, json, srt, vtt, ass"},
+		{"doc", "Format must be one of: text, timestamps, json, srt, vtt, ass"},
+		{"timestamps", "Using format timestamps"},
+	} {
+		c := newFakeContext(&telebot.Message{Payload: test.payload})
+		assert.NoError(bot.OnFormat(c))
+		assert.Equal([]interface{}{test.reply}, c.sent)
+	}
+
+	// The chat gets transcripts in paragraphs with their times
+	c := newFakeContext(&telebot.Message{Voice: &telebot.Voice{File: telebot.File{FileID: "voice"}}})
+	assert.NoError(bot.OnMedia(c))
+	if assert.Len(c.sent, 1) {
+		assert.Regexp(`^00:00 Hello world\n\n[0-9.]+ seconds with ggml-tiny`, c.sent[0])
+	}
+
+	c = newFakeContext(&telebot.Message{Payload: "default"})
+	assert.NoError(bot.OnFormat(c))
+	assert.Equal([]interface{}{"Using the default format text"}, c.sent)
+	assert.Equal("", bot.settings.Get(42).Format)
+}
+
+func Test_Bot_017(t *testing.T) {
+	assert := assert.New(t)
+	bot := newTestBot(t, "Hello", "world")
+	bot.params.filters = "highpass,normalize"
+
+	for _, test := range []struct{ payload, reply, filters string }{
+		{"", "Filters: highpass, normalize (default)\nAvailable: highpass, denoise, normalize", "highpass,normalize"},
+		{"denoise on", "Using filters highpass, denoise, normalize", "highpass,denoise,normalize"},
+		{"highpass off", "Using filters denoise, normalize", "denoise,normalize"},
+		{"echo on", "Filter must be one of: highpass, denoise, normalize", "denoise,normalize"},
+		{"normalize,highpass", "Using filters highpass, normalize", "highpass,normalize"},
+		{"none", "Using filters none", ""},
+		{"", "Filters: none\nAvailable: highpass, denoise, normalize", ""},
+		{"default", "Using filters highpass, normalize (default)", "highpass,normalize"},
+	} {
+		c := newFakeContext(&telebot.Message{Payload: test.payload})
+		assert.NoError(bot.OnFilters(c))
+		assert.Equal([]interface{}{test.reply}, c.sent, test.payload)
+		assert.Equal(test.filters, bot.filters(42), test.payload)
+	}
+
+	c := newFakeContext(&telebot.Message{Payload: "echo"})
+	assert.NoError(bot.OnFilters(c))
+	if assert.Len(c.sent, 1) {
+		assert.Contains(c.sent[0], `unknown filter "echo"`)
+	}
+}
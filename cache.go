@@ -0,0 +1,243 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	cacheExt = ".json"
+)
+
+// Cache keeps transcripts on disk, keyed by a hash of the audio or of the
+// telegram file plus the parameters and model which produced them. The
+// least recently used entries are removed to keep the cache within its size
+type Cache struct {
+	sync.Mutex
+	dir     string
+	limit   int64
+	size    int64
+	entries map[string]*cacheEntry
+	hits    uint64
+	misses  uint64
+}
+
+type cacheEntry struct {
+	size int64
+	used time.Time
+}
+
+// CacheStats counts cache lookups and entries
+type CacheStats struct {
+	Hits    uint64
+	Misses  uint64
+	Entries int
+	Size    int64
+}
+
+// OpenCache returns a cache which stores up to limit bytes in dir, creating
+// the directory if needed. A limit of zero means no limit
+func OpenCache(dir string, limit int64) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	cache := &Cache{dir: dir, limit: limit, entries: make(map[string]*cacheEntry)}
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != cacheExt {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+		key := strings.TrimSuffix(file.Name(), cacheExt)
+		cache.entries[key] = &cacheEntry{size: info.Size(), used: info.ModTime()}
+		cache.size += info.Size()
+	}
+	cache.evict()
+	return cache, nil
+}
+
+// Get returns the transcript for a key, with Cached set. The lookup counts
+// as a hit or a miss
+func (c *Cache) Get(key string) (*Transcript, bool) {
+	transcript, ok := c.get(key)
+	if !ok {
+		c.Lock()
+		c.misses++
+		c.Unlock()
+	}
+	return transcript, ok
+}
+
+// Probe returns the transcript for a key like Get, for a lookup which is
+// followed by another one when it misses, such as the telegram file before
+// the audio. Only hits are counted, so that the lookup which follows counts
+// the miss once
+func (c *Cache) Probe(key string) (*Transcript, bool) {
+	return c.get(key)
+}
+
+// Put stores the transcript for a key. The stored transcript has the key
+// added to its CacheKeys, and the transcript passed in is not changed
+func (c *Cache) Put(key string, transcript *Transcript) error {
+	stored := *transcript
+	stored.CacheKeys = append([]string(nil), transcript.CacheKeys...)
+	stored.addCacheKey(key)
+	data, err := json.Marshal(&stored)
+	if err != nil {
+		return err
+	}
+	c.Lock()
+	defer c.Unlock()
+	if err := os.WriteFile(c.path(key), data, 0644); err != nil {
+		return err
+	}
+	if entry, exists := c.entries[key]; exists {
+		c.size -= entry.size
+	}
+	c.entries[key] = &cacheEntry{size: int64(len(data)), used: time.Now()}
+	c.size += int64(len(data))
+	c.evict()
+	return nil
+}
+
+// Remove deletes the entries for keys, when they exist
+func (c *Cache) Remove(keys ...string) {
+	c.Lock()
+	defer c.Unlock()
+	for _, key := range keys {
+		if _, exists := c.entries[key]; exists {
+			c.remove(key)
+		}
+	}
+}
+
+// Stats returns the number of hits and misses and the size of the cache
+func (c *Cache) Stats() CacheStats {
+	c.Lock()
+	defer c.Unlock()
+	return CacheStats{Hits: c.hits, Misses: c.misses, Entries: len(c.entries), Size: c.size}
+}
+
+// contentKey returns the cache key for decoded audio transcribed with params
+// by a model
+func contentKey(data []float32, params WhisperParams, model string) string {
+	hash := sha256.New()
+	buf := make([]byte, 4)
+	for _, sample := range data {
+		binary.LittleEndian.PutUint32(buf, math.Float32bits(sample))
+		hash.Write(buf)
+	}
+	fmt.Fprintf(hash, "\x00%s\x00%s", model, cacheParams(params))
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// fileKey returns the cache key for a telegram file, by its unique ID,
+// transcribed with params by a model
+func fileKey(uniqueID string, params WhisperParams, model string) string {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("file\x00%s\x00%s\x00%s", uniqueID, model, cacheParams(params))))
+	return hex.EncodeToString(hash[:])
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// PRIVATE METHODS
+
+// get returns the transcript for a key, and counts a hit
+func (c *Cache) get(key string) (*Transcript, bool) {
+	c.Lock()
+	defer c.Unlock()
+	entry, exists := c.entries[key]
+	if !exists {
+		return nil, false
+	}
+	data, err := os.ReadFile(c.path(key))
+	if err != nil {
+		slog.Warn("cache", "error", err)
+		c.remove(key)
+		return nil, false
+	}
+	transcript := new(Transcript)
+	if err := json.Unmarshal(data, transcript); err != nil {
+		slog.Warn("cache", "error", err)
+		c.remove(key)
+		return nil, false
+	}
+
+	// Modification time records when the entry was last used
+	entry.used = time.Now()
+	os.Chtimes(c.path(key), entry.used, entry.used)
+	c.hits++
+	transcript.Cached = true
+	transcript.addCacheKey(key)
+	return transcript, true
+}
+
+// cacheParams returns the parameters which change the transcript as a
+// string, ignoring the ones which only change how it is output
+func cacheParams(params WhisperParams) string {
+	params.out, params.colorize, params.tokens = "", false, false
+	return fmt.Sprintf("%+v", params)
+}
+
+// addCacheKey adds a key to the cache keys of a transcript, unless it is
+// already there
+func (t *Transcript) addCacheKey(key string) {
+	for _, k := range t.CacheKeys {
+		if k == key {
+			return
+		}
+	}
+	t.CacheKeys = append(t.CacheKeys, key)
+}
+
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.dir, key+cacheExt)
+}
+
+// remove deletes an entry. It must be called with the lock held
+func (c *Cache) remove(key string) {
+	if entry, exists := c.entries[key]; exists {
+		c.size -= entry.size
+		delete(c.entries, key)
+	}
+	if err := os.Remove(c.path(key)); err != nil && !os.IsNotExist(err) {
+		slog.Warn("cache", "error", err)
+	}
+}
+
+// evict removes the least recently used entries until the cache is within
+// its limit. It must be called with the lock held
+func (c *Cache) evict() {
+	if c.limit <= 0 || c.size <= c.limit {
+		return
+	}
+	keys := make([]string, 0, len(c.entries))
+	for key := range c.entries {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return c.entries[keys[i]].used.Before(c.entries[keys[j]].used)
+	})
+	for _, key := range keys {
+		if c.size <= c.limit {
+			break
+		}
+		c.remove(key)
+	}
+}
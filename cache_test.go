@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	// Packages
+	fakewhisper "github.com/skrashevich/whisper.cpp-telegram/pkg/fake-whisper"
+	assert "github.com/stretchr/testify/assert"
+)
+
+func Test_Cache_000(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	cache, err := OpenCache(dir, 0)
+	assert.NoError(err)
+
+	_, ok := cache.Get("missing")
+	assert.False(ok)
+
+	transcript := &Transcript{
+		Model:    "ggml-tiny",
+		Language: "en",
+		Duration: 4 * time.Second,
+		Segments: fakewhisper.Segments(2*time.Second, "Hello", "world"),
+	}
+	assert.NoError(cache.Put("key", transcript))
+	result, ok := cache.Get("key")
+	if assert.True(ok) {
+		assert.True(result.Cached)
+		assert.False(transcript.Cached)
+		assert.Equal("ggml-tiny", result.Model)
+		assert.Equal(transcript.Segments, result.Segments)
+		assert.Equal(transcript.Confidence(), result.Confidence())
+	}
+	stats := cache.Stats()
+	assert.Equal(uint64(1), stats.Hits)
+	assert.Equal(uint64(1), stats.Misses)
+	assert.Equal(1, stats.Entries)
+
+	// Entries survive reopening
+	cache, err = OpenCache(dir, 0)
+	assert.NoError(err)
+	_, ok = cache.Get("key")
+	assert.True(ok)
+}
+
+func Test_Cache_001(t *testing.T) {
+	assert := assert.New(t)
+	cache, err := OpenCache(t.TempDir(), 0)
+	assert.NoError(err)
+	transcript := &Transcript{Segments: fakewhisper.Segments(time.Second, "one")}
+	assert.NoError(cache.Put("a", transcript))
+	size := cache.Stats().Size
+
+	// Least recently used entries are removed to stay within the limit
+	cache.limit = 2 * size
+	assert.NoError(cache.Put("b", transcript))
+	time.Sleep(time.Millisecond)
+	_, ok := cache.Get("a")
+	assert.True(ok)
+	time.Sleep(time.Millisecond)
+	assert.NoError(cache.Put("c", transcript))
+	assert.Equal(2, cache.Stats().Entries)
+	_, ok = cache.Get("b")
+	assert.False(ok)
+	_, ok = cache.Get("a")
+	assert.True(ok)
+
+	// Unreadable entries are dropped
+	assert.NoError(os.WriteFile(cache.path("c"), []byte("{"), 0644))
+	_, ok = cache.Get("c")
+	assert.False(ok)
+	assert.Equal(1, cache.Stats().Entries)
+}
+
+func Test_Cache_002(t *testing.T) {
+	assert := assert.New(t)
+	params := WhisperParams{language: "auto"}
+	data := seconds(1)
+
+	// Keys depend on the audio, the model and the parameters which change
+	// the transcript
+	key := contentKey(data, params, "ggml-tiny")
+	assert.Len(key, 64)
+	assert.Equal(key, contentKey(seconds(1), params, "ggml-tiny"))
+	assert.NotEqual(key, contentKey(seconds(2), params, "ggml-tiny"))
+	assert.NotEqual(key, contentKey(data, params, "ggml-base"))
+	assert.NotEqual(key, contentKey(data, WhisperParams{language: "de"}, "ggml-tiny"))
+	assert.NotEqual(key, contentKey(data, WhisperParams{language: "auto", offset: time.Second}, "ggml-tiny"))
+	assert.Equal(key, contentKey(data, WhisperParams{language: "auto", out: FormatVTT, colorize: true}, "ggml-tiny"))
+
+	key = fileKey("unique", params, "ggml-tiny")
+	assert.Equal(key, fileKey("unique", params, "ggml-tiny"))
+	assert.NotEqual(key, fileKey("other", params, "ggml-tiny"))
+	assert.NotEqual(key, fileKey("unique", params, "ggml-base"))
+}
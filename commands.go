@@ -0,0 +1,428 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/telebot.v3"
+)
+
+const (
+	// Maximum number of transcripts listed by /history and /search
+	maxHistory = 20
+
+	// Number of characters of each transcript shown in lists
+	maxPreview = 200
+)
+
+// OnModel shows the model used in the chat, or chooses a model for the
+// chat with "/model <name>". Use "/model default" to go back to the default
+func (b *Bot) OnModel(c telebot.Context) error {
+	chat := c.Chat().ID
+	args := c.Args()
+	if len(args) == 0 {
+		model := b.settings.Get(chat).Model
+		if model == "" {
+			model = b.models.Default() + " (default)"
+		}
+		return c.Send(fmt.Sprintf("Model: %s\nLoaded: %s\nAvailable: %s",
+			model, strings.Join(b.models.Loaded(), ", "), strings.Join(b.choices, ", ")))
+	}
+
+	model := args[0]
+	if model == "default" {
+		model = ""
+	} else if !isInSet(model, b.choices) {
+		return c.Send(fmt.Sprintf("Model must be one of: %s", strings.Join(b.choices, ", ")))
+	}
+	if !b.isChatAdmin(c) {
+		return c.Send("Only chat admins can change the model")
+	}
+	b.settings.Update(chat, func(s *ChatSettings) {
+		s.Model = model
+	})
+	if model == "" {
+		return c.Send("Using the default model " + b.models.Default())
+	}
+	return c.Send("Using model " + model)
+}
+
+// OnFormat shows the output format of transcripts in the chat, or chooses
+// it with "/format <name>". Use "/format default" to go back to the default
+func (b *Bot) OnFormat(c telebot.Context) error {
+	chat := c.Chat().ID
+	args := c.Args()
+	if len(args) == 0 {
+		format := b.settings.Get(chat).Format
+		if format == "" {
+			format = b.defaultFormat() + " (default)"
+		}
+		return c.Send(fmt.Sprintf("Format: %s\nAvailable: %s", format, strings.Join(formats, ", ")))
+	}
+
+	format := strings.ToLower(args[0])
+	if format == "default" {
+		format = ""
+	} else if !isInSet(format, formats) {
+		return c.Send(fmt.Sprintf("Format must be one of: %s", strings.Join(formats, ", ")))
+	}
+	if !b.isChatAdmin(c) {
+		return c.Send("Only chat admins can change the format")
+	}
+	b.settings.Update(chat, func(s *ChatSettings) {
+		s.Format = format
+	})
+	if format == "" {
+		return c.Send("Using the default format " + b.defaultFormat())
+	}
+	return c.Send("Using format " + format)
+}
+
+// OnSpeakers chooses whether transcripts in the chat are labelled with the
+// speaker of each segment with "/speakers on" or "/speakers off"
+func (b *Bot) OnSpeakers(c telebot.Context) error {
+	args := c.Args()
+	if len(args) == 0 {
+		if b.params.speakers || b.settings.Get(c.Chat().ID).Speakers {
+			return c.Send("Speakers are labelled in transcripts")
+		}
+		return c.Send("Speakers are not labelled in transcripts")
+	} else if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+		return c.Send("Usage: /speakers on|off")
+	} else if !b.isChatAdmin(c) {
+		return c.Send("Only chat admins can change speaker labels")
+	}
+	speakers := args[0] == "on"
+	b.settings.Update(c.Chat().ID, func(s *ChatSettings) {
+		s.Speakers = speakers
+	})
+	if speakers || b.params.speakers {
+		return c.Send("Speakers are labelled in transcripts")
+	}
+	return c.Send("Speakers are not labelled in transcripts")
+}
+
+// OnChannels chooses whether each channel of stereo audio in the chat is
+// transcribed separately with "/channels on" or "/channels off", for call
+// recordings with one speaker per channel
+func (b *Bot) OnChannels(c telebot.Context) error {
+	args := c.Args()
+	if len(args) == 0 {
+		if b.params.channels || b.settings.Get(c.Chat().ID).Channels {
+			return c.Send("Channels of stereo audio are transcribed separately")
+		}
+		return c.Send("Stereo audio is mixed down before it is transcribed")
+	} else if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+		return c.Send("Usage: /channels on|off")
+	} else if !b.isChatAdmin(c) {
+		return c.Send("Only chat admins can change how channels are transcribed")
+	}
+	channels := args[0] == "on"
+	b.settings.Update(c.Chat().ID, func(s *ChatSettings) {
+		s.Channels = channels
+	})
+	if channels || b.params.channels {
+		return c.Send("Channels of stereo audio are transcribed separately")
+	}
+	return c.Send("Stereo audio is mixed down before it is transcribed")
+}
+
+// OnFilters shows the filters applied to audio in the chat before it is
+// transcribed. Turn a filter on or off with "/filters <name> on|off", choose
+// them all with "/filters <name>,<name>" or "/filters none", and go back to
+// the default with "/filters default"
+func (b *Bot) OnFilters(c telebot.Context) error {
+	chat := c.Chat().ID
+	args := c.Args()
+	if len(args) == 0 {
+		filters := b.filters(chat)
+		if filters == "" {
+			filters = FilterNone
+		}
+		if b.settings.Get(chat).Filters == "" {
+			filters += " (default)"
+		}
+		return c.Send(fmt.Sprintf("Filters: %s\nAvailable: %s", strings.ReplaceAll(filters, ",", ", "), strings.Join(filterNames, ", ")))
+	}
+
+	var list string
+	switch {
+	case len(args) == 1 && args[0] == "default":
+		list = ""
+	case len(args) == 2 && (args[1] == "on" || args[1] == "off"):
+		if !isInSet(args[0], filterNames) {
+			return c.Send(fmt.Sprintf("Filter must be one of: %s", strings.Join(filterNames, ", ")))
+		}
+		var selected []string
+		for _, name := range strings.Split(b.filters(chat), ",") {
+			if name != args[0] {
+				selected = append(selected, name)
+			}
+		}
+		if args[1] == "on" {
+			selected = append(selected, args[0])
+		}
+		list, _ = parseFilters(strings.Join(selected, ","))
+		if list == "" {
+			list = FilterNone
+		}
+	default:
+		var err error
+		if list, err = parseFilters(strings.Join(args, ",")); err != nil {
+			return c.Send("Usage: /filters <name> on|off, /filters <name>,<name>, /filters none or /filters default\n" + err.Error())
+		} else if list == "" {
+			list = FilterNone
+		}
+	}
+	if !b.isChatAdmin(c) {
+		return c.Send("Only chat admins can change the filters")
+	}
+	b.settings.Update(chat, func(s *ChatSettings) {
+		s.Filters = list
+	})
+	filters := b.filters(chat)
+	if filters == "" {
+		filters = FilterNone
+	}
+	if list == "" {
+		filters += " (default)"
+	}
+	return c.Send("Using filters " + strings.ReplaceAll(filters, ",", ", "))
+}
+
+// OnDefault swaps the default model at runtime with "/default <name>". The
+// new model is loaded before the old one is unloaded, so jobs keep running
+func (b *Bot) OnDefault(c telebot.Context) error {
+	if !b.isAdmin(c.Sender()) {
+		return c.Send("This command is only available to admins")
+	}
+	args := c.Args()
+	if len(args) != 1 {
+		return c.Send("Usage: /default <model>")
+	} else if !isInSet(args[0], b.choices) {
+		return c.Send(fmt.Sprintf("Model must be one of: %s", strings.Join(b.choices, ", ")))
+	}
+	if err := c.Send("Loading " + args[0] + "..."); err != nil {
+		return err
+	}
+	if err := b.models.SetDefault(args[0]); err != nil {
+		jobLogger(c).Error("setting default model", "model", args[0], "error", err)
+		return c.Send(err.Error())
+	}
+	jobLogger(c).Info("default model changed", "model", args[0])
+	return c.Send("Default model is now " + args[0])
+}
+
+// OnHistory lists the latest transcripts in the chat with "/history [n]"
+func (b *Bot) OnHistory(c telebot.Context) error {
+	if b.history == nil {
+		return c.Send("History is disabled")
+	}
+	n := 5
+	if args := c.Args(); len(args) > 0 {
+		if v, err := strconv.Atoi(args[0]); err != nil || v < 1 {
+			return c.Send("Usage: /history [count]")
+		} else if v < maxHistory {
+			n = v
+		} else {
+			n = maxHistory
+		}
+	}
+	records, err := b.history.Recent(c.Chat().ID, n)
+	if err != nil {
+		jobLogger(c).Error("reading history", "error", err)
+		return c.Send(err.Error())
+	}
+	if len(records) == 0 {
+		return c.Send("No transcripts yet")
+	}
+	return c.Send(formatRecords(records))
+}
+
+// OnSearch finds transcripts in the chat with "/search <query>"
+func (b *Bot) OnSearch(c telebot.Context) error {
+	if b.history == nil {
+		return c.Send("History is disabled")
+	}
+	query := strings.Join(c.Args(), " ")
+	if query == "" {
+		return c.Send("Usage: /search <words>")
+	}
+	records, err := b.history.Search(c.Chat().ID, query, maxHistory)
+	if err != nil {
+		jobLogger(c).Error("searching history", "error", err)
+		return c.Send(err.Error())
+	}
+	if len(records) == 0 {
+		return c.Send("Nothing found")
+	}
+	return c.Send(formatRecords(records))
+}
+
+// OnForget deletes every transcript of the sender from the history, and
+// their entries in the cache
+func (b *Bot) OnForget(c telebot.Context) error {
+	if b.history == nil {
+		return c.Send("History is disabled")
+	}
+	records, err := b.history.Forget(c.Sender().ID)
+	if err != nil {
+		jobLogger(c).Error("forgetting history", "error", err)
+		return c.Send(err.Error())
+	}
+	if b.cache != nil {
+		for _, record := range records {
+			b.cache.Remove(record.CacheKeys...)
+		}
+	}
+	jobLogger(c).Info("history forgotten", "count", len(records))
+	return c.Send(fmt.Sprintf("Deleted %d transcripts", len(records)))
+}
+
+// OnStats shows totals for the jobs since the bot started, with the state of
+// the models and the cache
+func (b *Bot) OnStats(c telebot.Context) error {
+	text := b.stats.String()
+	text += fmt.Sprintf("\nModels: %s (default %s, %d jobs pending)",
+		strings.Join(b.models.Loaded(), ", "), b.models.Default(), b.models.Pending())
+	if b.cache != nil {
+		stats := b.cache.Stats()
+		text += fmt.Sprintf("\nCache: %d hits, %d misses, %d entries", stats.Hits, stats.Misses, stats.Entries)
+	}
+	return c.Send(text)
+}
+
+// OnQueue shows the position of the sender's jobs in the queue, with an
+// estimate of when each will be done based on the measured real time factor
+func (b *Bot) OnQueue(c telebot.Context) error {
+	lines := []string{fmt.Sprintf("Queue: %d running, %d waiting", b.scheduler.Running(), b.scheduler.Waiting())}
+	positions := b.scheduler.Positions(c.Sender().ID, b.stats.RealTimeFactor())
+	if len(positions) == 0 {
+		lines = append(lines, "You have no jobs in the queue")
+	}
+	for _, position := range positions {
+		line := "Transcribing now"
+		if position.Position > 0 {
+			line = fmt.Sprintf("Position %d", position.Position)
+		}
+		if position.Length > 0 {
+			line += ", " + clock(position.Length) + " of audio"
+		}
+		if position.ETA > 0 {
+			line += ", done in about " + clock(position.ETA)
+		}
+		lines = append(lines, line)
+	}
+	return c.Send(strings.Join(lines, "\n"))
+}
+
+// OnTranslate shows the language transcripts in the chat are translated to,
+// or chooses it with "/translate <language>". Use "/translate off" to stop
+// translating them
+func (b *Bot) OnTranslate(c telebot.Context) error {
+	if b.translator == nil {
+		return c.Send("Translation is disabled")
+	}
+	chat := c.Chat().ID
+	args := c.Args()
+	if len(args) == 0 {
+		if target := b.settings.Get(chat).Translate; target != "" {
+			return c.Send("Transcripts are translated to " + target)
+		}
+		return c.Send("Transcripts are not translated")
+	}
+
+	target := strings.ToLower(args[0])
+	if target == "off" {
+		target = ""
+	} else if !isLanguage(target) {
+		return c.Send("Usage: /translate <language code>, for example /translate de, or /translate off")
+	} else if !b.isChatAdmin(c) {
+		return c.Send("Only chat admins can change translation")
+	}
+	b.settings.Update(chat, func(s *ChatSettings) {
+		s.Translate = target
+	})
+	if target == "" {
+		return c.Send("Transcripts are no longer translated")
+	}
+	return c.Send("Transcripts are translated to " + target)
+}
+
+// OnTranslateMenu adds a button for each language a transcript can be
+// translated to under it
+func (b *Bot) OnTranslateMenu(c telebot.Context) error {
+	id := c.Data()
+	if _, ok := b.replyWithID(c, id); !ok {
+		return nil
+	}
+	c.Respond()
+	languages := &telebot.ReplyMarkup{}
+	buttons := make([]telebot.Btn, 0, len(b.translations))
+	for _, lang := range b.translations {
+		buttons = append(buttons, languages.Data(lang, btnTranslateTo.Unique, lang, id))
+	}
+	languages.Inline(languages.Split(4, buttons)...)
+	markup := withoutLanguages(c.Message().ReplyMarkup)
+	markup.InlineKeyboard = append(markup.InlineKeyboard, languages.InlineKeyboard...)
+	return c.Edit(markup)
+}
+
+// OnTranslateTo translates the transcript of the reply a language button is
+// under, and removes the language buttons. The text of the stored transcript
+// is translated, without timestamps, speakers or the footer, so replies sent
+// as documents can be translated too
+func (b *Bot) OnTranslateTo(c telebot.Context) error {
+	target, id, _ := strings.Cut(c.Data(), "|")
+	if b.translator == nil || !isLanguage(target) {
+		return c.Respond()
+	}
+	reply, ok := b.replyWithID(c, id)
+	if !ok {
+		return nil
+	}
+	c.Respond(&telebot.CallbackResponse{Text: "Translating to " + target})
+	if err := c.Edit(withoutLanguages(c.Message().ReplyMarkup)); err != nil {
+		jobLogger(c).Warn("removing language buttons", "error", err)
+	}
+
+	text := reply.Transcript.Text()
+	if text == "" {
+		return c.Send("There is nothing to translate")
+	}
+	source := reply.Transcript.Language
+	if reply.Job.Translate {
+		source = "en"
+	}
+	return b.sendTranslation(c, text, source, target)
+}
+
+func (b *Bot) isAdmin(user *telebot.User) bool {
+	return user != nil && b.admins[user.ID]
+}
+
+// formatRecords returns a summary of each record with the start of its text
+func formatRecords(records []*Record) string {
+	var lines []string
+	for _, record := range records {
+		text := []rune(record.Text())
+		if len(text) > maxPreview {
+			text = append(text[:maxPreview], '…')
+		}
+		lines = append(lines, fmt.Sprintf("#%d %s, %s, %s\n%s",
+			record.ID, record.Created.Format("2006-01-02 15:04"), clock(record.Duration), record.Model, string(text)))
+	}
+	return strings.Join(lines, "\n\n")
+}
+
+// clock returns a duration as m:ss, or h:mm:ss when it is an hour or more
+func clock(d time.Duration) string {
+	d = d.Round(time.Second)
+	h, m, s := int(d/time.Hour), int(d/time.Minute)%60, int(d/time.Second)%60
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, s)
+	}
+	return fmt.Sprintf("%d:%02d", m, s)
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	// Packages
+	whisper "github.com/ggerganov/whisper.cpp/bindings/go/pkg/whisper"
+)
+
+// Output formats
+const (
+	FormatText       = "text"
+	FormatTimestamps = "timestamps"
+	FormatJSON       = "json"
+	FormatSRT        = "srt"
+	FormatVTT        = "vtt"
+	FormatASS        = "ass"
+)
+
+const (
+	// A pause between segments at least this long starts a new paragraph in
+	// the timestamps format, as does a paragraph reaching maxParagraph
+	paragraphPause = 2 * time.Second
+	maxParagraph   = time.Minute
+)
+
+var (
+	// The output formats which can be selected
+	formats = []string{FormatText, FormatTimestamps, FormatJSON, FormatSRT, FormatVTT, FormatASS}
+)
+
+type jsonTranscript struct {
+	Model      string        `json:"model,omitempty"`
+	Language   string        `json:"language"`
+	Duration   float64       `json:"duration"`
+	Text       string        `json:"text"`
+	Confidence Confidence    `json:"confidence"`
+	Segments   []jsonSegment `json:"segments"`
+}
+
+type jsonSegment struct {
+	Start      float64    `json:"start"`
+	End        float64    `json:"end"`
+	Text       string     `json:"text"`
+	Speaker    int        `json:"speaker,omitempty"`
+	Channel    int        `json:"channel,omitempty"`
+	Confidence Confidence `json:"confidence"`
+	Words      []jsonWord `json:"words"`
+}
+
+type jsonWord struct {
+	Text        string  `json:"text"`
+	Start       float64 `json:"start"`
+	End         float64 `json:"end"`
+	Probability float32 `json:"probability"`
+}
+
+// Format renders a transcript in one of the output formats
+func Format(t *Transcript, format string) ([]byte, error) {
+	switch format {
+	case FormatText, "":
+		if t.Labelled() {
+			return []byte(formatLabels(t, func(segment whisper.Segment) string {
+				return segment.Text
+			})), nil
+		}
+		return []byte(t.Text()), nil
+	case FormatTimestamps:
+		return []byte(formatTimestamps(t)), nil
+	case FormatJSON:
+		return formatJSON(t)
+	case FormatSRT:
+		return formatSRT(t), nil
+	case FormatVTT:
+		return formatVTT(t), nil
+	case FormatASS:
+		return formatASS(t), nil
+	default:
+		return nil, fmt.Errorf("unsupported output format: %q", format)
+	}
+}
+
+// formatJSON renders segments and words with timestamps in seconds
+func formatJSON(t *Transcript) ([]byte, error) {
+	result := jsonTranscript{
+		Model:      t.Model,
+		Language:   t.Language,
+		Duration:   t.Duration.Seconds(),
+		Text:       t.Text(),
+		Confidence: t.Confidence(),
+		Segments:   make([]jsonSegment, 0, len(t.Segments)),
+	}
+	for i, segment := range t.Segments {
+		s := newJSONSegment(segment, 0)
+		s.Speaker, s.Channel = t.Speaker(i), t.Channel(i)
+		result.Segments = append(result.Segments, s)
+	}
+	return json.MarshalIndent(result, "", "  ")
+}
+
+// newJSONSegment returns a segment with its words, with times moved later
+// by offset
+func newJSONSegment(segment whisper.Segment, offset time.Duration) jsonSegment {
+	words := segmentWords(segment)
+	s := jsonSegment{
+		Start:      (segment.Start + offset).Seconds(),
+		End:        (segment.End + offset).Seconds(),
+		Text:       segment.Text,
+		Confidence: segmentConfidence(segment),
+		Words:      make([]jsonWord, 0, len(words)),
+	}
+	for _, word := range words {
+		s.Words = append(s.Words, jsonWord{
+			Text:        word.Text,
+			Start:       (word.Start + offset).Seconds(),
+			End:         (word.End + offset).Seconds(),
+			Probability: word.P,
+		})
+	}
+	return s
+}
+
+// formatTimestamps renders the segments in paragraphs, each starting with
+// its time
+func formatTimestamps(t *Transcript) string {
+	return formatParagraphs(t.Segments, func(segment whisper.Segment) string {
+		return segment.Text
+	})
+}
+
+// formatParagraphs renders the segments in paragraphs separated by blank
+// lines, each starting with its time as mm:ss. Telegram makes the times
+// clickable in replies to media. The text function returns the text of a
+// segment
+func formatParagraphs(segments []whisper.Segment, text func(whisper.Segment) string) string {
+	var paragraphs []string
+	var texts []string
+	var start, end time.Duration
+	for i, segment := range segments {
+		if i > 0 && (segment.Start-end >= paragraphPause || segment.Start-start >= maxParagraph) {
+			paragraphs = append(paragraphs, timestamp(start)+" "+joinText(texts))
+			texts = texts[:0]
+		}
+		if len(texts) == 0 {
+			start = segment.Start
+		}
+		texts = append(texts, text(segment))
+		end = segment.End
+	}
+	if len(texts) > 0 {
+		paragraphs = append(paragraphs, timestamp(start)+" "+joinText(texts))
+	}
+	return strings.Join(paragraphs, "\n\n")
+}
+
+// formatLabels renders the segments in paragraphs separated by blank
+// lines, starting a new paragraph with the label of the speaker or channel
+// each time it changes. The text function returns the text of a segment
+func formatLabels(t *Transcript, text func(whisper.Segment) string) string {
+	var paragraphs []string
+	var texts []string
+	label := ""
+	for i, segment := range t.Segments {
+		if i > 0 && t.Label(i) != label {
+			paragraphs = append(paragraphs, label+": "+joinText(texts))
+			texts = texts[:0]
+		}
+		label = t.Label(i)
+		texts = append(texts, text(segment))
+	}
+	if len(texts) > 0 {
+		paragraphs = append(paragraphs, label+": "+joinText(texts))
+	}
+	return strings.Join(paragraphs, "\n\n")
+}
+
+// formatSRT renders one SubRip cue per segment, starting with the label of
+// its speaker or channel when they are known
+func formatSRT(t *Transcript) []byte {
+	var b strings.Builder
+	for i, segment := range t.Segments {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		text := strings.TrimSpace(segment.Text)
+		if label := t.Label(i); label != "" {
+			text = label + ": " + text
+		}
+		fmt.Fprintf(&b, "%d\n%s --> %s\n%s\n", i+1, srtTime(segment.Start), srtTime(segment.End), text)
+	}
+	return []byte(b.String())
+}
+
+// formatVTT renders one WebVTT cue per segment, with a timestamp tag before
+// each word so players can highlight words as they are spoken
+func formatVTT(t *Transcript) []byte {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+	for i, segment := range t.Segments {
+		fmt.Fprintf(&b, "\n%d\n%s --> %s\n", i+1, vttTime(segment.Start), vttTime(segment.End))
+		for j, word := range segmentWords(segment) {
+			if j > 0 {
+				b.WriteString(" ")
+			}
+			if word.Start > segment.Start {
+				fmt.Fprintf(&b, "<%s>", vttTime(word.Start))
+			}
+			fmt.Fprintf(&b, "<c>%s</c>", vttEscape(word.Text))
+		}
+		b.WriteString("\n")
+	}
+	return []byte(b.String())
+}
+
+// formatASS renders one ASS dialogue line per segment, with \k karaoke tags
+// holding the duration of each word in centiseconds
+func formatASS(t *Transcript) []byte {
+	var b strings.Builder
+	b.WriteString("[Script Info]\n")
+	b.WriteString("ScriptType: v4.00+\n")
+	b.WriteString("PlayResX: 384\n")
+	b.WriteString("PlayResY: 288\n\n")
+	b.WriteString("[V4+ Styles]\n")
+	b.WriteString("Format: Name, Fontname, Fontsize, PrimaryColour, SecondaryColour, OutlineColour, BackColour, Bold, Italic, Underline, StrikeOut, ScaleX, ScaleY, Spacing, Angle, BorderStyle, Outline, Shadow, Alignment, MarginL, MarginR, MarginV, Encoding\n")
+	b.WriteString("Style: Default,Arial,20,&H00FFFFFF,&H000000FF,&H00000000,&H00000000,0,0,0,0,100,100,0,0,1,2,0,2,10,10,10,1\n\n")
+	b.WriteString("[Events]\n")
+	b.WriteString("Format: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text\n")
+	for _, segment := range t.Segments {
+		var text strings.Builder
+		at := segment.Start
+		for i, word := range segmentWords(segment) {
+			if gap := centiseconds(word.Start - at); gap > 0 {
+				fmt.Fprintf(&text, "{\\k%d}", gap)
+			}
+			if i > 0 {
+				text.WriteString(" ")
+			}
+			fmt.Fprintf(&text, "{\\k%d}%s", centiseconds(word.End-word.Start), assEscape(word.Text))
+			at = word.End
+		}
+		fmt.Fprintf(&b, "Dialogue: 0,%s,%s,Default,,0,0,0,,%s\n", assTime(segment.Start), assTime(segment.End), text.String())
+	}
+	return []byte(b.String())
+}
+
+// vttTime formats a duration as hh:mm:ss.mmm
+func vttTime(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// timestamp formats a duration as mm:ss, or h:mm:ss from an hour
+func timestamp(d time.Duration) string {
+	s := int64(d / time.Second)
+	if s >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+	}
+	return fmt.Sprintf("%02d:%02d", s/60, s%60)
+}
+
+// srtTime formats a duration as hh:mm:ss,mmm
+func srtTime(d time.Duration) string {
+	return strings.Replace(vttTime(d), ".", ",", 1)
+}
+
+// assTime formats a duration as h:mm:ss.cc
+func assTime(d time.Duration) string {
+	cs := centiseconds(d)
+	return fmt.Sprintf("%d:%02d:%02d.%02d", cs/360000, cs/6000%60, cs/100%60, cs%100)
+}
+
+func centiseconds(d time.Duration) int64 {
+	return d.Milliseconds() / 10
+}
+
+func vttEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+func assEscape(s string) string {
+	return strings.NewReplacer("{", "(", "}", ")", "\\", "/", "\n", " ").Replace(s)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	// Packages
+	whisper "github.com/ggerganov/whisper.cpp/bindings/go/pkg/whisper"
+	fakewhisper "github.com/skrashevich/whisper.cpp-telegram/pkg/fake-whisper"
+	assert "github.com/stretchr/testify/assert"
+)
+
+func testTranscript() *Transcript {
+	return &Transcript{
+		Language: "en",
+		Duration: 5 * time.Second,
+		Segments: []whisper.Segment{
+			fakewhisper.Segment(0, 0, 2*time.Second, "And so my"),
+			fakewhisper.Segment(1, 2*time.Second, 5*time.Second, "fellow Americans"),
+		},
+	}
+}
+
+func Test_Format_000(t *testing.T) {
+	assert := assert.New(t)
+
+	data, err := Format(testTranscript(), FormatJSON)
+	assert.NoError(err)
+
+	var result jsonTranscript
+	assert.NoError(json.Unmarshal(data, &result))
+	assert.Equal("en", result.Language)
+	assert.Equal(5.0, result.Duration)
+	assert.InDelta(0.9, result.Confidence.Mean, 0.0001)
+	assert.InDelta(0.9, result.Confidence.Min, 0.0001)
+	if assert.Len(result.Segments, 2) {
+		words := result.Segments[1].Words
+		if assert.Len(words, 2) {
+			assert.Equal(jsonWord{Text: "fellow", Start: 2, End: 3, Probability: 0.9}, words[0])
+			assert.Equal(jsonWord{Text: "Americans", Start: 3, End: 5, Probability: 0.9}, words[1])
+		}
+	}
+}
+
+func Test_Format_001(t *testing.T) {
+	assert := assert.New(t)
+
+	data, err := Format(testTranscript(), FormatVTT)
+	assert.NoError(err)
+	lines := strings.Split(string(data), "\n")
+	assert.Equal("WEBVTT", lines[0])
+	assert.Contains(lines, "00:00:00.000 --> 00:00:02.000")
+	assert.Contains(lines, "<c>And</c> <00:00:00.666><c>so</c> <00:00:01.333><c>my</c>")
+	assert.Contains(lines, "00:00:02.000 --> 00:00:05.000")
+	assert.Contains(lines, "<c>fellow</c> <00:00:03.000><c>Americans</c>")
+}
+
+func Test_Format_002(t *testing.T) {
+	assert := assert.New(t)
+
+	data, err := Format(testTranscript(), FormatASS)
+	assert.NoError(err)
+	assert.Contains(string(data), "[Events]\n")
+	assert.Contains(string(data), "Dialogue: 0,0:00:00.00,0:00:02.00,Default,,0,0,0,,{\\k66}And {\\k66}so {\\k66}my\n")
+	assert.Contains(string(data), "Dialogue: 0,0:00:02.00,0:00:05.00,Default,,0,0,0,,{\\k100}fellow {\\k200}Americans\n")
+}
+
+func Test_Format_003(t *testing.T) {
+	assert := assert.New(t)
+
+	// Words are spread over the segment when there are no token timestamps
+	segment := whisper.Segment{
+		Start: time.Second, End: 3 * time.Second, Text: "one two",
+		Tokens: []whisper.Token{{Text: " one", P: 1}, {Text: " two", P: 1}},
+	}
+	words := segmentWords(segment)
+	if assert.Len(words, 2) {
+		assert.Equal(time.Second, words[0].Start)
+		assert.Equal(2*time.Second, words[0].End)
+		assert.Equal(3*time.Second, words[1].End)
+	}
+
+	_, err := Format(testTranscript(), "doc")
+	assert.Error(err)
+	data, err := Format(testTranscript(), FormatText)
+	assert.NoError(err)
+	assert.Equal("And so my fellow Americans", string(data))
+}
+
+func Test_Format_004(t *testing.T) {
+	assert := assert.New(t)
+
+	data, err := Format(testTranscript(), FormatSRT)
+	assert.NoError(err)
+	assert.Equal("1\n00:00:00,000 --> 00:00:02,000\nAnd so my\n\n2\n00:00:02,000 --> 00:00:05,000\nfellow Americans\n", string(data))
+
+	data, err = Format(testTranscript(), FormatTimestamps)
+	assert.NoError(err)
+	assert.Equal("00:00 And so my fellow Americans", string(data))
+
+	// Long pauses start paragraphs
+	segments := []whisper.Segment{
+		fakewhisper.Segment(0, 0, 2*time.Second, " One"),
+		fakewhisper.Segment(1, 3*time.Second, 5*time.Second, "two "),
+		fakewhisper.Segment(2, 7*time.Second, 9*time.Second, "three"),
+		fakewhisper.Segment(3, time.Hour+5*time.Second, time.Hour+6*time.Second, "four"),
+	}
+	assert.Equal("00:00 One two\n\n00:07 three\n\n1:00:05 four", formatTimestamps(&Transcript{Segments: segments}))
+}
+
+func Test_Format_005(t *testing.T) {
+	assert := assert.New(t)
+	transcript := testTranscript()
+	transcript.Segments = append(transcript.Segments, fakewhisper.Segment(2, 5*time.Second, 6*time.Second, "Ask not"))
+	transcript.Speakers = []int{1, 1, 2}
+
+	// Paragraphs start with the speaker when it changes
+	data, err := Format(transcript, FormatText)
+	assert.NoError(err)
+	assert.Equal("Speaker 1: And so my fellow Americans\n\nSpeaker 2: Ask not", string(data))
+
+	data, err = Format(transcript, FormatSRT)
+	assert.NoError(err)
+	assert.Contains(string(data), "00:00:02,000 --> 00:00:05,000\nSpeaker 1: fellow Americans\n")
+	assert.Contains(string(data), "00:00:05,000 --> 00:00:06,000\nSpeaker 2: Ask not\n")
+
+	data, err = Format(transcript, FormatJSON)
+	assert.NoError(err)
+	var result jsonTranscript
+	assert.NoError(json.Unmarshal(data, &result))
+	assert.Equal(1, result.Segments[0].Speaker)
+	assert.Equal(2, result.Segments[2].Speaker)
+
+	// Speakers are left out when they were not detected
+	data, err = Format(testTranscript(), FormatJSON)
+	assert.NoError(err)
+	assert.NotContains(string(data), "speaker")
+}
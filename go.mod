@@ -1,23 +1,37 @@
 module github.com/skrashevich/whisper.cpp-telegram
 
-go 1.20
+go 1.21
 
 require (
 	github.com/ggerganov/whisper.cpp/bindings/go v0.0.0-20230528233858-d7c936b44a80
+	github.com/go-audio/audio v1.0.0
 	github.com/go-audio/wav v1.1.0
+	github.com/gorilla/websocket v1.5.3
 	github.com/imdario/mergo v0.3.16
+	github.com/prometheus/client_golang v1.19.1
+	github.com/stretchr/testify v1.8.1
 	github.com/u2takey/ffmpeg-go v0.4.1
-	gopkg.in/telebot.v3 v3.1.3
+	go.etcd.io/bbolt v1.3.9
+	gopkg.in/telebot.v3 v3.2.1
 )
 
 // require github.com/crayonwow/telegram-bot-api v0.0.0-20221028165247-f06b46d75030
 
 require (
 	github.com/aws/aws-sdk-go v1.38.20 // indirect
-	github.com/go-audio/audio v1.0.0 // indirect
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/go-audio/riff v1.0.0 // indirect
 	github.com/jmespath/go-jmespath v0.4.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/prometheus/client_model v0.5.0 // indirect
+	github.com/prometheus/common v0.48.0 // indirect
+	github.com/prometheus/procfs v0.12.0 // indirect
 	github.com/u2takey/go-utils v0.3.1 // indirect
+	golang.org/x/sys v0.17.0 // indirect
+	google.golang.org/protobuf v1.33.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
 
 replace github.com/ggerganov/whisper.cpp/bindings/go => ./pkg/whisper
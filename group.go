@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/telebot.v3"
+)
+
+// Group modes, which choose the media transcribed in group chats
+const (
+	// Transcribe all media
+	GroupAuto = "auto"
+
+	// Transcribe media when asked by replying to it with a mention of the
+	// bot or /transcribe
+	GroupMention = "mention"
+
+	// Transcribe media from chat admins, or when they ask for it
+	GroupAdmins = "admins"
+
+	// Transcribe nothing
+	GroupOff = "off"
+)
+
+var (
+	// The group modes which can be selected
+	groupModes = []string{GroupAuto, GroupMention, GroupAdmins, GroupOff}
+)
+
+// replyContext is a telebot context which sends messages about a job with
+// options, so they reply to the media in its forum topic
+type replyContext struct {
+	telebot.Context
+	options *telebot.SendOptions
+}
+
+// Send sends a message with the options of the job. The options go first, as
+// telebot replaces the options given before send options
+func (c *replyContext) Send(what interface{}, opts ...interface{}) error {
+	return c.Context.Send(what, append([]interface{}{c.options}, opts...)...)
+}
+
+// OnTranscribe transcribes the media which a "/transcribe" command replies to
+func (b *Bot) OnTranscribe(c telebot.Context) error {
+	media := c.Message().ReplyTo
+	kind, file, length := mediaFile(media)
+	if file == nil {
+		return c.Send("Reply to a voice, audio or video message with /transcribe to transcribe it")
+	}
+	if !b.allowed(c, true) {
+		return nil
+	}
+	jobLogger(c).Info("asked to transcribe media", "kind", kind, "file", file.FileID, "size", file.FileSize, "length", length)
+	return b.process(c, media, file, length)
+}
+
+// OnText transcribes the media which a message mentioning the bot replies to
+func (b *Bot) OnText(c telebot.Context) error {
+	msg := c.Message()
+	if b.username == "" || !strings.Contains(strings.ToLower(msg.Text), "@"+strings.ToLower(b.username)) {
+		return nil
+	}
+	kind, file, length := mediaFile(msg.ReplyTo)
+	if file == nil || !b.allowed(c, true) {
+		return nil
+	}
+	jobLogger(c).Info("mentioned for media", "kind", kind, "file", file.FileID, "size", file.FileSize, "length", length)
+	return b.process(c, msg.ReplyTo, file, length)
+}
+
+// OnGroup shows the group mode of the chat, or chooses it with
+// "/group <mode>". Only chat admins can choose the mode
+func (b *Bot) OnGroup(c telebot.Context) error {
+	chat := c.Chat().ID
+	args := c.Args()
+	if len(args) == 0 {
+		settings := b.settings.Get(chat)
+		mode := settings.Group
+		if mode == "" {
+			mode = b.groupMode + " (default)"
+		}
+		replies := "with a notification"
+		if settings.Silent {
+			replies = "silent"
+		}
+		return c.Send(fmt.Sprintf("Group mode: %s\nReplies: %s\nAvailable: %s", mode, replies, strings.Join(groupModes, ", ")))
+	}
+
+	mode := strings.ToLower(args[0])
+	if mode == "default" {
+		mode = ""
+	} else if !isInSet(mode, groupModes) {
+		return c.Send(fmt.Sprintf("Group mode must be one of: %s", strings.Join(groupModes, ", ")))
+	}
+	if !b.isChatAdmin(c) {
+		return c.Send("Only chat admins can change the group mode")
+	}
+	b.settings.Update(chat, func(s *ChatSettings) {
+		s.Group = mode
+	})
+	jobLogger(c).Info("group mode changed", "mode", mode)
+	if mode == "" {
+		return c.Send("Using the default group mode " + b.groupMode)
+	}
+	return c.Send("Using group mode " + mode)
+}
+
+// OnSilent chooses whether transcripts in the chat are sent without a
+// notification with "/silent on" or "/silent off"
+func (b *Bot) OnSilent(c telebot.Context) error {
+	args := c.Args()
+	if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+		return c.Send("Usage: /silent on|off")
+	}
+	if !b.isChatAdmin(c) {
+		return c.Send("Only chat admins can change how transcripts are sent")
+	}
+	silent := args[0] == "on"
+	b.settings.Update(c.Chat().ID, func(s *ChatSettings) {
+		s.Silent = silent
+	})
+	if silent {
+		return c.Send("Transcripts are sent without a notification")
+	}
+	return c.Send("Transcripts are sent with a notification")
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// PRIVATE METHODS
+
+// allowed returns true when media in the chat should be transcribed. The
+// explicit flag is set when a user asked for the media to be transcribed
+func (b *Bot) allowed(c telebot.Context, explicit bool) bool {
+	chat := c.Chat()
+	if chat.Type != telebot.ChatGroup && chat.Type != telebot.ChatSuperGroup {
+		return true
+	}
+	mode := b.settings.Get(chat.ID).Group
+	if mode == "" {
+		mode = b.groupMode
+	}
+	switch mode {
+	case GroupAuto:
+		return true
+	case GroupMention:
+		return explicit
+	case GroupAdmins:
+		return b.isChatAdmin(c)
+	default:
+		return false
+	}
+}
+
+// isChatAdmin returns true when the sender is an admin of the chat or the
+// bot. Everyone is an admin of a private chat
+func (b *Bot) isChatAdmin(c telebot.Context) bool {
+	chat, sender := c.Chat(), c.Sender()
+	switch {
+	case b.isAdmin(sender):
+		return true
+	case chat.Type == telebot.ChatPrivate:
+		return true
+	case b.telegram == nil || sender == nil:
+		return false
+	}
+	member, err := b.telegram.ChatMemberOf(chat, sender)
+	if err != nil {
+		jobLogger(c).Warn("getting chat member", "error", err)
+		return false
+	}
+	return member.Role == telebot.Creator || member.Role == telebot.Administrator
+}
+
+// sendOptions returns the options of messages about a job, which reply to
+// the media in its forum topic, without a notification when the chat has
+// chosen silent replies
+func (b *Bot) sendOptions(job *Job) *telebot.SendOptions {
+	return &telebot.SendOptions{
+		ReplyTo:             job.message(),
+		ThreadID:            job.Thread,
+		AllowWithoutReply:   true,
+		DisableNotification: b.settings.Get(job.Chat).Silent,
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"testing"
+
+	// Packages
+	assert "github.com/stretchr/testify/assert"
+	"gopkg.in/telebot.v3"
+)
+
+func Test_Group_000(t *testing.T) {
+	assert := assert.New(t)
+	bot := newTestBot(t, "Hello", "world")
+	bot.telegram = &fakeMessenger{admins: map[string]bool{"7": true}}
+	bot.username = "WhisperBot"
+	group := &telebot.Chat{ID: -100, Type: telebot.ChatSuperGroup}
+	voice := func(user int64) *fakeContext {
+		return newFakeContext(&telebot.Message{
+			ID:     10,
+			Chat:   group,
+			Sender: &telebot.User{ID: user},
+			Voice:  &telebot.Voice{File: telebot.File{FileID: "voice"}},
+		})
+	}
+	command := func(user int64, payload string) *fakeContext {
+		return newFakeContext(&telebot.Message{Chat: group, Sender: &telebot.User{ID: user}, Payload: payload})
+	}
+
+	// All media is transcribed by default
+	c := voice(5)
+	assert.NoError(bot.OnMedia(c))
+	assert.Len(c.sent, 1)
+
+	// Only chat admins change the mode
+	c = command(5, "mention")
+	assert.NoError(bot.OnGroup(c))
+	assert.Equal([]interface{}{"Only chat admins can change the group mode"}, c.sent)
+	c = command(7, "mention")
+	assert.NoError(bot.OnGroup(c))
+	assert.Equal([]interface{}{"Using group mode mention"}, c.sent)
+	c = command(5, "")
+	assert.NoError(bot.OnGroup(c))
+	assert.Equal([]interface{}{"Group mode: mention\nReplies: with a notification\nAvailable: auto, mention, admins, off"}, c.sent)
+
+	// Media is transcribed when the bot is asked
+	c = voice(5)
+	assert.NoError(bot.OnMedia(c))
+	assert.Empty(c.sent)
+	c = newFakeContext(&telebot.Message{ID: 11, Chat: group, Sender: &telebot.User{ID: 5}, Text: "@whisperbot what was that?", ReplyTo: voice(5).msg})
+	assert.NoError(bot.OnText(c))
+	if assert.Len(c.sent, 1) && assert.NotNil(c.options) {
+		assert.Equal(10, c.options.ReplyTo.ID)
+	}
+	c = newFakeContext(&telebot.Message{ID: 11, Chat: group, Sender: &telebot.User{ID: 5}, Text: "@other_bot hi", ReplyTo: voice(5).msg})
+	assert.NoError(bot.OnText(c))
+	assert.Empty(c.sent)
+	c = command(5, "")
+	assert.NoError(bot.OnTranscribe(c))
+	assert.Equal([]interface{}{"Reply to a voice, audio or video message with /transcribe to transcribe it"}, c.sent)
+	c = command(5, "")
+	c.msg.ReplyTo = voice(5).msg
+	assert.NoError(bot.OnTranscribe(c))
+	assert.Len(c.sent, 1)
+
+	// Only media from admins, or which admins ask for, is transcribed
+	assert.NoError(bot.OnGroup(command(7, "admins")))
+	c = voice(5)
+	assert.NoError(bot.OnMedia(c))
+	assert.Empty(c.sent)
+	c = voice(7)
+	assert.NoError(bot.OnMedia(c))
+	assert.Len(c.sent, 1)
+
+	// Nothing is transcribed
+	assert.NoError(bot.OnGroup(command(7, "off")))
+	c = command(7, "")
+	c.msg.ReplyTo = voice(5).msg
+	assert.NoError(bot.OnTranscribe(c))
+	assert.Empty(c.sent)
+
+	// Private chats are not affected
+	bot.groupMode = GroupOff
+	c = newFakeContext(&telebot.Message{Voice: &telebot.Voice{File: telebot.File{FileID: "voice"}}})
+	assert.NoError(bot.OnMedia(c))
+	assert.Len(c.sent, 1)
+}
+
+func Test_Group_001(t *testing.T) {
+	assert := assert.New(t)
+	bot := newTestBot(t, "Hello", "world")
+	bot.telegram = &fakeMessenger{admins: map[string]bool{"7": true}}
+	group := &telebot.Chat{ID: -100, Type: telebot.ChatSuperGroup}
+
+	c := newFakeContext(&telebot.Message{Chat: group, Sender: &telebot.User{ID: 5}, Payload: "on"})
+	assert.NoError(bot.OnSilent(c))
+	assert.Equal([]interface{}{"Only chat admins can change how transcripts are sent"}, c.sent)
+	c = newFakeContext(&telebot.Message{Chat: group, Sender: &telebot.User{ID: 7}, Payload: "on"})
+	assert.NoError(bot.OnSilent(c))
+	assert.Equal([]interface{}{"Transcripts are sent without a notification"}, c.sent)
+
+	// Transcripts reply to the media in its topic, without a notification
+	c = newFakeContext(&telebot.Message{
+		ID:           10,
+		Chat:         group,
+		Sender:       &telebot.User{ID: 5},
+		ThreadID:     3,
+		TopicMessage: true,
+		Voice:        &telebot.Voice{File: telebot.File{FileID: "voice"}},
+	})
+	assert.NoError(bot.OnMedia(c))
+	if assert.Len(c.sent, 1) && assert.NotNil(c.options) {
+		assert.Equal(10, c.options.ReplyTo.ID)
+		assert.Equal(3, c.options.ThreadID)
+		assert.True(c.options.DisableNotification)
+		assert.True(c.options.AllowWithoutReply)
+	}
+}
+
+func Test_Group_002(t *testing.T) {
+	assert := assert.New(t)
+	bot := newTestBot(t, "Hello")
+	bot.telegram = &fakeMessenger{admins: map[string]bool{"7": true}}
+	bot.translator = fakeTranslator{}
+	group := &telebot.Chat{ID: -100, Type: telebot.ChatSuperGroup}
+
+	// Only chat admins change the settings of a group
+	for _, test := range []struct {
+		handler func(telebot.Context) error
+		payload string
+		refused string
+	}{
+		{bot.OnModel, "ggml-base", "Only chat admins can change the model"},
+		{bot.OnFormat, FormatSRT, "Only chat admins can change the format"},
+		{bot.OnSpeakers, "on", "Only chat admins can change speaker labels"},
+		{bot.OnChannels, "on", "Only chat admins can change how channels are transcribed"},
+		{bot.OnFilters, FilterNone, "Only chat admins can change the filters"},
+		{bot.OnTranslate, "de", "Only chat admins can change translation"},
+	} {
+		c := newFakeContext(&telebot.Message{Chat: group, Sender: &telebot.User{ID: 5}, Payload: test.payload})
+		assert.NoError(test.handler(c))
+		assert.Equal([]interface{}{test.refused}, c.sent)
+		assert.Equal(ChatSettings{}, bot.settings.Get(group.ID))
+		c = newFakeContext(&telebot.Message{Chat: group, Sender: &telebot.User{ID: 7}, Payload: test.payload})
+		assert.NoError(test.handler(c))
+		assert.NotEqual([]interface{}{test.refused}, c.sent)
+		assert.NotEqual(ChatSettings{}, bot.settings.Get(group.ID))
+		bot.settings.Update(group.ID, func(s *ChatSettings) { *s = ChatSettings{} })
+	}
+
+	// Anyone can see the settings
+	c := newFakeContext(&telebot.Message{Chat: group, Sender: &telebot.User{ID: 5}})
+	assert.NoError(bot.OnFormat(c))
+	assert.Len(c.sent, 1)
+	assert.NotContains(c.sent[0], "Only chat admins")
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	// Packages
+	"gopkg.in/telebot.v3"
+)
+
+// Health tracks whether telegram is being polled and the default model is
+// loaded, for the /healthz and /readyz endpoints
+type Health struct {
+	sync.Mutex
+	models  *ModelManager
+	started time.Time
+	polled  time.Time
+	err     error
+	closing bool
+
+	// Polling is unhealthy when telegram has not been reached for this long
+	stale time.Duration
+}
+
+// NewHealth returns health checks for the models. Polling is considered
+// stale when telegram has not answered for longer than stale
+func NewHealth(models *ModelManager, stale time.Duration) *Health {
+	return &Health{models: models, started: time.Now(), stale: stale}
+}
+
+// Polled records a successful poll of telegram
+func (h *Health) Polled() {
+	h.Lock()
+	defer h.Unlock()
+	h.polled, h.err = time.Now(), nil
+}
+
+// PollFailed records a failed poll of telegram
+func (h *Health) PollFailed(err error) {
+	h.Lock()
+	defer h.Unlock()
+	h.err = err
+}
+
+// Live returns an error when telegram has not been reached recently
+func (h *Health) Live() error {
+	h.Lock()
+	defer h.Unlock()
+	last := h.polled
+	if last.IsZero() {
+		last = h.started
+	}
+	if since := time.Since(last); since > h.stale {
+		if h.err != nil {
+			return fmt.Errorf("telegram not reached for %v: %w", since.Truncate(time.Second), h.err)
+		}
+		return fmt.Errorf("telegram not reached for %v", since.Truncate(time.Second))
+	}
+	return nil
+}
+
+// Stopping records that the bot is shutting down, which makes it unready
+func (h *Health) Stopping() {
+	h.Lock()
+	defer h.Unlock()
+	h.closing = true
+}
+
+// Ready returns an error unless telegram has been polled and the default
+// model is loaded, or when shutting down
+func (h *Health) Ready() error {
+	if err := h.Live(); err != nil {
+		return err
+	}
+	h.Lock()
+	polled, closing := !h.polled.IsZero(), h.closing
+	h.Unlock()
+	if closing {
+		return fmt.Errorf("shutting down")
+	} else if !polled {
+		return fmt.Errorf("telegram not polled yet")
+	}
+	if model := h.models.Default(); !isInSet(model, h.models.Loaded()) {
+		return fmt.Errorf("model %s not loaded", model)
+	}
+	return nil
+}
+
+// Register adds the /healthz and /readyz handlers to a mux
+func (h *Health) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", healthHandler(h.Live))
+	mux.HandleFunc("/readyz", healthHandler(h.Ready))
+}
+
+func healthHandler(check func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		status := map[string]string{"status": "ok"}
+		if err := check(); err != nil {
+			status = map[string]string{"status": "unavailable", "error": err.Error()}
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(status)
+	}
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// POLLER
+
+// Poller is a telebot.LongPoller which reports each poll to the health
+// checks. The long poller only passes on updates, so polls are seen through
+// the HTTP client of the bot, which must be the one returned by Client
+type Poller struct {
+	*telebot.LongPoller
+
+	health *Health
+}
+
+// pollTransport reports the result of each request for updates to the
+// health checks
+type pollTransport struct {
+	next   http.RoundTripper
+	health *Health
+}
+
+// NewPoller returns a long poller which waits up to timeout for updates
+func NewPoller(health *Health, timeout time.Duration) *Poller {
+	return &Poller{LongPoller: &telebot.LongPoller{Timeout: timeout}, health: health}
+}
+
+// Client returns the HTTP client for the bot. Its timeout is the one telebot
+// uses by default
+func (p *Poller) Client() *http.Client {
+	return &http.Client{
+		Timeout:   time.Minute,
+		Transport: &pollTransport{next: http.DefaultTransport, health: p.health},
+	}
+}
+
+// RoundTrip sends a request, and records whether it reached telegram when
+// it asks for updates. Failed polls return after a second, so a network
+// outage does not spin the long poller
+func (t *pollTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if !strings.HasSuffix(req.URL.Path, "/getUpdates") {
+		return resp, err
+	}
+	if err == nil && resp.StatusCode == http.StatusOK {
+		t.health.Polled()
+		return resp, nil
+	} else if err == nil {
+		t.health.PollFailed(fmt.Errorf("telegram: %s", resp.Status))
+	} else {
+		t.health.PollFailed(err)
+	}
+	select {
+	case <-req.Context().Done():
+	case <-time.After(time.Second):
+	}
+	return resp, err
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	// Packages
+	assert "github.com/stretchr/testify/assert"
+	"gopkg.in/telebot.v3"
+)
+
+func Test_Health_000(t *testing.T) {
+	assert := assert.New(t)
+	models, _ := newTestModels(t)
+	health := NewHealth(models, time.Minute)
+	mux := http.NewServeMux()
+	health.Register(mux)
+	get := func(path string) (int, map[string]string) {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		var status map[string]string
+		assert.NoError(json.Unmarshal(w.Body.Bytes(), &status))
+		return w.Code, status
+	}
+
+	// Live at startup, but not ready until polled with the model loaded
+	code, _ := get("/healthz")
+	assert.Equal(http.StatusOK, code)
+	code, status := get("/readyz")
+	assert.Equal(http.StatusServiceUnavailable, code)
+	assert.Equal("telegram not polled yet", status["error"])
+	health.Polled()
+	code, status = get("/readyz")
+	assert.Equal(http.StatusServiceUnavailable, code)
+	assert.Equal("model ggml-tiny not loaded", status["error"])
+	_, release, err := models.Acquire("")
+	assert.NoError(err)
+	release()
+	code, status = get("/readyz")
+	assert.Equal(http.StatusOK, code)
+	assert.Equal("ok", status["status"])
+
+	// Not ready once shutting down, but still live
+	health.Stopping()
+	code, status = get("/readyz")
+	assert.Equal(http.StatusServiceUnavailable, code)
+	assert.Equal("shutting down", status["error"])
+	code, _ = get("/healthz")
+	assert.Equal(http.StatusOK, code)
+
+	// Neither when telegram has not been reached for a while
+	health.PollFailed(errors.New("connection refused"))
+	health.polled = time.Now().Add(-2 * time.Minute)
+	code, status = get("/healthz")
+	assert.Equal(http.StatusServiceUnavailable, code)
+	assert.Regexp(`^telegram not reached for 2m0s: connection refused$`, status["error"])
+	code, _ = get("/readyz")
+	assert.Equal(http.StatusServiceUnavailable, code)
+}
+
+func Test_Health_001(t *testing.T) {
+	assert := assert.New(t)
+
+	// Telegram returns one update, then fails
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var params map[string]string
+		assert.NoError(json.NewDecoder(r.Body).Decode(&params))
+		calls++
+		if calls == 1 {
+			assert.Equal("1", params["offset"])
+			fmt.Fprint(w, `{"ok":true,"result":[{"update_id":7,"message":{"message_id":1,"text":"hi"}}]}`)
+		} else {
+			assert.Equal("8", params["offset"])
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `{"ok":false,"error_code":500,"description":"Internal Server Error"}`)
+		}
+	}))
+	defer server.Close()
+	health := NewHealth(nil, time.Minute)
+	poller := NewPoller(health, 0)
+	bot, err := telebot.NewBot(telebot.Settings{URL: server.URL, Token: "token", Offline: true, Client: poller.Client(), OnError: func(error, telebot.Context) {}})
+	assert.NoError(err)
+	dest, stop := make(chan telebot.Update, 1), make(chan struct{})
+	go poller.Poll(bot, dest, stop)
+	update := <-dest
+	assert.Equal(7, update.ID)
+	assert.Equal("hi", update.Message.Text)
+	assert.Eventually(func() bool {
+		health.Lock()
+		defer health.Unlock()
+		return !health.polled.IsZero() && health.err != nil
+	}, time.Second, 10*time.Millisecond)
+	close(stop)
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+	"unicode"
+
+	// Packages
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	bucketRecords = []byte("records")
+	bucketChats   = []byte("chats")
+	bucketUsers   = []byte("users")
+	bucketIndex   = []byte("index")
+)
+
+// ErrRecordNotFound is returned when a record does not exist in the history
+var ErrRecordNotFound = errors.New("record not found")
+
+// History stores transcribed jobs in a bolt database, with a full text index
+// of the transcripts in each chat
+type History struct {
+	db *bolt.DB
+}
+
+// Record is a transcribed job
+type Record struct {
+	ID       uint64          `json:"id"`
+	Chat     int64           `json:"chat"`
+	User     int64           `json:"user"`
+	Message  int             `json:"message"`
+	Model    string          `json:"model"`
+	Language string          `json:"language"`
+	Duration time.Duration   `json:"duration"`
+	Elapsed  time.Duration   `json:"elapsed"`
+	Created  time.Time       `json:"created"`
+	Segments []RecordSegment `json:"segments"`
+
+	// Keys of the cache entries holding the transcript
+	CacheKeys []string `json:"cache_keys,omitempty"`
+}
+
+// RecordSegment is a segment of a transcript in the history
+type RecordSegment struct {
+	Start time.Duration `json:"start"`
+	End   time.Duration `json:"end"`
+	Text  string        `json:"text"`
+}
+
+// OpenHistory opens or creates the history database at path
+func OpenHistory(path string) (*History, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketRecords, bucketChats, bucketUsers, bucketIndex} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &History{db: db}, nil
+}
+
+// NewRecord returns a record for a transcript
+func NewRecord(chat, user int64, message int, transcript *Transcript, elapsed time.Duration) *Record {
+	record := &Record{
+		Chat:      chat,
+		User:      user,
+		Message:   message,
+		Model:     transcript.Model,
+		Language:  transcript.Language,
+		Duration:  transcript.Duration,
+		Elapsed:   elapsed,
+		Created:   time.Now(),
+		Segments:  make([]RecordSegment, 0, len(transcript.Segments)),
+		CacheKeys: transcript.CacheKeys,
+	}
+	for _, segment := range transcript.Segments {
+		record.Segments = append(record.Segments, RecordSegment{
+			Start: segment.Start,
+			End:   segment.End,
+			Text:  segment.Text,
+		})
+	}
+	return record
+}
+
+// Close closes the database
+func (h *History) Close() error {
+	return h.db.Close()
+}
+
+// Add stores a record, setting its ID
+func (h *History) Add(record *Record) error {
+	return h.db.Update(func(tx *bolt.Tx) error {
+		id, err := tx.Bucket(bucketRecords).NextSequence()
+		if err != nil {
+			return err
+		}
+		record.ID = id
+		return putRecord(tx, record)
+	})
+}
+
+// Replace stores a record in place of the record with the same ID, or
+// returns ErrRecordNotFound if it has been deleted. The cache keys of the
+// replaced record are kept, so their entries are still removed by Forget
+func (h *History) Replace(record *Record) error {
+	return h.db.Update(func(tx *bolt.Tx) error {
+		old, err := getRecord(tx, record.ID)
+		if err != nil {
+			return err
+		}
+		for _, key := range old.CacheKeys {
+			if !isInSet(key, record.CacheKeys) {
+				record.CacheKeys = append(record.CacheKeys, key)
+			}
+		}
+		if err := deleteRecord(tx, record.ID); err != nil {
+			return err
+		}
+		return putRecord(tx, record)
+	})
+}
+
+// Delete deletes a record, or returns ErrRecordNotFound if it does not exist
+func (h *History) Delete(id uint64) error {
+	return h.db.Update(func(tx *bolt.Tx) error {
+		return deleteRecord(tx, id)
+	})
+}
+
+// Get returns a record by ID
+func (h *History) Get(id uint64) (*Record, error) {
+	var record *Record
+	err := h.db.View(func(tx *bolt.Tx) error {
+		var err error
+		record, err = getRecord(tx, id)
+		return err
+	})
+	return record, err
+}
+
+// Recent returns up to n records from a chat, newest first
+func (h *History) Recent(chat int64, n int) ([]*Record, error) {
+	var result []*Record
+	err := h.db.View(func(tx *bolt.Tx) error {
+		members := tx.Bucket(bucketChats).Bucket(itob(uint64(chat)))
+		if members == nil {
+			return nil
+		}
+		c := members.Cursor()
+		for k, _ := c.Last(); k != nil && len(result) < n; k, _ = c.Prev() {
+			record, err := getRecord(tx, btoi(k))
+			if err != nil {
+				return err
+			}
+			result = append(result, record)
+		}
+		return nil
+	})
+	return result, err
+}
+
+// Search returns up to n records from a chat which contain every word of the
+// query, newest first. Words match case-insensitively, and the last word of
+// the query also matches as a prefix
+func (h *History) Search(chat int64, query string, n int) ([]*Record, error) {
+	words := tokenize(query)
+	if len(words) == 0 {
+		return nil, nil
+	}
+	var result []*Record
+	err := h.db.View(func(tx *bolt.Tx) error {
+		var matches map[uint64]bool
+		for i, word := range words {
+			ids := searchIndex(tx.Bucket(bucketIndex), chat, word, i == len(words)-1)
+			if matches != nil {
+				for id := range matches {
+					if !ids[id] {
+						delete(matches, id)
+					}
+				}
+			} else {
+				matches = ids
+			}
+		}
+
+		// Walk the chat newest first, so results are in order
+		members := tx.Bucket(bucketChats).Bucket(itob(uint64(chat)))
+		if members == nil || len(matches) == 0 {
+			return nil
+		}
+		c := members.Cursor()
+		for k, _ := c.Last(); k != nil && len(result) < n; k, _ = c.Prev() {
+			if !matches[btoi(k)] {
+				continue
+			}
+			record, err := getRecord(tx, btoi(k))
+			if err != nil {
+				return err
+			}
+			result = append(result, record)
+		}
+		return nil
+	})
+	return result, err
+}
+
+// SearchUser returns up to n records of the media a user sent to any chat,
+// whoever asked for them, which contain every word of the query, newest
+// first, after skipping the first skip matches. Words match as for Search,
+// and an empty query matches every record
+func (h *History) SearchUser(user int64, query string, skip, n int) ([]*Record, error) {
+	words := tokenize(query)
+	var result []*Record
+	err := h.db.View(func(tx *bolt.Tx) error {
+		members := tx.Bucket(bucketUsers).Bucket(itob(uint64(user)))
+		if members == nil {
+			return nil
+		}
+		c := members.Cursor()
+		for k, _ := c.Last(); k != nil && len(result) < n; k, _ = c.Prev() {
+			record, err := getRecord(tx, btoi(k))
+			if err != nil {
+				return err
+			}
+			if !matchWords(tokenize(record.Text()), words) {
+				continue
+			} else if skip > 0 {
+				skip--
+				continue
+			}
+			result = append(result, record)
+		}
+		return nil
+	})
+	return result, err
+}
+
+// Forget deletes every record of a user and returns the records deleted
+func (h *History) Forget(user int64) ([]*Record, error) {
+	var result []*Record
+	err := h.db.Update(func(tx *bolt.Tx) error {
+		members := tx.Bucket(bucketUsers).Bucket(itob(uint64(user)))
+		if members == nil {
+			return nil
+		}
+		var ids []uint64
+		members.ForEach(func(k, _ []byte) error {
+			ids = append(ids, btoi(k))
+			return nil
+		})
+		for _, id := range ids {
+			record, err := getRecord(tx, id)
+			if err == ErrRecordNotFound {
+				continue
+			} else if err != nil {
+				return err
+			}
+			if err := deleteRecord(tx, id); err != nil {
+				return err
+			}
+			result = append(result, record)
+		}
+		return nil
+	})
+	return result, err
+}
+
+// Prune deletes records created before a time and returns the number deleted
+func (h *History) Prune(before time.Time) (int, error) {
+	count := 0
+	err := h.db.Update(func(tx *bolt.Tx) error {
+		var ids []uint64
+		c := tx.Bucket(bucketRecords).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var record Record
+			if err := json.Unmarshal(v, &record); err != nil {
+				return err
+			}
+			if record.Created.Before(before) {
+				ids = append(ids, record.ID)
+			}
+		}
+		for _, id := range ids {
+			if err := deleteRecord(tx, id); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// Text returns the text of the record
+func (r *Record) Text() string {
+	texts := make([]string, 0, len(r.Segments))
+	for _, segment := range r.Segments {
+		texts = append(texts, segment.Text)
+	}
+	return joinText(texts)
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// PRIVATE METHODS
+
+func getRecord(tx *bolt.Tx, id uint64) (*Record, error) {
+	data := tx.Bucket(bucketRecords).Get(itob(id))
+	if data == nil {
+		return nil, ErrRecordNotFound
+	}
+	record := new(Record)
+	if err := json.Unmarshal(data, record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// putRecord stores a record with its ID and adds its index entries
+func putRecord(tx *bolt.Tx, record *Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if err := tx.Bucket(bucketRecords).Put(itob(record.ID), data); err != nil {
+		return err
+	}
+	for _, key := range indexKeys(record) {
+		if err := tx.Bucket(bucketIndex).Put(key, nil); err != nil {
+			return err
+		}
+	}
+	if err := putMember(tx.Bucket(bucketChats), record.Chat, record.ID); err != nil {
+		return err
+	}
+	return putMember(tx.Bucket(bucketUsers), record.User, record.ID)
+}
+
+// deleteRecord removes a record and its index entries
+func deleteRecord(tx *bolt.Tx, id uint64) error {
+	record, err := getRecord(tx, id)
+	if err != nil {
+		return err
+	}
+	for _, key := range indexKeys(record) {
+		if err := tx.Bucket(bucketIndex).Delete(key); err != nil {
+			return err
+		}
+	}
+	if err := deleteMember(tx.Bucket(bucketChats), record.Chat, id); err != nil {
+		return err
+	}
+	if err := deleteMember(tx.Bucket(bucketUsers), record.User, id); err != nil {
+		return err
+	}
+	return tx.Bucket(bucketRecords).Delete(itob(id))
+}
+
+// putMember adds a record ID to the nested bucket for a chat or user
+func putMember(bucket *bolt.Bucket, owner int64, id uint64) error {
+	members, err := bucket.CreateBucketIfNotExists(itob(uint64(owner)))
+	if err != nil {
+		return err
+	}
+	return members.Put(itob(id), nil)
+}
+
+func deleteMember(bucket *bolt.Bucket, owner int64, id uint64) error {
+	members := bucket.Bucket(itob(uint64(owner)))
+	if members == nil {
+		return nil
+	}
+	if err := members.Delete(itob(id)); err != nil {
+		return err
+	}
+	if k, _ := members.Cursor().First(); k == nil {
+		return bucket.DeleteBucket(itob(uint64(owner)))
+	}
+	return nil
+}
+
+// indexKeys returns the keys of the full text index for a record, which are
+// the chat, the word, a zero byte and the record ID
+func indexKeys(record *Record) [][]byte {
+	var keys [][]byte
+	seen := make(map[string]bool)
+	for _, word := range tokenize(record.Text()) {
+		if seen[word] {
+			continue
+		}
+		seen[word] = true
+		key := append(itob(uint64(record.Chat)), word...)
+		key = append(key, 0)
+		keys = append(keys, append(key, itob(record.ID)...))
+	}
+	return keys
+}
+
+// searchIndex returns the IDs of records in a chat which contain a word, or
+// a word starting with it when prefix is true
+func searchIndex(index *bolt.Bucket, chat int64, word string, prefix bool) map[uint64]bool {
+	ids := make(map[uint64]bool)
+	seek := append(itob(uint64(chat)), word...)
+	if !prefix {
+		seek = append(seek, 0)
+	}
+	c := index.Cursor()
+	for k, _ := c.Seek(seek); k != nil && bytes.HasPrefix(k, seek); k, _ = c.Next() {
+		if len(k) >= 8 {
+			ids[btoi(k[len(k)-8:])] = true
+		}
+	}
+	return ids
+}
+
+// matchWords returns true when the text contains every word, with the last
+// word also matching as a prefix
+func matchWords(text, words []string) bool {
+	for i, word := range words {
+		found := false
+		for _, w := range text {
+			if w == word || (i == len(words)-1 && strings.HasPrefix(w, word)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// tokenize splits text into lower case words
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func itob(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+func btoi(b []byte) uint64 {
+	return binary.BigEndian.Uint64(b)
+}
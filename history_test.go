@@ -0,0 +1,202 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	// Packages
+	fakewhisper "github.com/skrashevich/whisper.cpp-telegram/pkg/fake-whisper"
+	assert "github.com/stretchr/testify/assert"
+)
+
+func newTestHistory(t *testing.T) *History {
+	t.Helper()
+	history, err := OpenHistory(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { history.Close() })
+	return history
+}
+
+func testRecord(chat, user int64, texts ...string) *Record {
+	return NewRecord(chat, user, 1, &Transcript{
+		Model:    "ggml-tiny",
+		Language: "en",
+		Duration: time.Duration(len(texts)) * 2 * time.Second,
+		Segments: fakewhisper.Segments(2*time.Second, texts...),
+	}, time.Second)
+}
+
+func Test_History_000(t *testing.T) {
+	assert := assert.New(t)
+	history := newTestHistory(t)
+
+	first := testRecord(1, 10, "Hello", "world")
+	assert.NoError(history.Add(first))
+	assert.NoError(history.Add(testRecord(1, 11, "Goodbye")))
+	assert.NoError(history.Add(testRecord(2, 10, "Elsewhere")))
+	assert.Equal(uint64(1), first.ID)
+
+	record, err := history.Get(1)
+	assert.NoError(err)
+	assert.Equal("Hello world", record.Text())
+	assert.Equal("ggml-tiny", record.Model)
+	assert.Equal(4*time.Second, record.Duration)
+	assert.Equal(2*time.Second, record.Segments[1].Start)
+	_, err = history.Get(99)
+	assert.ErrorIs(err, ErrRecordNotFound)
+
+	// Recent records of a chat, newest first
+	records, err := history.Recent(1, 5)
+	assert.NoError(err)
+	if assert.Len(records, 2) {
+		assert.Equal("Goodbye", records[0].Text())
+		assert.Equal("Hello world", records[1].Text())
+	}
+	records, err = history.Recent(1, 1)
+	assert.NoError(err)
+	assert.Len(records, 1)
+	records, err = history.Recent(3, 5)
+	assert.NoError(err)
+	assert.Empty(records)
+}
+
+func Test_History_001(t *testing.T) {
+	assert := assert.New(t)
+	history := newTestHistory(t)
+	assert.NoError(history.Add(testRecord(1, 10, "The quick brown fox", "jumps over the lazy dog.")))
+	assert.NoError(history.Add(testRecord(1, 10, "A lazy afternoon")))
+	assert.NoError(history.Add(testRecord(2, 10, "The lazy dog elsewhere")))
+
+	for _, test := range []struct {
+		query string
+		ids   []uint64
+	}{
+		{"lazy", []uint64{2, 1}},
+		{"LAZY DOG", []uint64{1}},
+		{"dog.", []uint64{1}},
+		{"after", []uint64{2}},
+		{"aft lazy", nil},
+		{"cat", nil},
+		{"", nil},
+	} {
+		records, err := history.Search(1, test.query, 10)
+		assert.NoError(err)
+		var ids []uint64
+		for _, record := range records {
+			ids = append(ids, record.ID)
+		}
+		assert.Equal(test.ids, ids, test.query)
+	}
+}
+
+func Test_History_002(t *testing.T) {
+	assert := assert.New(t)
+	history := newTestHistory(t)
+	assert.NoError(history.Add(testRecord(1, 10, "one")))
+	assert.NoError(history.Add(testRecord(1, 11, "two")))
+	assert.NoError(history.Add(testRecord(2, 10, "three")))
+
+	// Forget removes the records of a user from every chat and the index
+	forgotten, err := history.Forget(10)
+	assert.NoError(err)
+	assert.Len(forgotten, 2)
+	records, err := history.Recent(1, 5)
+	assert.NoError(err)
+	if assert.Len(records, 1) {
+		assert.Equal("two", records[0].Text())
+	}
+	records, err = history.Search(2, "three", 5)
+	assert.NoError(err)
+	assert.Empty(records)
+	forgotten, err = history.Forget(10)
+	assert.NoError(err)
+	assert.Empty(forgotten)
+
+	// Prune removes records created before a time
+	old := testRecord(1, 12, "old")
+	old.Created = time.Now().Add(-48 * time.Hour)
+	assert.NoError(history.Add(old))
+	count, err := history.Prune(time.Now().Add(-time.Hour))
+	assert.NoError(err)
+	assert.Equal(1, count)
+	count, err = history.Prune(time.Now().Add(time.Hour))
+	assert.NoError(err)
+	assert.Equal(1, count)
+	records, err = history.Recent(1, 5)
+	assert.NoError(err)
+	assert.Empty(records)
+}
+
+func Test_History_003(t *testing.T) {
+	assert := assert.New(t)
+	history := newTestHistory(t)
+
+	assert.NoError(history.Add(testRecord(1, 10, "Hello", "world")))
+	assert.NoError(history.Add(testRecord(2, 10, "Hello again")))
+	assert.NoError(history.Add(testRecord(1, 11, "Hello from someone else")))
+
+	// Records of the user from every chat, newest first
+	texts := func(records []*Record) []string {
+		var result []string
+		for _, record := range records {
+			result = append(result, record.Text())
+		}
+		return result
+	}
+	records, err := history.SearchUser(10, "hello", 0, 5)
+	assert.NoError(err)
+	assert.Equal([]string{"Hello again", "Hello world"}, texts(records))
+	records, err = history.SearchUser(10, "HELLO wor", 0, 5)
+	assert.NoError(err)
+	assert.Equal([]string{"Hello world"}, texts(records))
+	records, err = history.SearchUser(10, "wor hello", 0, 5)
+	assert.NoError(err)
+	assert.Empty(records)
+
+	// An empty query matches every record, and pages skip records
+	records, err = history.SearchUser(10, "", 1, 5)
+	assert.NoError(err)
+	assert.Equal([]string{"Hello world"}, texts(records))
+	records, err = history.SearchUser(12, "", 0, 5)
+	assert.NoError(err)
+	assert.Empty(records)
+}
+
+func Test_History_004(t *testing.T) {
+	assert := assert.New(t)
+	history := newTestHistory(t)
+
+	record := testRecord(1, 10, "Hello", "world")
+	assert.NoError(history.Add(record))
+	assert.NoError(history.Add(testRecord(1, 10, "Goodbye")))
+
+	// Replacing a record keeps its ID and cache keys, and updates the index
+	record.CacheKeys = []string{"a"}
+	assert.NoError(history.Replace(record))
+	replaced := testRecord(1, 10, "Bonjour")
+	replaced.ID, replaced.CacheKeys = record.ID, []string{"b"}
+	assert.NoError(history.Replace(replaced))
+	got, err := history.Get(record.ID)
+	assert.NoError(err)
+	assert.Equal("Bonjour", got.Text())
+	assert.Equal([]string{"b", "a"}, got.CacheKeys)
+	records, err := history.Search(1, "hello", 5)
+	assert.NoError(err)
+	assert.Empty(records)
+	records, err = history.Search(1, "bonjour", 5)
+	assert.NoError(err)
+	assert.Len(records, 1)
+
+	// Deleted records cannot be replaced
+	assert.NoError(history.Delete(record.ID))
+	assert.ErrorIs(history.Delete(record.ID), ErrRecordNotFound)
+	assert.ErrorIs(history.Replace(replaced), ErrRecordNotFound)
+	records, err = history.Recent(1, 5)
+	assert.NoError(err)
+	if assert.Len(records, 1) {
+		assert.Equal("Goodbye", records[0].Text())
+	}
+}
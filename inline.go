@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"gopkg.in/telebot.v3"
+)
+
+const (
+	// Number of transcripts in each page of inline query results
+	maxInline = 20
+
+	// Maximum number of characters in a message, which limits the text
+	// inserted from an inline query
+	maxMessage = 4096
+)
+
+// OnQuery searches the transcripts of the user from any chat when they type
+// "@bot words", so a transcript can be inserted into any chat. Results are
+// personal, and only include transcripts of media the user sent themselves,
+// even when someone else asked for them. Inline mode has to be enabled for
+// the bot with BotFather
+func (b *Bot) OnQuery(c telebot.Context) error {
+	query := c.Query()
+	response := &telebot.QueryResponse{Results: telebot.Results{}, IsPersonal: true, CacheTime: 10}
+	if b.history == nil {
+		return c.Answer(response)
+	}
+	skip, _ := strconv.Atoi(query.Offset)
+	records, err := b.history.SearchUser(c.Sender().ID, query.Text, skip, maxInline)
+	if err != nil {
+		jobLogger(c).Error("searching history", "error", err)
+		return c.Answer(response)
+	}
+	words := tokenize(query.Text)
+	for _, record := range records {
+		text := []rune(record.Text())
+		if len(text) > maxMessage {
+			text = append(text[:maxMessage-1], '…')
+		}
+		result := &telebot.ArticleResult{
+			Title:       fmt.Sprintf("%s, %s", record.Created.Format("2006-01-02 15:04"), clock(record.Duration)),
+			Description: snippet(record.Text(), words, maxPreview),
+			Text:        string(text),
+		}
+		result.SetResultID(strconv.FormatUint(record.ID, 10))
+		response.Results = append(response.Results, result)
+	}
+	if len(records) == maxInline {
+		response.NextOffset = strconv.Itoa(skip + maxInline)
+	}
+	jobLogger(c).Debug("answered query", "results", len(records), "offset", skip)
+	return c.Answer(response)
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// PRIVATE METHODS
+
+// snippet returns up to n characters of text around the first of the words
+// it contains, or from the start when it contains none
+func snippet(text string, words []string, n int) string {
+	runes := []rune(text)
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+	start := 0
+	for _, word := range words {
+		if i := strings.Index(string(lower), word); i >= 0 {
+			// Show some of the text before the word
+			start = len([]rune(string(lower)[:i])) - n/4
+			break
+		}
+	}
+	if start < 0 || len(runes) <= n {
+		start = 0
+	} else if start > len(runes)-n {
+		start = len(runes) - n
+	}
+	end := start + n
+	if end > len(runes) {
+		end = len(runes)
+	}
+	result := strings.TrimSpace(string(runes[start:end]))
+	if start > 0 {
+		result = "…" + result
+	}
+	if end < len(runes) {
+		result += "…"
+	}
+	return result
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	// Packages
+	assert "github.com/stretchr/testify/assert"
+	"gopkg.in/telebot.v3"
+)
+
+func Test_Inline_000(t *testing.T) {
+	assert := assert.New(t)
+	bot := newTestBot(t, "Hello")
+	query := func(text string) *fakeContext {
+		c := newFakeContext(&telebot.Message{})
+		c.query = &telebot.Query{Text: text, Sender: c.msg.Sender}
+		return c
+	}
+
+	// Nothing is found without a history
+	c := query("hello")
+	assert.NoError(bot.OnQuery(c))
+	if assert.NotNil(c.answer) {
+		assert.Empty(c.answer.Results)
+	}
+
+	// Only the transcripts of the user are found
+	bot.history = newTestHistory(t)
+	assert.NoError(bot.history.Add(testRecord(1, 42, "Hello", "world")))
+	assert.NoError(bot.history.Add(testRecord(2, 42, "Goodbye")))
+	assert.NoError(bot.history.Add(testRecord(1, 7, "Hello from someone else")))
+	c = query("hel")
+	assert.NoError(bot.OnQuery(c))
+	if assert.NotNil(c.answer) && assert.Len(c.answer.Results, 1) {
+		result := c.answer.Results[0].(*telebot.ArticleResult)
+		assert.Equal("1", result.ResultID())
+		assert.Equal("Hello world", result.Text)
+		assert.Equal("Hello world", result.Description)
+		assert.True(c.answer.IsPersonal)
+		assert.Empty(c.answer.NextOffset)
+	}
+	c = query("")
+	assert.NoError(bot.OnQuery(c))
+	if assert.NotNil(c.answer) {
+		assert.Len(c.answer.Results, 2)
+	}
+}
+
+func Test_Inline_001(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("Hello world", snippet("Hello world", []string{"world"}, 20))
+	text := strings.Repeat("a ", 50) + "Needle " + strings.Repeat("b ", 50)
+	result := snippet(text, []string{"needle"}, 40)
+	assert.True(strings.HasPrefix(result, "…a a"), result)
+	assert.Contains(result, "Needle")
+	assert.True(strings.HasSuffix(result, "b…"), result)
+	result = snippet(text, []string{"missing"}, 10)
+	assert.Equal("a a a a a…", result)
+}
+
+func Test_Inline_002(t *testing.T) {
+	assert := assert.New(t)
+	bot := newTestBot(t, "Hello")
+	bot.history = newTestHistory(t)
+	group := &telebot.Chat{ID: -100, Type: telebot.ChatSuperGroup}
+	query := func(user int64) *fakeContext {
+		c := newFakeContext(&telebot.Message{Sender: &telebot.User{ID: user}})
+		c.query = &telebot.Query{Text: "hello", Sender: c.msg.Sender}
+		return c
+	}
+
+	// A transcript asked for by another user belongs to the sender of the
+	// media, and is only found by them
+	c := newFakeContext(&telebot.Message{Chat: group, Sender: &telebot.User{ID: 5}, ReplyTo: &telebot.Message{
+		ID:     10,
+		Chat:   group,
+		Sender: &telebot.User{ID: 9},
+		Voice:  &telebot.Voice{File: telebot.File{FileID: "voice"}},
+	}})
+	assert.NoError(bot.OnTranscribe(c))
+	assert.Len(c.sent, 1)
+	c = query(5)
+	assert.NoError(bot.OnQuery(c))
+	if assert.NotNil(c.answer) {
+		assert.Empty(c.answer.Results)
+	}
+	c = query(9)
+	assert.NoError(bot.OnQuery(c))
+	if assert.NotNil(c.answer) {
+		assert.Len(c.answer.Results, 1)
+	}
+}
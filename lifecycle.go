@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrShuttingDown is returned when a job is started during shutdown
+	ErrShuttingDown = errors.New("the bot is shutting down, please try again in a minute")
+)
+
+// Lifecycle tracks the jobs in progress and shuts down in order. When
+// shutdown begins new jobs are refused and the stop functions run, then jobs
+// are given the grace period to finish. Jobs still running after that are
+// cancelled and their users notified, and finally the close functions run
+type Lifecycle struct {
+	sync.Mutex
+	ctx     context.Context
+	cancel  context.CancelFunc
+	grace   time.Duration
+	closing bool
+	jobs    map[*lifecycleJob]bool
+	idle    chan struct{}
+	stopped chan error
+	stops   []lifecycleFunc
+	closes  []lifecycleFunc
+}
+
+type lifecycleJob struct {
+	cancel context.CancelFunc
+	notify func()
+}
+
+type lifecycleFunc struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+// NewLifecycle returns a lifecycle which gives jobs the grace period to
+// finish when shutting down
+func NewLifecycle(grace time.Duration) *Lifecycle {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Lifecycle{
+		ctx:     ctx,
+		cancel:  cancel,
+		grace:   grace,
+		jobs:    make(map[*lifecycleJob]bool),
+		stopped: make(chan error, 1),
+	}
+}
+
+// Context returns a context which is cancelled when shutdown begins, for
+// work such as model downloads which should not delay it
+func (l *Lifecycle) Context() context.Context {
+	if l == nil {
+		return context.Background()
+	}
+	return l.ctx
+}
+
+// OnStop adds a function which runs when shutdown begins, such as stopping
+// the poller. Functions run in the order they were added, and the context
+// expires with the grace period
+func (l *Lifecycle) OnStop(name string, fn func(ctx context.Context) error) {
+	l.Lock()
+	defer l.Unlock()
+	l.stops = append(l.stops, lifecycleFunc{name, fn})
+}
+
+// OnClose adds a function which runs once the jobs have finished, such as
+// freeing the models. Functions run in the reverse order they were added
+func (l *Lifecycle) OnClose(name string, fn func() error) {
+	l.Lock()
+	defer l.Unlock()
+	l.closes = append(l.closes, lifecycleFunc{name, func(context.Context) error { return fn() }})
+}
+
+// Begin starts a job, returning its context and a function which must be
+// called when the job is done. The context is cancelled if the job is still
+// running when the grace period expires, and notify is then called to tell
+// the user. ErrShuttingDown is returned once shutdown has begun
+func (l *Lifecycle) Begin(notify func()) (context.Context, func(), error) {
+	if l == nil {
+		return context.Background(), func() {}, nil
+	}
+	l.Lock()
+	defer l.Unlock()
+	if l.closing {
+		return nil, nil, ErrShuttingDown
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &lifecycleJob{cancel: cancel, notify: notify}
+	l.jobs[job] = true
+	return ctx, func() { l.done(job) }, nil
+}
+
+// Jobs returns the number of jobs in progress
+func (l *Lifecycle) Jobs() int {
+	if l == nil {
+		return 0
+	}
+	l.Lock()
+	defer l.Unlock()
+	return len(l.jobs)
+}
+
+// Notify begins shutdown when one of the signals is received. The context
+// is cancelled straight away, so signals also interrupt model downloads
+// while starting up. A second signal exits immediately
+func (l *Lifecycle) Notify(signals ...os.Signal) {
+	ch := make(chan os.Signal, 2)
+	signal.Notify(ch, signals...)
+	go func() {
+		sig := <-ch
+		slog.Info("received signal, shutting down", "signal", sig, "grace", l.grace, "jobs", l.Jobs())
+		l.cancel()
+		l.stop(nil)
+
+		sig = <-ch
+		slog.Warn("received second signal, exiting immediately", "signal", sig, "jobs", l.Jobs())
+		os.Exit(1)
+	}()
+}
+
+// Fail begins shutdown because of an error, such as a server which could
+// not listen. Wait then returns a non-zero status
+func (l *Lifecycle) Fail(err error) {
+	slog.Error("shutting down", "error", err)
+	l.stop(err)
+}
+
+// Wait blocks until a signal is received or Fail is called, then shuts down
+// and returns the exit status
+func (l *Lifecycle) Wait() int {
+	err := <-l.stopped
+	if status := l.Shutdown(); status != 0 || err == nil {
+		return status
+	}
+	return 1
+}
+
+// Shutdown stops accepting jobs, runs the stop functions, waits up to the
+// grace period for jobs to finish and then runs the close functions. It
+// returns 0 when everything finished cleanly and 1 when jobs were cancelled
+// or a function failed
+func (l *Lifecycle) Shutdown() int {
+	l.Lock()
+	if l.closing {
+		l.Unlock()
+		return 0
+	}
+	l.closing = true
+	l.idle = make(chan struct{})
+	if len(l.jobs) == 0 {
+		close(l.idle)
+	}
+	stops, closes := l.stops, l.closes
+	l.Unlock()
+	l.cancel()
+
+	status := 0
+	ctx, cancel := context.WithTimeout(context.Background(), l.grace)
+	defer cancel()
+	for _, stop := range stops {
+		slog.Debug("stopping", "name", stop.name)
+		if err := stop.fn(ctx); err != nil {
+			slog.Error("stopping", "name", stop.name, "error", err)
+			status = 1
+		}
+	}
+
+	// Wait for the jobs in progress, and cancel those which overrun
+	select {
+	case <-l.idle:
+		slog.Info("jobs finished")
+	case <-ctx.Done():
+		l.Lock()
+		jobs := make([]*lifecycleJob, 0, len(l.jobs))
+		for job := range l.jobs {
+			jobs = append(jobs, job)
+		}
+		l.Unlock()
+		slog.Warn("grace period expired, cancelling jobs", "jobs", len(jobs))
+		for _, job := range jobs {
+			job.cancel()
+			if job.notify != nil {
+				job.notify()
+			}
+		}
+		status = 1
+	}
+
+	for i := len(closes) - 1; i >= 0; i-- {
+		slog.Debug("closing", "name", closes[i].name)
+		if err := closes[i].fn(context.Background()); err != nil {
+			slog.Error("closing", "name", closes[i].name, "error", err)
+			status = 1
+		}
+	}
+	slog.Info("shut down", "status", status)
+	return status
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// PRIVATE METHODS
+
+func (l *Lifecycle) stop(err error) {
+	select {
+	case l.stopped <- err:
+	default:
+	}
+}
+
+func (l *Lifecycle) done(job *lifecycleJob) {
+	l.Lock()
+	defer l.Unlock()
+	if !l.jobs[job] {
+		return
+	}
+	delete(l.jobs, job)
+	job.cancel()
+	if l.closing && len(l.jobs) == 0 {
+		close(l.idle)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	// Packages
+	assert "github.com/stretchr/testify/assert"
+)
+
+func Test_Lifecycle_000(t *testing.T) {
+	assert := assert.New(t)
+	lifecycle := NewLifecycle(time.Second)
+	var order []string
+	lifecycle.OnStop("poller", func(ctx context.Context) error {
+		order = append(order, "poller")
+		_, ok := ctx.Deadline()
+		assert.True(ok)
+		return nil
+	})
+	lifecycle.OnClose("history", func() error {
+		order = append(order, "history")
+		return nil
+	})
+	lifecycle.OnClose("models", func() error {
+		order = append(order, "models")
+		return nil
+	})
+
+	// Shutdown waits for the job in progress, which is not cancelled
+	ctx, done, err := lifecycle.Begin(func() { t.Error("notified") })
+	assert.NoError(err)
+	assert.Equal(1, lifecycle.Jobs())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		assert.NoError(ctx.Err())
+		order = append(order, "job")
+		done()
+	}()
+	assert.Equal(0, lifecycle.Shutdown())
+	assert.Equal([]string{"poller", "job", "models", "history"}, order)
+	assert.Error(lifecycle.Context().Err())
+	assert.Equal(0, lifecycle.Jobs())
+
+	// New jobs are refused
+	_, _, err = lifecycle.Begin(nil)
+	assert.ErrorIs(err, ErrShuttingDown)
+}
+
+func Test_Lifecycle_001(t *testing.T) {
+	assert := assert.New(t)
+	lifecycle := NewLifecycle(20 * time.Millisecond)
+	closed := false
+	lifecycle.OnClose("models", func() error {
+		closed = true
+		return nil
+	})
+
+	// Jobs which overrun the grace period are cancelled and notified
+	notified := 0
+	ctx, done, err := lifecycle.Begin(func() { notified++ })
+	assert.NoError(err)
+	defer done()
+	assert.Equal(1, lifecycle.Shutdown())
+	assert.ErrorIs(ctx.Err(), context.Canceled)
+	assert.Equal(1, notified)
+	assert.True(closed)
+
+	// Failing begins shutdown with a non-zero status
+	lifecycle = NewLifecycle(time.Second)
+	lifecycle.Fail(context.DeadlineExceeded)
+	assert.Equal(1, lifecycle.Wait())
+}
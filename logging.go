@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	// Packages
+	"gopkg.in/telebot.v3"
+)
+
+const (
+	// Replacement for secrets and redacted transcript text
+	redacted = "[REDACTED]"
+
+	// Key under which the job logger is stored in a telebot context
+	loggerKey = "logger"
+)
+
+// Attribute keys which hold transcript text, redacted when requested
+var textKeys = map[string]bool{"text": true, "caption": true}
+
+type contextKey struct{}
+
+// NewLogger returns a structured logger writing to w. The level is one of
+// debug, info, warn or error and the format is text or json. Occurrences of
+// the secrets in messages and attributes are redacted, and so is transcript
+// text when redactText is true
+func NewLogger(w io.Writer, level, format string, secrets []string, redactText bool) (*slog.Logger, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level: %q", level)
+	}
+	opts := &slog.HandlerOptions{
+		Level:       l,
+		ReplaceAttr: redactor(secrets, redactText),
+	}
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format: %q", format)
+	}
+}
+
+// withLogger returns a context carrying a logger
+func withLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// loggerFrom returns the logger carried by a context, or the default logger
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// newJobID returns a random identifier which correlates the log lines of a
+// job
+func newJobID() string {
+	id := make([]byte, 4)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// LogUpdates is telebot middleware which gives each update a job ID and a
+// logger with the chat and sender, and logs the update at debug level
+func LogUpdates(next telebot.HandlerFunc) telebot.HandlerFunc {
+	return func(c telebot.Context) error {
+		logger := slog.Default().With("job", newJobID())
+		if chat := c.Chat(); chat != nil {
+			logger = logger.With("chat", chat.ID)
+		}
+		if sender := c.Sender(); sender != nil {
+			logger = logger.With("user", sender.ID, "username", sender.Username)
+		}
+		c.Set(loggerKey, logger)
+		if msg := c.Message(); msg != nil {
+			logger.Debug("update", "message", msg.ID, "text", msg.Text, "caption", msg.Caption)
+		} else if cb := c.Callback(); cb != nil {
+			logger.Debug("update", "callback", cb.Unique, "data", cb.Data)
+		} else if q := c.Query(); q != nil {
+			logger.Debug("update", "query", q.ID)
+		}
+		return next(c)
+	}
+}
+
+// jobLogger returns the logger set by LogUpdates, or the default logger
+func jobLogger(c telebot.Context) *slog.Logger {
+	if logger, ok := c.Get(loggerKey).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// PRIVATE METHODS
+
+func redactor(secrets []string, redactText bool) func([]string, slog.Attr) slog.Attr {
+	var pairs []string
+	for _, secret := range secrets {
+		if secret != "" {
+			pairs = append(pairs, secret, redacted)
+		}
+	}
+	replacer := strings.NewReplacer(pairs...)
+	return func(groups []string, a slog.Attr) slog.Attr {
+		if redactText && textKeys[a.Key] {
+			return slog.String(a.Key, redacted)
+		}
+		if len(pairs) == 0 {
+			return a
+		}
+		switch v := a.Value.Resolve(); v.Kind() {
+		case slog.KindString:
+			return slog.String(a.Key, replacer.Replace(v.String()))
+		case slog.KindAny:
+			if err, ok := v.Any().(error); ok {
+				return slog.String(a.Key, replacer.Replace(err.Error()))
+			}
+		}
+		return a
+	}
+}
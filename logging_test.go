@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	// Packages
+	assert "github.com/stretchr/testify/assert"
+	"gopkg.in/telebot.v3"
+)
+
+func Test_Logging_000(t *testing.T) {
+	assert := assert.New(t)
+	_, err := NewLogger(nil, "loud", "text", nil, false)
+	assert.Error(err)
+	_, err = NewLogger(nil, "info", "xml", nil, false)
+	assert.Error(err)
+
+	// Secrets are redacted from messages, strings and errors
+	var buf bytes.Buffer
+	logger, err := NewLogger(&buf, "info", "json", []string{"123:secret"}, false)
+	assert.NoError(err)
+	logger.Info("fetching https://api.telegram.org/file/bot123:secret/voice.oga",
+		"url", "https://api.telegram.org/file/bot123:secret/voice.oga",
+		"error", errors.New("get bot123:secret: timeout"),
+		"text", "Hello world",
+	)
+	logger.Debug("hidden")
+	var line map[string]interface{}
+	assert.NoError(json.Unmarshal(buf.Bytes(), &line))
+	assert.Equal("fetching https://api.telegram.org/file/bot[REDACTED]/voice.oga", line["msg"])
+	assert.Equal("https://api.telegram.org/file/bot[REDACTED]/voice.oga", line["url"])
+	assert.Equal("get bot[REDACTED]: timeout", line["error"])
+	assert.Equal("Hello world", line["text"])
+	assert.NotContains(buf.String(), "hidden")
+
+	// Transcript text is redacted on request
+	buf.Reset()
+	logger, err = NewLogger(&buf, "debug", "text", nil, true)
+	assert.NoError(err)
+	logger.Debug("segment", "text", "Hello world", "caption", "from 1:00")
+	assert.Equal("level=DEBUG msg=segment text=[REDACTED] caption=[REDACTED]", strings.TrimSpace(buf.String()[strings.Index(buf.String(), "level="):]))
+}
+
+func Test_Logging_001(t *testing.T) {
+	assert := assert.New(t)
+	var buf bytes.Buffer
+	logger, err := NewLogger(&buf, "debug", "json", nil, false)
+	assert.NoError(err)
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(logger)
+
+	// Each update gets a logger with a job ID, chat and user, which is
+	// carried through the job
+	bot := newTestBot(t, "Hello")
+	c := newFakeContext(&telebot.Message{ID: 3, Voice: &telebot.Voice{File: telebot.File{FileID: "voice"}}})
+	assert.NoError(LogUpdates(bot.OnMedia)(c))
+	assert.Len(c.sent, 1)
+
+	var jobs = make(map[string]bool)
+	var messages []string
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry map[string]interface{}
+		assert.NoError(json.Unmarshal([]byte(line), &entry))
+		if job, ok := entry["job"].(string); ok {
+			jobs[job] = true
+			assert.Equal(42.0, entry["chat"])
+			assert.Equal(42.0, entry["user"])
+			messages = append(messages, entry["msg"].(string))
+		}
+	}
+	assert.Len(jobs, 1)
+	assert.Subset(messages, []string{"update", "received media", "preparing model", "segment", "transcribed"})
+
+	// Without the middleware the default logger is used
+	assert.Equal(slog.Default(), jobLogger(newFakeContext(&telebot.Message{})))
+	assert.Equal(slog.Default(), loggerFrom(context.Background()))
+}
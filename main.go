@@ -2,9 +2,8 @@ package main
 
 import (
 	"context"
-	"crypto/rand"
-	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"net/http"
 
@@ -12,20 +11,17 @@ import (
 	"io"
 
 	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
 	"flag"
 
-	ffmpeg "github.com/u2takey/ffmpeg-go"
 	"gopkg.in/telebot.v3"
 
-	"gopkg.in/telebot.v3/middleware"
-
-	"log"
+	"log/slog"
 	"os"
-	"os/signal"
 
 	// Packages
 	//whisper "github.com/ggerganov/whisper.cpp/bindings/go/pkg/whisper"
@@ -54,33 +50,126 @@ func main() {
 	offset := flag.Duration("offset", 0, "Time offset")
 	duration := flag.Duration("duration", 0, "Duration of audio to process")
 	threads := flag.Uint("threads", 0, "Number of threads to use")
-	speedup := flag.Bool("speedup", false, "Enable speedup")
 	max_len := flag.Uint("max-len", 0, "Maximum segment length in characters")
 	max_tokens := flag.Uint("max-tokens", 0, "Maximum tokens per segment")
 	word_thold := flag.Float64("word-thold", 0, "Maximum segment score")
 	tokens := flag.Bool("tokens", false, "Display tokens")
 	colorize := flag.Bool("colorize", false, "Colorize tokens")
-	//flag.String("out", "", "Output format (srt, none or leave as empty string)")
+	channels := flag.Bool("channels", false, "Transcribe each channel of stereo audio separately, for call recordings with one speaker per channel")
+	filters := flag.String("filters", "", "Comma-separated filters applied to audio before it is transcribed ("+strings.Join(filterNames, ", ")+")")
+	speakers := flag.Bool("speakers", false, "Label the speaker of each segment, using speaker turns with tinydiarize (tdrz) models")
+	out := flag.String("format", FormatText, "Output format ("+strings.Join(formats, ", ")+")")
+	http_addr := flag.String("http", "", "Address for the HTTP API, for example :8080 (disabled when empty)")
+	metrics_addr := flag.String("metrics", "", "Address for prometheus metrics, /healthz and /readyz, for example :9090 (disabled when empty)")
+	edit_interval := flag.Duration("edit-interval", 3*time.Second, "Show text replies while they are transcribed, editing them at most this often, 0 to disable")
+	low_confidence := flag.Float64("low-confidence", 0, "Highlight words with a probability below this threshold, for example 0.5 (disabled when 0)")
+	model_memory := flag.Uint("model-memory", 0, "Memory budget in MB for loaded models, 0 for no limit")
+	route := flag.String("route", "", "Rules choosing the model for each job, for example \"ggml-tiny:duration<=30s;ggml-base:queue>=4\"")
+	tiers := flag.String("tiers", "", "User tiers for routing rules, for example \"123=premium,456=premium\"")
+	history_path := flag.String("history", "history.db", "Path of the transcript history and chat settings database, empty to disable history and forget settings on restart")
+	history_retention := flag.Duration("history-retention", 0, "Delete transcripts from the history after this long, 0 to keep them")
+	jobs := flag.Uint("jobs", 1, "Number of jobs transcribed at once")
+	short := flag.Duration("short", 30*time.Second, "Give priority to media no longer than this, 0 to disable")
+	aging := flag.Duration("aging", 2*time.Minute, "Raise the priority of waiting jobs each time they wait this long, 0 to disable")
+	queue_path := flag.String("queue", "queue.db", "Path of the job queue database, for resuming jobs after a restart, empty to disable")
+	queue_expiry := flag.Duration("queue-expiry", 24*time.Hour, "Drop queued jobs older than this when resuming them, 0 to keep them")
+	cache_dir := flag.String("cache", "cache", "Directory for cached transcripts, empty to disable caching")
+	cache_size := flag.Uint("cache-size", 256, "Maximum size of the transcript cache in MB, 0 for no limit")
+	group_mode := flag.String("group-mode", GroupAuto, "Media transcribed in group chats which have not chosen a mode ("+strings.Join(groupModes, ", ")+")")
+	translator := flag.String("translator", "", "URL of a LibreTranslate compatible service for translating transcripts, for example http://localhost:5000 (disabled when empty)")
+	translator_key := flag.String("translator-key", "", "API key for the translation service")
+	translations := flag.String("translations", strings.Join(defaultTranslations, ","), "Comma-separated languages offered by the translate button")
+	admins := flag.String("admins", "", "Comma-separated telegram user IDs which can run admin commands")
+	log_level := flag.String("log-level", "info", "Log level (debug, info, warn, error)")
+	log_format := flag.String("log-format", "text", "Log format (text, json)")
+	log_redact_text := flag.Bool("log-redact-text", false, "Redact transcript text from the logs")
+	shutdown_grace := flag.Duration("shutdown-grace", 30*time.Second, "Time given to jobs in progress to finish when shutting down")
+	low_confidence_marker := flag.String("low-confidence-marker", "<i>%s</i>", "HTML format string used to highlight low confidence words")
 
 	flag.Parse()
 
-	// Create a channel to receive the signals
-	sigChan := make(chan os.Signal, 1)
+	// Structured logging, which also receives the output of the log package
+	if logger, err := NewLogger(os.Stderr, *log_level, *log_format, []string{*token}, *log_redact_text); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	} else {
+		slog.SetDefault(logger)
+	}
 
-	// Notify the channel for specific signals
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	// Shut down in order on a signal, giving jobs in progress time to finish
+	lifecycle := NewLifecycle(*shutdown_grace)
+	lifecycle.Notify(os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT)
 
-	// Start a goroutine to handle signals
-	go func() {
-		// Block until a signal is received
-		sig := <-sigChan
-		fmt.Printf("Received signal: %s\n", sig)
+	if !isInSet(*out, formats) {
+		fmt.Fprintf(os.Stderr, "Format must be one of: %s\n", strings.Join(formats, ","))
+		os.Exit(1)
+	}
+	if !isInSet(*group_mode, groupModes) {
+		fmt.Fprintf(os.Stderr, "Group mode must be one of: %s\n", strings.Join(groupModes, ","))
+		os.Exit(1)
+	}
+	if list, err := parseFilters(*filters); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	} else {
+		*filters = list
+	}
 
-		// Perform any cleanup or shutdown operations here
+	// Routing rules
+	router := &Router{}
+	if rules, err := ParseRules(*route); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	} else {
+		for _, rule := range rules {
+			if !isInSet(rule.Model, modelNames) {
+				fmt.Fprintf(os.Stderr, "Error: unknown model %q in routing rules\n", rule.Model)
+				os.Exit(1)
+			}
+		}
+		router.Rules = rules
+	}
+	if tiers, err := ParseTiers(*tiers); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	} else {
+		router.Tiers = tiers
+	}
 
-		// Terminate the program
-		os.Exit(0)
-	}()
+	// Transcript history
+	var history *History
+	if *history_path != "" {
+		var err error
+		if history, err = OpenHistory(*history_path); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		lifecycle.OnClose("history", history.Close)
+		if *history_retention > 0 {
+			go pruneHistory(history, *history_retention)
+		}
+	}
+
+	// Jobs which have not finished, resumed when the bot starts
+	var queue *Queue
+	if *queue_path != "" {
+		var err error
+		if queue, err = OpenQueue(*queue_path); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		lifecycle.OnClose("queue", queue.Close)
+	}
+
+	// Transcript cache
+	var cache *Cache
+	if *cache_dir != "" {
+		var err error
+		if cache, err = OpenCache(*cache_dir, int64(*cache_size)<<20); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+	}
 
 	// Get output path
 	modelspath, err := modeldownloader.GetOut()
@@ -89,170 +178,207 @@ func main() {
 		os.Exit(-1)
 	}
 
-	// Create context which quits on SIGINT or SIGQUIT
-	ctx := modeldownloader.ContextForSignal(os.Interrupt, syscall.SIGQUIT)
+	// Model downloads are interrupted when shutting down
+	ctx := lifecycle.Context()
 
 	// Progress filehandle
 	progress := os.Stdout
 
-	url, err := modeldownloader.URLForModel(*model)
-	modelfile := filepath.Join(modelspath, filepath.Base(url))
-	info, err := os.Stat(modelfile)
-
-	if err == nil && info.Size() > 0 {
-		log.Printf("Use local model %s", *model)
-	} else {
-		log.Printf("Download model %s", *model)
-		if !isInSet(*model, modelNames) {
-			fmt.Printf("Model must be one of: %s\n", strings.Join(modelNames, ","))
-			//os.Exit(1)
-		}
-		if modelfile, err := modeldownloader.Download(ctx, progress, url, modelspath); err == nil || err == io.EOF {
-			fmt.Fprintln(progress, "Model downloaded")
-		} else if err == context.Canceled {
-			os.Remove(modelfile)
-			fmt.Fprintln(progress, "\nInterrupted")
-			os.Exit(1)
-		} else if err == context.DeadlineExceeded {
-			os.Remove(modelfile)
-			fmt.Fprintln(progress, "Timeout downloading model")
-			os.Exit(1)
-		} else {
-			os.Remove(modelfile)
-			fmt.Fprintln(os.Stderr, "Error:", err)
-			os.Exit(1)
-		}
+	// Models are downloaded when first needed
+	resolve := func(name string) (string, error) {
+		return modelFile(ctx, progress, modelspath, name)
+	}
+	models := NewModelManager(cgoBackend{}, resolve, int64(*model_memory)<<20, *model)
+	models.cache = cache
+	lifecycle.OnClose("models", models.Close)
+	scheduler := NewScheduler(int(*jobs), *short, *aging)
+	health := NewHealth(models, time.Minute)
+	var metrics *Metrics
+	if *metrics_addr != "" {
+		metrics = NewMetrics(models, cache)
+		models.metrics = metrics
+		metrics.Scheduler(scheduler)
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler())
+		health.Register(mux)
+		// The metrics server keeps running until the jobs have finished
+		server := &http.Server{Addr: *metrics_addr, Handler: mux}
+		lifecycle.OnClose("metrics", server.Close)
+		serve(lifecycle, "metrics", server)
 	}
 
+	if *token == "" {
+		slog.Error("no bot token provided")
+		os.Exit(1)
+	}
+	poller := NewPoller(health, 10*time.Second)
 	pref := telebot.Settings{
 		Token:  *token,
-		Poller: &telebot.LongPoller{Timeout: 10 * time.Second},
-	}
-	if err != nil {
-		log.Printf("Not bot token provided")
-		os.Exit(1)
+		Poller: poller,
+		Client: poller.Client(),
 	}
 
 	bot, err := telebot.NewBot(pref)
-
-	log.Printf("Authorized on account %s", bot.Me.Username)
-
-	wp := WPInit()
-
-	// Load model
-	err = wp.LoadModel(modelfile)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
+		slog.Error("connecting to telegram", "error", err)
 		os.Exit(1)
 	}
-	log.Printf("Model %s loaded", *model)
-	//wp.Prepare()
 
-	//log.Printf("Model %s prepared", *model)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
+	slog.Info("authorized", "account", bot.Me.Username)
+
+	// Load the default model
+	if _, release, err := models.Acquire(*model); err != nil {
+		slog.Error("loading model", "error", err)
 		os.Exit(1)
+	} else {
+		release()
 	}
 
-	bot.Use(middleware.Logger())
-
-	processHandler := func(c telebot.Context, fileURL string) error {
-		err := wp.PrepareModel(WhisperParams{
-			language:   *language,
-			no_context: *no_context,
-			translate:  *translate,
-			offset:     *offset,
-			duration:   *duration,
-			threads:    *threads,
-			speedup:    *speedup,
-			max_len:    *max_len,
-			max_tokens: *max_tokens,
-			word_thold: *word_thold,
-			tokens:     *tokens,
-			colorize:   *colorize,
-		})
+	bot.Use(LogUpdates)
+
+	params := WhisperParams{
+		language:   *language,
+		no_context: *no_context,
+		translate:  *translate,
+		offset:     *offset,
+		duration:   *duration,
+		threads:    *threads,
+		max_len:    *max_len,
+		max_tokens: *max_tokens,
+		word_thold: *word_thold,
+		tokens:     *tokens,
+		colorize:   *colorize,
+		out:        *out,
+		speakers:   *speakers,
+		channels:   *channels,
+		filters:    *filters,
+	}
+	handler := NewBot(models, params, func(fileID string) (string, error) {
+		return getFileURL(*token, fileID)
+	})
+	handler.lowConfidence = float32(*low_confidence)
+	handler.lowConfidenceMarker = *low_confidence_marker
+	handler.router = router
+	handler.history = history
+	if history != nil {
+		// Chat settings are kept with the history, so they survive restarts
+		settings, err := OpenSettings(history.db)
 		if err != nil {
-			fmt.Fprintln(os.Stderr, err)
-			return err
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
 		}
-
-		start := time.Now()
-		output, err := wp.Transcribe(fileURL)
-		if err != nil {
-			log.Println(err)
-			output = err.Error()
+		handler.settings = settings
+	}
+	handler.cache = cache
+	handler.metrics = metrics
+	handler.lifecycle = lifecycle
+	handler.queue = queue
+	handler.scheduler = scheduler
+	handler.editInterval = *edit_interval
+	handler.groupMode = *group_mode
+	if *translator != "" {
+		handler.translator = NewLibreTranslate(*translator, *translator_key)
+		handler.translations = nil
+		for _, lang := range strings.Split(*translations, ",") {
+			if lang = strings.TrimSpace(lang); isLanguage(lang) {
+				handler.translations = append(handler.translations, lang)
+			}
 		}
-		recorgise_duration := time.Since(start)
-		output += fmt.Sprintf("\n\n%.2f seconds", recorgise_duration.Seconds())
-
-		// Instead, prefer a context short-hand:
-		return c.Send(output)
 	}
-
-	bot.Handle(telebot.OnVoice, func(c telebot.Context) error {
-
-		fileURL, err := getFileURL(c.Bot().Token, c.Message().Voice.FileID)
-		if err != nil {
-			log.Println(err)
-			return c.Send(err.Error())
+	for _, id := range strings.Split(*admins, ",") {
+		if id, err := strconv.ParseInt(strings.TrimSpace(id), 10, 64); err == nil {
+			handler.admins[id] = true
 		}
-		fmt.Printf("Received voice message from %s. FileID: %s, FileURL: %s\n", c.Sender().Username, c.Message().Voice.FileID, fileURL)
-		return processHandler(c, fileURL)
-	})
-	bot.Handle(telebot.OnVideoNote, func(c telebot.Context) error {
+	}
+	handler.Register(bot)
+
+	if *http_addr != "" {
+		api := NewAPI(models, params)
+		api.router = router
+		api.metrics = metrics
+		api.lifecycle = lifecycle
+		api.scheduler = scheduler
+		server := &http.Server{Addr: *http_addr, Handler: api}
+		lifecycle.OnStop("HTTP API", server.Shutdown)
+		serve(lifecycle, "HTTP API", server)
+	}
 
-		fileURL, err := getFileURL(c.Bot().Token, c.Message().VideoNote.FileID)
-		if err != nil {
-			log.Println(err)
-			return c.Send(err.Error())
-		}
-		fmt.Printf("Received VideoNote message from %s. FileID: %s, FileURL: %s\n", c.Sender().Username, c.Message().VideoNote.FileID, fileURL)
-		return processHandler(c, fileURL)
+	// Stop polling before waiting for the jobs in progress
+	lifecycle.OnStop("health", func(context.Context) error {
+		health.Stopping()
+		return nil
 	})
-	bot.Handle(telebot.OnAudio, func(c telebot.Context) error {
-
-		fileURL, err := getFileURL(c.Bot().Token, c.Message().Audio.FileID)
-		if err != nil {
-			log.Println(err)
-			return c.Send(err.Error())
-		}
-		fmt.Printf("Received Audio message from %s. FileID: %s, FileURL: %s\n", c.Sender().Username, c.Message().Audio.FileID, fileURL)
-		return processHandler(c, fileURL)
+	lifecycle.OnStop("poller", func(context.Context) error {
+		bot.Stop()
+		return nil
 	})
-	bot.Handle(telebot.OnVideo, func(c telebot.Context) error {
+	go bot.Start()
+	if queue != nil {
+		go func() {
+			if err := handler.Resume(bot.NewContext, *queue_expiry); err != nil {
+				slog.Error("resuming queued jobs", "error", err)
+			}
+		}()
+	}
 
-		fileURL, err := getFileURL(c.Bot().Token, c.Message().Video.FileID)
-		if err != nil {
-			log.Println(err)
-			return c.Send(err.Error())
-		}
-		fmt.Printf("Received Video message from %s. FileID: %s, FileURL: %s\n", c.Sender().Username, c.Message().Video.FileID, fileURL)
-		return processHandler(c, fileURL)
-	})
+	os.Exit(lifecycle.Wait())
+}
 
-	bot.Start()
+// serve runs an HTTP server in the background. Shutdown begins if the
+// server fails
+func serve(lifecycle *Lifecycle, name string, server *http.Server) {
+	go func() {
+		slog.Info(name+" listening", "addr", server.Addr)
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			lifecycle.Fail(fmt.Errorf("%s: %w", name, err))
+		}
+	}()
+}
 
+// pruneHistory deletes transcripts older than retention from the history
+// every hour
+func pruneHistory(history *History, retention time.Duration) {
+	for ; ; time.Sleep(time.Hour) {
+		if count, err := history.Prune(time.Now().Add(-retention)); err != nil {
+			slog.Error("pruning history", "error", err)
+		} else if count > 0 {
+			slog.Info("pruned history", "count", count, "retention", retention)
+		}
+	}
 }
 
-// convertToWav converts an audio file to 16kHz WAV format
-func convertToWav(input, output string) error {
-	err := ffmpeg.Input(input).
-		Output(output, ffmpeg.KwArgs{"c:a": "pcm_s16le", "ar": "16000", "f": "wav"}).
-		OverWriteOutput().
-		Run()
+// modelFile returns the path of a model file, downloading the model if it
+// does not exist in modelspath
+func modelFile(ctx context.Context, progress io.Writer, modelspath, model string) (string, error) {
+	url, err := modeldownloader.URLForModel(model)
 	if err != nil {
-		log.Fatal(err)
-		return err
+		return "", err
 	}
-	return nil
-}
+	modelfile := filepath.Join(modelspath, filepath.Base(url))
+	info, err := os.Stat(modelfile)
 
-// TempFileName generates a temporary filename for use in testing or whatever
-func TempFileName(prefix, suffix string) string {
-	randBytes := make([]byte, 16)
-	rand.Read(randBytes)
-	return filepath.Join(os.TempDir(), prefix+hex.EncodeToString(randBytes)+suffix)
+	if err == nil && info.Size() > 0 {
+		slog.Info("using local model", "model", model, "path", modelfile)
+		return modelfile, nil
+	}
+
+	slog.Info("downloading model", "model", model, "url", url)
+	if !isInSet(model, modelNames) {
+		slog.Warn("unknown model", "model", model, "models", strings.Join(modelNames, ","))
+	}
+	// The downloader removes what it has written when the download fails
+	if _, err := modeldownloader.Download(ctx, progress, url, modelspath); err == nil {
+		fmt.Fprintln(progress, "Model downloaded")
+		return modelfile, nil
+	} else if errors.Is(err, context.Canceled) {
+		return "", fmt.Errorf("interrupted downloading model %s", model)
+	} else if errors.Is(err, context.DeadlineExceeded) {
+		return "", fmt.Errorf("timeout downloading model %s", model)
+	} else {
+		return "", err
+	}
 }
+
 func isInSet(value string, set []string) bool {
 	for _, v := range set {
 		if v == value {
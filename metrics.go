@@ -0,0 +1,195 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	// Packages
+	whisper "github.com/ggerganov/whisper.cpp/bindings/go/pkg/whisper"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Job statuses counted by the metrics
+const (
+	JobDone    = "done"
+	JobFailed  = "failed"
+	JobCached  = "cached"
+	JobInvalid = "invalid"
+)
+
+// Metrics are the prometheus metrics of the bot and API. All methods can be
+// called on a nil *Metrics, which records nothing
+type Metrics struct {
+	registry *prometheus.Registry
+	jobs     *prometheus.CounterVec
+	audio    prometheus.Counter
+	rtf      prometheus.Histogram
+	stages   *prometheus.HistogramVec
+	runs     *prometheus.HistogramVec
+	conf     *prometheus.HistogramVec
+	download *prometheus.CounterVec
+}
+
+// NewMetrics returns metrics which also report the queue depth and loaded
+// models of the model manager and the hits of the cache, which may be nil
+func NewMetrics(models *ModelManager, cache *Cache) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		jobs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "whisper_jobs_total",
+			Help: "Transcription jobs by status",
+		}, []string{"status"}),
+		audio: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "whisper_audio_seconds_total",
+			Help: "Length of the audio transcribed",
+		}),
+		rtf: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "whisper_real_time_factor",
+			Help:    "Processing time divided by the length of the audio",
+			Buckets: []float64{0.05, 0.1, 0.2, 0.3, 0.5, 0.75, 1, 1.5, 2, 5},
+		}),
+		stages: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "whisper_stage_seconds",
+			Help:    "Time spent in each stage of a job",
+			Buckets: prometheus.ExponentialBuckets(0.01, 2, 16),
+		}, []string{"stage"}),
+		runs: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "whisper_run_seconds",
+			Help:    "Mean time of one run of each whisper stage in a job, such as decoding a token",
+			Buckets: prometheus.ExponentialBuckets(0.0005, 2, 16),
+		}, []string{"stage"}),
+		conf: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "whisper_confidence",
+			Help:    "Mean token probability of each transcript and segment",
+			Buckets: []float64{0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9, 1},
+		}, []string{"scope"}),
+		download: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "whisper_download_bytes_total",
+			Help: "Bytes of media downloaded from telegram or uploaded to the API",
+		}, []string{"source"}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.jobs, m.audio, m.rtf, m.stages, m.runs, m.conf, m.download,
+	)
+	if models != nil {
+		m.registry.MustRegister(
+			prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+				Name: "whisper_queue_depth",
+				Help: "Jobs waiting for or using a model",
+			}, func() float64 { return float64(models.Pending()) }),
+			prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+				Name: "whisper_models_loaded",
+				Help: "Models loaded in memory",
+			}, func() float64 { return float64(len(models.Loaded())) }),
+		)
+	}
+	if cache != nil {
+		m.registry.MustRegister(
+			prometheus.NewCounterFunc(prometheus.CounterOpts{
+				Name: "whisper_cache_hits_total",
+				Help: "Transcripts returned from the cache",
+			}, func() float64 { return float64(cache.Stats().Hits) }),
+			prometheus.NewCounterFunc(prometheus.CounterOpts{
+				Name: "whisper_cache_misses_total",
+				Help: "Cache lookups which found nothing",
+			}, func() float64 { return float64(cache.Stats().Misses) }),
+			prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+				Name: "whisper_cache_entries",
+				Help: "Transcripts in the cache",
+			}, func() float64 { return float64(cache.Stats().Entries) }),
+			prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+				Name: "whisper_cache_bytes",
+				Help: "Size of the cache on disk",
+			}, func() float64 { return float64(cache.Stats().Size) }),
+		)
+	}
+	return m
+}
+
+// Scheduler also reports the jobs waiting for their turn with a scheduler
+func (m *Metrics) Scheduler(s *Scheduler) {
+	if m == nil {
+		return
+	}
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "whisper_jobs_waiting",
+		Help: "Jobs waiting for their turn with the scheduler",
+	}, func() float64 { return float64(s.Waiting()) }))
+}
+
+// Handler returns an HTTP handler which serves the metrics
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Job counts a job with a status
+func (m *Metrics) Job(status string) {
+	if m != nil {
+		m.jobs.WithLabelValues(status).Inc()
+	}
+}
+
+// Transcribed records the confidence, audio length and real time factor of a
+// transcript which took elapsed to produce
+func (m *Metrics) Transcribed(transcript *Transcript, elapsed time.Duration) {
+	if m == nil {
+		return
+	}
+	// Transcripts and segments without text have no confidence
+	if confidence := transcript.Confidence(); confidence.Mean > 0 {
+		m.conf.WithLabelValues("transcript").Observe(float64(confidence.Mean))
+	}
+	for _, segment := range transcript.Segments {
+		if confidence := segmentConfidence(segment); confidence.Mean > 0 {
+			m.conf.WithLabelValues("segment").Observe(float64(confidence.Mean))
+		}
+	}
+	if transcript.Duration <= 0 {
+		return
+	}
+	m.audio.Add(transcript.Duration.Seconds())
+	m.rtf.Observe(elapsed.Seconds() / transcript.Duration.Seconds())
+}
+
+// Stage records the time taken by a stage of a job
+func (m *Metrics) Stage(stage string, d time.Duration) {
+	if m != nil {
+		m.stages.WithLabelValues(stage).Observe(d.Seconds())
+	}
+}
+
+// Timings records the time whisper took as the "whisper" stage of a job, and
+// the mean time of one run of each of its own stages
+func (m *Metrics) Timings(t whisper.Timings) {
+	if m == nil {
+		return
+	}
+	if t.Total > 0 {
+		m.stages.WithLabelValues("whisper").Observe(t.Total.Seconds())
+	}
+	for _, stage := range []struct {
+		name string
+		d    time.Duration
+	}{
+		{"sample", t.Sample},
+		{"encode", t.Encode},
+		{"decode", t.Decode},
+		{"batchd", t.Batchd},
+		{"prompt", t.Prompt},
+	} {
+		if stage.d > 0 {
+			m.runs.WithLabelValues(stage.name).Observe(stage.d.Seconds())
+		}
+	}
+}
+
+// Downloaded counts bytes of media from a source
+func (m *Metrics) Downloaded(source string, bytes int64) {
+	if m != nil && bytes > 0 {
+		m.download.WithLabelValues(source).Add(float64(bytes))
+	}
+}
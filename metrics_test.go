@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	// Packages
+	whisper "github.com/ggerganov/whisper.cpp/bindings/go/pkg/whisper"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	fakewhisper "github.com/skrashevich/whisper.cpp-telegram/pkg/fake-whisper"
+	assert "github.com/stretchr/testify/assert"
+	"gopkg.in/telebot.v3"
+)
+
+func Test_Metrics_000(t *testing.T) {
+	assert := assert.New(t)
+
+	// A nil *Metrics records nothing
+	var metrics *Metrics
+	metrics.Job(JobDone)
+	metrics.Transcribed(&Transcript{Duration: 1}, 1)
+	metrics.Stage("load", 1)
+	metrics.Downloaded("telegram", 1)
+
+	bot := newTestBot(t, "Hello", "world")
+	bot.models.backend.(*fakewhisper.Backend).Timings = whisper.Timings{Encode: time.Second, Decode: time.Second / 2, Total: 2 * time.Second}
+	cache, err := OpenCache(t.TempDir(), 0)
+	assert.NoError(err)
+	metrics = NewMetrics(bot.models, cache)
+	bot.metrics, bot.models.metrics = metrics, metrics
+	bot.cache, bot.models.cache = cache, cache
+
+	for _, msg := range []*telebot.Message{
+		{Voice: &telebot.Voice{File: telebot.File{FileID: "voice", UniqueID: "voice", FileSize: 1000}}},
+		{Voice: &telebot.Voice{File: telebot.File{FileID: "voice", UniqueID: "voice", FileSize: 1000}}},
+		{Caption: "from 0:20 to 0:10", Voice: &telebot.Voice{File: telebot.File{FileID: "voice", UniqueID: "voice"}}},
+	} {
+		assert.NoError(bot.OnMedia(newFakeContext(msg)))
+	}
+	assert.Equal(1.0, testutil.ToFloat64(metrics.jobs.WithLabelValues(JobDone)))
+	assert.Equal(1.0, testutil.ToFloat64(metrics.jobs.WithLabelValues(JobCached)))
+	assert.Equal(1.0, testutil.ToFloat64(metrics.jobs.WithLabelValues(JobInvalid)))
+	assert.Equal(10.0, testutil.ToFloat64(metrics.audio))
+	assert.Equal(1000.0, testutil.ToFloat64(metrics.download.WithLabelValues("telegram")))
+	assert.Equal(1, testutil.CollectAndCount(metrics.rtf))
+	assert.Equal(3, testutil.CollectAndCount(metrics.stages))
+	assert.Equal(2, testutil.CollectAndCount(metrics.runs))
+
+	// Metrics are served in the prometheus text format
+	w := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(http.StatusOK, w.Code)
+	for _, line := range []string{
+		`whisper_jobs_total{status="done"} 1`,
+		`whisper_queue_depth 0`,
+		`whisper_models_loaded 1`,
+		`whisper_cache_hits_total 1`,
+		`whisper_stage_seconds_count{stage="whisper"} 1`,
+		`whisper_run_seconds_sum{stage="encode"} 1`,
+		`whisper_confidence_count{scope="transcript"} 1`,
+		`whisper_confidence_count{scope="segment"} 2`,
+		`whisper_confidence_bucket{scope="segment",le="0.9"} 2`,
+	} {
+		assert.True(strings.Contains(w.Body.String(), line+"\n"), line)
+	}
+}
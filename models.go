@@ -0,0 +1,301 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ModelManager keeps several whisper models loaded. Models are loaded on
+// first use, and the least recently used idle models are unloaded to keep
+// the total size of the loaded model files within the memory budget
+type ModelManager struct {
+	sync.Mutex
+	backend Backend
+	resolve func(name string) (string, error)
+	budget  int64
+	def     string
+	models  map[string]*managedModel
+
+	// Audio loader for the processors, or nil to use ffmpeg
+	loader AudioLoader
+
+	// Cache of transcripts and metrics shared by the processors, or nil
+	cache   *Cache
+	metrics *Metrics
+}
+
+type managedModel struct {
+	name string
+	wp   *WhisperProcessor
+
+	// Size of the model file, which is reserved in the budget while the
+	// model is loading
+	size     int64
+	users    int
+	lastUsed time.Time
+	ready    chan struct{}
+	err      error
+	closing  bool
+}
+
+// NewModelManager returns a manager which loads models with backend. The
+// resolve function returns the path of the file for a model name, which may
+// involve downloading it. A budget of zero means no limit
+func NewModelManager(backend Backend, resolve func(string) (string, error), budget int64, def string) *ModelManager {
+	return &ModelManager{
+		backend: backend,
+		resolve: resolve,
+		budget:  budget,
+		def:     def,
+		models:  make(map[string]*managedModel),
+	}
+}
+
+// Default returns the name of the model used when none is chosen
+func (m *ModelManager) Default() string {
+	m.Lock()
+	defer m.Unlock()
+	return m.def
+}
+
+// SetDefault loads a model and makes it the default. The previous default
+// model is unloaded once any jobs using it have finished
+func (m *ModelManager) SetDefault(name string) error {
+	_, release, err := m.Acquire(name)
+	if err != nil {
+		return err
+	}
+	release()
+
+	m.Lock()
+	old := m.def
+	m.def = name
+	m.Unlock()
+	if old != name {
+		m.Unload(old)
+	}
+	return nil
+}
+
+// Acquire returns the processor for a model, loading the model if necessary.
+// The empty name selects the default model. The release function must be
+// called when the job has finished, so the model can be unloaded if needed
+func (m *ModelManager) Acquire(name string) (*WhisperProcessor, func(), error) {
+	m.Lock()
+	if name == "" {
+		name = m.def
+	}
+	mm, exists := m.models[name]
+	if !exists {
+		mm = &managedModel{name: name, ready: make(chan struct{})}
+		m.models[name] = mm
+	}
+	mm.users++
+	m.Unlock()
+
+	if exists {
+		<-mm.ready
+	} else {
+		m.load(mm)
+	}
+	if mm.err != nil {
+		m.release(mm)
+		return nil, nil, mm.err
+	}
+	return mm.wp, func() { m.release(mm) }, nil
+}
+
+// Unload removes a model, closing it once any jobs using it have finished
+func (m *ModelManager) Unload(name string) bool {
+	m.Lock()
+	mm, exists := m.models[name]
+	if !exists {
+		m.Unlock()
+		return false
+	}
+	select {
+	case <-mm.ready:
+	default:
+		// Still loading, leave it to whoever is waiting
+		m.Unlock()
+		return false
+	}
+	delete(m.models, name)
+	mm.closing = true
+	idle := mm.users == 0
+	m.Unlock()
+
+	if idle {
+		m.close(mm)
+	}
+	return true
+}
+
+// Loaded returns the names of the loaded models, most recently used first
+func (m *ModelManager) Loaded() []string {
+	m.Lock()
+	defer m.Unlock()
+	models := m.loaded()
+	sort.Slice(models, func(i, j int) bool {
+		return models[i].lastUsed.After(models[j].lastUsed)
+	})
+	result := make([]string, 0, len(models))
+	for _, mm := range models {
+		result = append(result, mm.name)
+	}
+	return result
+}
+
+// Pending returns the number of jobs which are waiting for or using a model
+func (m *ModelManager) Pending() int {
+	m.Lock()
+	defer m.Unlock()
+	pending := 0
+	for _, mm := range m.models {
+		pending += mm.users
+	}
+	return pending
+}
+
+// Close unloads all models
+func (m *ModelManager) Close() error {
+	m.Lock()
+	names := make([]string, 0, len(m.models))
+	for name := range m.models {
+		names = append(names, name)
+	}
+	m.Unlock()
+	for _, name := range names {
+		m.Unload(name)
+	}
+	return nil
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// PRIVATE METHODS
+
+// load resolves and loads a model, making room for it within the budget
+func (m *ModelManager) load(mm *managedModel) {
+	defer close(mm.ready)
+
+	path, err := m.resolve(mm.name)
+	if err != nil {
+		m.failed(mm, err)
+		return
+	}
+	var size int64
+	if info, err := os.Stat(path); err == nil {
+		size = info.Size()
+	}
+
+	// The size is reserved before the lock is released, so models loading
+	// at the same time do not both fit themselves into the same room
+	m.Lock()
+	evicted := m.evict(size)
+	mm.size = size
+	m.Unlock()
+	for _, mm := range evicted {
+		m.close(mm)
+	}
+
+	wp := WPInit(m.backend)
+	wp.name, wp.cache, wp.metrics = mm.name, m.cache, m.metrics
+	if m.loader != nil {
+		wp.load = m.loader
+	}
+	if err := wp.LoadModel(path); err != nil {
+		m.failed(mm, err)
+		return
+	}
+	slog.Info("model loaded", "model", mm.name, "size", size)
+
+	m.Lock()
+	mm.wp, mm.lastUsed = wp, time.Now()
+	m.Unlock()
+}
+
+func (m *ModelManager) failed(mm *managedModel, err error) {
+	m.Lock()
+	defer m.Unlock()
+	mm.err = fmt.Errorf("model %s: %w", mm.name, err)
+	if m.models[mm.name] == mm {
+		delete(m.models, mm.name)
+	}
+}
+
+func (m *ModelManager) release(mm *managedModel) {
+	m.Lock()
+	mm.users--
+	mm.lastUsed = time.Now()
+	idle := mm.closing && mm.users == 0
+	m.Unlock()
+
+	if idle {
+		m.close(mm)
+	}
+}
+
+// evict removes idle models, least recently used first, until there is room
+// for another size bytes, and returns them to be closed once the lock is
+// released. Models which are loading count with their reserved size. It
+// must be called with the lock held
+func (m *ModelManager) evict(size int64) []*managedModel {
+	if m.budget <= 0 {
+		return nil
+	}
+	var used int64
+	for _, mm := range m.models {
+		used += mm.size
+	}
+	models := m.loaded()
+	sort.Slice(models, func(i, j int) bool {
+		return models[i].lastUsed.Before(models[j].lastUsed)
+	})
+	var evicted []*managedModel
+	for _, mm := range models {
+		if used+size <= m.budget {
+			return evicted
+		}
+		if mm.users > 0 {
+			continue
+		}
+		slog.Info("unloading model to stay within the memory budget", "model", mm.name)
+		delete(m.models, mm.name)
+		evicted = append(evicted, mm)
+		used -= mm.size
+	}
+	if used+size > m.budget {
+		slog.Warn("loaded models exceed the memory budget", "over", used+size-m.budget)
+	}
+	return evicted
+}
+
+// loaded returns the models which have finished loading. It must be called
+// with the lock held
+func (m *ModelManager) loaded() []*managedModel {
+	result := make([]*managedModel, 0, len(m.models))
+	for _, mm := range m.models {
+		if mm.wp != nil {
+			result = append(result, mm)
+		}
+	}
+	return result
+}
+
+// close closes the processor of a model which has been removed. It must be
+// called without the lock held, as closing waits for the processor to be
+// idle
+func (m *ModelManager) close(mm *managedModel) {
+	if mm.wp != nil {
+		if err := mm.wp.Close(); err != nil {
+			slog.Error("closing model", "model", mm.name, "error", err)
+		} else {
+			slog.Info("model unloaded", "model", mm.name)
+		}
+		mm.wp = nil
+	}
+}
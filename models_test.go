@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	// Packages
+	fakewhisper "github.com/skrashevich/whisper.cpp-telegram/pkg/fake-whisper"
+	assert "github.com/stretchr/testify/assert"
+)
+
+// newTestModels returns a model manager over the fake backend, with
+// ggml-tiny as the default model. Every model reads ten seconds of silence
+// for any file
+func newTestModels(t *testing.T, texts ...string) (*ModelManager, *fakewhisper.Backend) {
+	t.Helper()
+	backend := fakewhisper.New(fakewhisper.Segments(2*time.Second, texts...)...)
+	models := NewModelManager(backend, func(name string) (string, error) {
+		return name + ".bin", nil
+	}, 0, "ggml-tiny")
+	models.loader = func(context.Context, string) ([]float32, error) {
+		return seconds(10), nil
+	}
+	return models, backend
+}
+
+// modelFiles returns a resolver for model files of the given sizes
+func modelFiles(t *testing.T, sizes map[string]int64) func(string) (string, error) {
+	dir := t.TempDir()
+	for name, size := range sizes {
+		path := filepath.Join(dir, name+".bin")
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Truncate(path, size); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return func(name string) (string, error) {
+		if _, exists := sizes[name]; !exists {
+			return "", os.ErrNotExist
+		}
+		return filepath.Join(dir, name+".bin"), nil
+	}
+}
+
+func Test_Models_000(t *testing.T) {
+	assert := assert.New(t)
+	models, backend := newTestModels(t, "Hello")
+
+	// Models are loaded once, on first use
+	assert.Empty(models.Loaded())
+	for i := 0; i < 2; i++ {
+		wp, release, err := models.Acquire("")
+		assert.NoError(err)
+		assert.NotNil(wp)
+		release()
+	}
+	assert.Equal([]string{"ggml-tiny.bin"}, backend.Loaded)
+	assert.Equal([]string{"ggml-tiny"}, models.Loaded())
+
+	_, release, err := models.Acquire("ggml-base")
+	assert.NoError(err)
+	release()
+	assert.Equal([]string{"ggml-base", "ggml-tiny"}, models.Loaded())
+}
+
+func Test_Models_001(t *testing.T) {
+	assert := assert.New(t)
+	backend := fakewhisper.New()
+	models := NewModelManager(backend, modelFiles(t, map[string]int64{
+		"ggml-tiny": 100, "ggml-base": 200, "ggml-small": 300,
+	}), 500, "ggml-tiny")
+
+	// The least recently used idle model is unloaded to make room
+	for _, name := range []string{"ggml-tiny", "ggml-base"} {
+		_, release, err := models.Acquire(name)
+		assert.NoError(err)
+		release()
+		time.Sleep(time.Millisecond)
+	}
+	_, release, err := models.Acquire("ggml-small")
+	assert.NoError(err)
+	release()
+	assert.Equal([]string{"ggml-small", "ggml-base"}, models.Loaded())
+
+	// Models in use are not unloaded, even if that exceeds the budget
+	base, releaseBase, err := models.Acquire("ggml-base")
+	assert.NoError(err)
+	time.Sleep(time.Millisecond)
+	_, releaseTiny, err := models.Acquire("ggml-tiny")
+	assert.NoError(err)
+	assert.Equal([]string{"ggml-tiny", "ggml-base"}, models.Loaded())
+	assert.NotNil(base.model)
+	releaseBase()
+	releaseTiny()
+
+	// Unknown models fail to resolve
+	_, _, err = models.Acquire("ggml-huge")
+	assert.ErrorIs(err, os.ErrNotExist)
+	assert.NotContains(models.Loaded(), "ggml-huge")
+}
+
+func Test_Models_002(t *testing.T) {
+	assert := assert.New(t)
+	models, backend := newTestModels(t, "Hello")
+
+	// A job keeps the old default model loaded until it has finished
+	old, release, err := models.Acquire("")
+	assert.NoError(err)
+	model := old.model.(*fakewhisper.Model)
+	assert.NoError(models.SetDefault("ggml-base"))
+	assert.Equal("ggml-base", models.Default())
+	assert.Equal([]string{"ggml-base"}, models.Loaded())
+	assert.False(model.Closed())
+	release()
+	assert.True(model.Closed())
+	assert.Equal([]string{"ggml-tiny.bin", "ggml-base.bin"}, backend.Loaded)
+
+	// A model which fails to load leaves the default as it was
+	backend.LoadErr = errors.New("out of memory")
+	assert.ErrorIs(models.SetDefault("ggml-small"), backend.LoadErr)
+	assert.Equal("ggml-base", models.Default())
+
+	// Close unloads everything
+	assert.NoError(models.Close())
+	assert.Empty(models.Loaded())
+}
+
+func Test_Models_003(t *testing.T) {
+	assert := assert.New(t)
+	backend := fakewhisper.New()
+	models := NewModelManager(backend, modelFiles(t, map[string]int64{
+		"ggml-tiny": 100, "ggml-base": 300, "ggml-small": 300,
+	}), 500, "ggml-tiny")
+	_, release, err := models.Acquire("ggml-tiny")
+	assert.NoError(err)
+	release()
+
+	// A model which is loading has its size reserved, so a model loading at
+	// the same time makes room for both of them
+	reserved := func(name string) func() bool {
+		return func() bool {
+			models.Lock()
+			defer models.Unlock()
+			mm, exists := models.models[name]
+			return exists && mm.size > 0
+		}
+	}
+	var wg sync.WaitGroup
+	backend.Lock()
+	for _, name := range []string{"ggml-base", "ggml-small"} {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			_, release, err := models.Acquire(name)
+			assert.NoError(err)
+			release()
+		}(name)
+		assert.Eventually(reserved(name), time.Second, time.Millisecond)
+	}
+	assert.Empty(models.Loaded())
+	backend.Unlock()
+	wg.Wait()
+	assert.ElementsMatch([]string{"ggml-base", "ggml-small"}, models.Loaded())
+}
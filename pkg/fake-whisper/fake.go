@@ -0,0 +1,333 @@
+package fakewhisper
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	// Packages
+	whisper "github.com/ggerganov/whisper.cpp/bindings/go/pkg/whisper"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// CONSTANTS
+
+// Special token ids, text tokens are always below TokenEOT
+const (
+	TokenEOT  = 50256
+	TokenSOT  = 50257
+	TokenPREV = 50360
+	TokenSOLM = 50361
+	TokenNOT  = 50362
+	TokenBEG  = 50363
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// TYPES
+
+// Backend is a whisper backend which returns scripted segments instead of
+// running a model, so that everything above the whisper bindings can be
+// tested on a machine without model files
+type Backend struct {
+	sync.Mutex
+
+	// Segments returned by every context created from this backend
+	Segments []whisper.Segment
+
+	// Languages supported, the first one is used when auto-detecting
+	Languages []string
+
+	// Error returned from Load or Process when set
+	LoadErr, ProcessErr error
+
+	// Timings reported after each call to Process
+	Timings whisper.Timings
+
+	// Time taken to decode each segment
+	Delay time.Duration
+
+	// Models loaded so far, in order
+	Loaded []string
+
+	// Contexts created so far, in order
+	Contexts []*Context
+}
+
+// Model is a fake whisper.Model
+type Model struct {
+	backend *Backend
+	path    string
+	closed  bool
+}
+
+// Context is a fake whisper.Context which records the parameters set on it
+type Context struct {
+	model *Model
+	n     int
+	segs  []whisper.Segment
+
+	language       string
+	translate      bool
+	offset         time.Duration
+	duration       time.Duration
+	threads        uint
+	nocontext      bool
+	tokenThreshold float32
+	tokenSum       float32
+	maxLen         uint
+	maxTokens      uint
+	tokenTS        bool
+	speakerTurns   bool
+	processed      int
+	timings        whisper.Timings
+}
+
+// Make sure the fakes adhere to the interfaces
+var _ whisper.Model = (*Model)(nil)
+var _ whisper.Context = (*Context)(nil)
+
+///////////////////////////////////////////////////////////////////////////////
+// LIFECYCLE
+
+// New returns a backend which produces the given segments
+func New(segments ...whisper.Segment) *Backend {
+	return &Backend{
+		Segments:  segments,
+		Languages: []string{"en", "de", "es", "ru"},
+	}
+}
+
+// Load returns a fake model for the path, which does not need to exist
+func (backend *Backend) Load(path string) (whisper.Model, error) {
+	backend.Lock()
+	defer backend.Unlock()
+	if backend.LoadErr != nil {
+		return nil, backend.LoadErr
+	}
+	backend.Loaded = append(backend.Loaded, path)
+	return &Model{backend: backend, path: path}, nil
+}
+
+func (model *Model) Close() error {
+	model.closed = true
+	return nil
+}
+
+// Closed returns true if the model has been closed
+func (model *Model) Closed() bool {
+	return model.closed
+}
+
+// Path returns the path the model was loaded from
+func (model *Model) Path() string {
+	return model.path
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// SCRIPTS
+
+// Segments returns one segment per text, each lasting step and split into
+// word tokens. Words longer than six characters are split into two tokens
+// so that callers can exercise token merging. Every text token has
+// probability 0.9
+func Segments(step time.Duration, texts ...string) []whisper.Segment {
+	result := make([]whisper.Segment, 0, len(texts))
+	for i, text := range texts {
+		start := time.Duration(i) * step
+		result = append(result, Segment(i, start, start+step, text))
+	}
+	return result
+}
+
+// Segment returns a single segment with tokens evenly spread between start
+// and end
+func Segment(num int, start, end time.Duration, text string) whisper.Segment {
+	var pieces []string
+	for _, word := range strings.Fields(text) {
+		if len(word) > 6 {
+			pieces = append(pieces, " "+word[:4], word[4:])
+		} else {
+			pieces = append(pieces, " "+word)
+		}
+	}
+
+	tokens := []whisper.Token{{Id: TokenBEG, Text: "[_BEG_]", P: 1, Start: start, End: start}}
+	if len(pieces) > 0 {
+		step := (end - start) / time.Duration(len(pieces))
+		for i, piece := range pieces {
+			tokens = append(tokens, whisper.Token{
+				Id:    100 + i,
+				Text:  piece,
+				P:     0.9,
+				Start: start + time.Duration(i)*step,
+				End:   start + time.Duration(i+1)*step,
+			})
+		}
+	}
+	tokens = append(tokens, whisper.Token{Id: TokenBEG, Text: fmt.Sprintf("[_TT_%d]", end.Milliseconds()/10), P: 1, Start: end, End: end})
+
+	return whisper.Segment{
+		Num:    num,
+		Start:  start,
+		End:    end,
+		Text:   strings.TrimSpace(text),
+		Tokens: tokens,
+	}
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// MODEL
+
+func (model *Model) NewContext() (whisper.Context, error) {
+	if model.closed {
+		return nil, whisper.ErrInternalAppError
+	}
+	context := &Context{model: model, language: "en", nocontext: true}
+	model.backend.Lock()
+	model.backend.Contexts = append(model.backend.Contexts, context)
+	model.backend.Unlock()
+	return context, nil
+}
+
+func (model *Model) IsMultilingual() bool {
+	return len(model.backend.Languages) > 1
+}
+
+func (model *Model) Languages() []string {
+	return model.backend.Languages
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// CONTEXT
+
+func (context *Context) SetLanguage(lang string) error {
+	if !context.model.IsMultilingual() {
+		return whisper.ErrModelNotMultilingual
+	}
+	if lang != "auto" {
+		found := false
+		for _, l := range context.model.backend.Languages {
+			if l == lang {
+				found = true
+			}
+		}
+		if !found {
+			return whisper.ErrUnsupportedLanguage
+		}
+	}
+	context.language = lang
+	return nil
+}
+
+func (context *Context) IsMultilingual() bool {
+	return context.model.IsMultilingual()
+}
+
+func (context *Context) Language() string {
+	return context.language
+}
+
+func (context *Context) SetTranslate(v bool)            { context.translate = v }
+func (context *Context) SetOffset(v time.Duration)      { context.offset = v }
+func (context *Context) SetDuration(v time.Duration)    { context.duration = v }
+func (context *Context) SetThreads(v uint)              { context.threads = v }
+func (context *Context) SetNoContext(v bool)            { context.nocontext = v }
+func (context *Context) SetTokenThreshold(v float32)    { context.tokenThreshold = v }
+func (context *Context) SetTokenSumThreshold(v float32) { context.tokenSum = v }
+func (context *Context) SetMaxSegmentLength(v uint)     { context.maxLen = v }
+func (context *Context) SetTokenTimestamps(v bool)      { context.tokenTS = v }
+func (context *Context) SetMaxTokensPerSegment(v uint)  { context.maxTokens = v }
+func (context *Context) SetSpeakerTurns(v bool)         { context.speakerTurns = v }
+func (context *Context) Translate() bool                { return context.translate }
+func (context *Context) Offset() time.Duration          { return context.offset }
+func (context *Context) Duration() time.Duration        { return context.duration }
+func (context *Context) Threads() uint                  { return context.threads }
+func (context *Context) NoContext() bool                { return context.nocontext }
+func (context *Context) TokenThreshold() float32        { return context.tokenThreshold }
+func (context *Context) MaxSegmentLength() uint         { return context.maxLen }
+func (context *Context) MaxTokensPerSegment() uint      { return context.maxTokens }
+func (context *Context) TokenTimestamps() bool          { return context.tokenTS }
+func (context *Context) SpeakerTurns() bool             { return context.speakerTurns }
+func (context *Context) ProcessedSamples() int          { return context.processed }
+
+// Process emits the scripted segments which fall inside both the audio and
+// the offset/duration window, in the same way whisper does. Speaker turns
+// are only reported when they are enabled
+func (context *Context) Process(data []float32, cb whisper.SegmentCallback, progress whisper.ProgressCallback, abort whisper.AbortCallback) error {
+	if context.model.closed {
+		return whisper.ErrInternalAppError
+	}
+	if err := context.model.backend.ProcessErr; err != nil {
+		return err
+	}
+	context.processed = len(data)
+
+	// Determine the window to process
+	length := time.Duration(len(data)) * time.Second / whisper.SampleRate
+	from, to := context.offset, length
+	if context.duration > 0 && from+context.duration < to {
+		to = from + context.duration
+	}
+
+	// Emit segments
+	context.n = 0
+	context.segs = context.segs[:0]
+	segments := context.model.backend.Segments
+	for i, segment := range segments {
+		if abort != nil && abort() {
+			return whisper.ErrProcessingAborted
+		}
+		if segment.Start >= from && segment.Start < to {
+			time.Sleep(context.model.backend.Delay)
+			segment.Num = len(context.segs)
+			segment.SpeakerTurnNext = segment.SpeakerTurnNext && context.speakerTurns
+			context.segs = append(context.segs, segment)
+			if cb != nil {
+				cb(segment)
+			}
+		}
+		if progress != nil {
+			progress((i + 1) * 100 / len(segments))
+		}
+	}
+
+	// Return success
+	context.timings = context.model.backend.Timings
+	return nil
+}
+
+func (context *Context) NextSegment() (whisper.Segment, error) {
+	if context.n >= len(context.segs) {
+		return whisper.Segment{}, io.EOF
+	}
+	segment := context.segs[context.n]
+	context.n++
+	return segment, nil
+}
+
+func (context *Context) IsBEG(t whisper.Token) bool  { return t.Id == TokenBEG }
+func (context *Context) IsSOT(t whisper.Token) bool  { return t.Id == TokenSOT }
+func (context *Context) IsEOT(t whisper.Token) bool  { return t.Id == TokenEOT }
+func (context *Context) IsPREV(t whisper.Token) bool { return t.Id == TokenPREV }
+func (context *Context) IsSOLM(t whisper.Token) bool { return t.Id == TokenSOLM }
+func (context *Context) IsNOT(t whisper.Token) bool  { return t.Id == TokenNOT }
+func (context *Context) IsText(t whisper.Token) bool { return t.Id < TokenEOT }
+
+func (context *Context) IsLANG(t whisper.Token, lang string) bool {
+	for i, l := range context.model.backend.Languages {
+		if l == lang {
+			return t.Id == TokenSOT+1+i
+		}
+	}
+	return false
+}
+
+func (context *Context) PrintTimings()            {}
+func (context *Context) ResetTimings()            { context.timings = whisper.Timings{} }
+func (context *Context) Timings() whisper.Timings { return context.timings }
+
+func (context *Context) SystemInfo() string {
+	return "system_info: fake whisper backend\n"
+}
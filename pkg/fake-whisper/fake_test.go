@@ -0,0 +1,114 @@
+package fakewhisper_test
+
+import (
+	"testing"
+	"time"
+
+	// Packages
+	whisper "github.com/ggerganov/whisper.cpp/bindings/go/pkg/whisper"
+	fakewhisper "github.com/skrashevich/whisper.cpp-telegram/pkg/fake-whisper"
+	assert "github.com/stretchr/testify/assert"
+)
+
+func seconds(n int) []float32 {
+	return make([]float32, n*whisper.SampleRate)
+}
+
+func Test_Fake_000(t *testing.T) {
+	assert := assert.New(t)
+
+	segment := fakewhisper.Segment(0, 0, 3*time.Second, "hello transcription world")
+	assert.Equal("hello transcription world", segment.Text)
+
+	var text []string
+	for _, token := range segment.Tokens {
+		if token.Id < fakewhisper.TokenEOT {
+			text = append(text, token.Text)
+		}
+	}
+	assert.Equal([]string{" hello", " tran", "scription", " world"}, text)
+	assert.Equal(time.Duration(0), segment.Tokens[1].Start)
+	assert.Equal(3*time.Second, segment.Tokens[4].End)
+}
+
+func Test_Fake_001(t *testing.T) {
+	assert := assert.New(t)
+	backend := fakewhisper.New(fakewhisper.Segments(time.Second, "one", "two", "three", "four")...)
+
+	model, err := backend.Load("ggml-tiny.bin")
+	assert.NoError(err)
+	assert.Equal([]string{"ggml-tiny.bin"}, backend.Loaded)
+	context, err := model.NewContext()
+	assert.NoError(err)
+
+	// Segments past the end of the audio are not returned
+	assert.NoError(context.Process(seconds(3), nil, nil, nil))
+	var texts []string
+	for {
+		segment, err := context.NextSegment()
+		if err != nil {
+			break
+		}
+		texts = append(texts, segment.Text)
+	}
+	assert.Equal([]string{"one", "two", "three"}, texts)
+
+	// Offset and duration select a window
+	context.SetOffset(time.Second)
+	context.SetDuration(2 * time.Second)
+	var cbTexts []string
+	assert.NoError(context.Process(seconds(4), func(s whisper.Segment) {
+		cbTexts = append(cbTexts, s.Text)
+	}, nil, nil))
+	assert.Equal([]string{"two", "three"}, cbTexts)
+}
+
+func Test_Fake_002(t *testing.T) {
+	assert := assert.New(t)
+	backend := fakewhisper.New(fakewhisper.Segments(time.Second, "one", "two", "three", "four")...)
+	model, _ := backend.Load("ggml-tiny.bin")
+	context, _ := model.NewContext()
+
+	var progress []int
+	assert.NoError(context.Process(seconds(4), nil, func(p int) {
+		progress = append(progress, p)
+	}, nil))
+	assert.Equal([]int{25, 50, 75, 100}, progress)
+
+	calls := 0
+	err := context.Process(seconds(4), nil, nil, func() bool {
+		calls++
+		return calls > 2
+	})
+	assert.ErrorIs(err, whisper.ErrProcessingAborted)
+}
+
+func Test_Fake_003(t *testing.T) {
+	assert := assert.New(t)
+	backend := fakewhisper.New()
+	model, _ := backend.Load("ggml-tiny.bin")
+	context, _ := model.NewContext()
+
+	assert.NoError(context.SetLanguage("de"))
+	assert.Equal("de", context.Language())
+	assert.ErrorIs(context.SetLanguage("xx"), whisper.ErrUnsupportedLanguage)
+
+	assert.NoError(model.Close())
+	_, err := model.NewContext()
+	assert.Error(err)
+}
+
+func Test_Fake_004(t *testing.T) {
+	assert := assert.New(t)
+	backend := fakewhisper.New(fakewhisper.Segments(time.Second, "Hello")...)
+	backend.Timings = whisper.Timings{Encode: time.Second, Decode: 500 * time.Millisecond}
+	model, _ := backend.Load("ggml-tiny.bin")
+	context, _ := model.NewContext()
+
+	// Timings are reported after processing until reset
+	assert.Equal(whisper.Timings{}, context.Timings())
+	assert.NoError(context.Process(make([]float32, whisper.SampleRate), nil, nil, nil))
+	assert.Equal(backend.Timings, context.Timings())
+	context.ResetTimings()
+	assert.Equal(whisper.Timings{}, context.Timings())
+}
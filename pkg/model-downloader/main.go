@@ -2,6 +2,7 @@ package modeldownloader
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -10,7 +11,6 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
-	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -86,6 +86,10 @@ func Download(ctx context.Context, p io.Writer, model, out string) (string, erro
 		return "", fmt.Errorf("%s: %s", model, resp.Status)
 	}
 
+	if resp.ContentLength <= 0 {
+		return "", fmt.Errorf("%s: unknown content length", model)
+	}
+
 	path := filepath.Join(out, filepath.Base(model))
 	info, err := os.Stat(path)
 
@@ -94,7 +98,11 @@ func Download(ctx context.Context, p io.Writer, model, out string) (string, erro
 		return path, nil
 	}
 
-	file, err := os.Create(path)
+	// The parts are written to a temporary file, which is renamed when every
+	// part has been downloaded, so a failed download never leaves a partial
+	// model behind
+	temp := path + ".part"
+	file, err := os.Create(temp)
 	if err != nil {
 		return "", err
 	}
@@ -102,60 +110,101 @@ func Download(ctx context.Context, p io.Writer, model, out string) (string, erro
 
 	fmt.Fprintln(p, "Downloading", model, "to", out)
 
+	// The first part to fail cancels the others
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	partSize := resp.ContentLength / NumParts
-	var wg sync.WaitGroup
+	errs := make(chan error, NumParts)
 	var count int64 = 0
 
 	for i := 0; i < NumParts; i++ {
-		wg.Add(1)
-
 		start := int64(i) * partSize
-		end := start + partSize
+		end := start + partSize - 1
 
 		if i == NumParts-1 {
-			end = resp.ContentLength
+			end = resp.ContentLength - 1
 		}
 
-		go func(partNumber int, start int64, end int64) {
-			defer wg.Done()
-
-			req, _ := http.NewRequest("GET", model, nil)
-			req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
-			resp, _ := client.Do(req)
-			defer resp.Body.Close()
-
-			buf := make([]byte, bufSize)
-			ticker := time.NewTicker(5 * time.Second)
-			for {
-				select {
-				case <-ctx.Done():
-					return
-				case <-ticker.C:
-					// Print out progress
-					progress := float64(atomic.LoadInt64(&count)) / float64(resp.ContentLength) * 100 / NumParts
-					fmt.Printf("Download progress: %.2f%%\n", progress)
-				default:
-					n, err := resp.Body.Read(buf)
-					if err != nil {
-						return
-					}
-					_, err = file.WriteAt(buf[:n], start)
-					if err != nil {
-						return
-					}
-					atomic.AddInt64(&count, int64(n))
-					start += int64(n)
+		go func(start int64, end int64) {
+			errs <- downloadPart(ctx, &client, file, model, start, end, &count, resp.ContentLength)
+		}(start, end)
+	}
 
-				}
-			}
-		}(i, start, end)
+	for i := 0; i < NumParts; i++ {
+		if partErr := <-errs; partErr != nil && err == nil {
+			err = partErr
+			cancel()
+		}
+	}
+	if err == nil {
+		err = file.Close()
 	}
+	if err == nil {
+		err = os.Rename(temp, path)
+	}
+	if err != nil {
+		os.Remove(temp)
 
-	wg.Wait()
+		// Parts which were cancelled fail with the reason wrapped in a URL error
+		if ctxErr := ctx.Err(); ctxErr != nil && errors.Is(err, ctxErr) {
+			return "", ctxErr
+		}
+		return "", err
+	}
 
 	return path, nil
 }
 
+// downloadPart downloads the bytes from start to end inclusive of the model
+// into the file, and adds the number of bytes to count
+func downloadPart(ctx context.Context, client *http.Client, file *os.File, model string, start, end int64, count *int64, total int64) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", model, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("%s: %s", model, resp.Status)
+	}
+
+	buf := make([]byte, bufSize)
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			// Print out progress
+			progress := float64(atomic.LoadInt64(count)) / float64(total) * 100
+			fmt.Printf("Download progress: %.2f%%\n", progress)
+		default:
+			n, err := resp.Body.Read(buf)
+			if n > 0 {
+				if _, err := file.WriteAt(buf[:n], start); err != nil {
+					return err
+				}
+				atomic.AddInt64(count, int64(n))
+				start += int64(n)
+			}
+			if err == io.EOF {
+				if start <= end {
+					return fmt.Errorf("%s: %w", model, io.ErrUnexpectedEOF)
+				}
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+	}
+}
+
 // ContextForSignal returns a context object which is cancelled when a signal
 // is received. It returns nil if no signal parameter is provided
 func ContextForSignal(signals ...os.Signal) context.Context {
@@ -163,7 +212,7 @@ func ContextForSignal(signals ...os.Signal) context.Context {
 		return nil
 	}
 
-	ch := make(chan os.Signal)
+	ch := make(chan os.Signal, 1)
 	ctx, cancel := context.WithCancel(context.Background())
 
 	// Send message on channel when signal received
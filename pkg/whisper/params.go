@@ -43,8 +43,9 @@ func (p *Params) SetPrintTimestamps(v bool) {
 	p.print_timestamps = toBool(v)
 }
 
-func (p *Params) SetSpeedup(v bool) {
-	p.speed_up = toBool(v)
+// Set tinydiarize speaker turn detection, for models trained with it
+func (p *Params) SetTdrzEnable(v bool) {
+	p.tdrz_enable = toBool(v)
 }
 
 func (p *Params) SetNocontext(v bool) {
@@ -165,9 +166,6 @@ func (p *Params) String() string {
 	if p.token_timestamps {
 		str += " token_timestamps"
 	}
-	if p.speed_up {
-		str += " speed_up"
-	}
 
 	return str + ">"
 }
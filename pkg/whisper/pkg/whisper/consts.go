@@ -14,6 +14,7 @@ var (
 	ErrUnableToLoadModel    = errors.New("unable to load model")
 	ErrInternalAppError     = errors.New("internal application error")
 	ErrProcessingFailed     = errors.New("processing failed")
+	ErrProcessingAborted    = errors.New("processing aborted")
 	ErrUnsupportedLanguage  = errors.New("unsupported language")
 	ErrModelNotMultilingual = errors.New("model is not multilingual")
 )
@@ -18,6 +18,7 @@ type context struct {
 	n      int
 	model  *model
 	params whisper.Params
+	total  time.Duration
 }
 
 // Make sure context adheres to the interface
@@ -76,11 +77,6 @@ func (context *context) SetTranslate(v bool) {
 	context.params.SetTranslate(v)
 }
 
-// Set speedup flag
-func (context *context) SetSpeedup(v bool) {
-	context.params.SetSpeedup(v)
-}
-
 // Set no_context flag
 func (context *context) SetNoContext(v bool) {
 	context.params.SetNocontext(v)
@@ -98,7 +94,7 @@ func (context *context) SetOffset(v time.Duration) {
 
 // Set duration of audio to process
 func (context *context) SetDuration(v time.Duration) {
-	context.params.SetOffset(int(v.Milliseconds()))
+	context.params.SetDuration(int(v.Milliseconds()))
 }
 
 // Set timestamp token probability threshold (~0.01)
@@ -126,6 +122,11 @@ func (context *context) SetMaxTokensPerSegment(n uint) {
 	context.params.SetMaxTokensPerSegment(int(n))
 }
 
+// Set tinydiarize speaker turn detection flag
+func (context *context) SetSpeakerTurns(v bool) {
+	context.params.SetTdrzEnable(v)
+}
+
 // ResetTimings resets the mode timings. Should be called before processing
 func (context *context) ResetTimings() {
 	//fmt.Printf("Context.model: %v", *context.model)
@@ -143,6 +144,23 @@ func (context *context) PrintTimings() {
 	context.model.ctx.Whisper_print_timings()
 }
 
+// Timings returns the mean time of one run of each stage in the last call
+// to Process, and the total time of the call
+func (context *context) Timings() Timings {
+	timings := Timings{Total: context.total}
+	if context.model.ctx == nil {
+		return timings
+	}
+	if t := context.model.ctx.Whisper_get_timings(); t != nil {
+		timings.Sample = msToDuration(t.SampleMs())
+		timings.Encode = msToDuration(t.EncodeMs())
+		timings.Decode = msToDuration(t.DecodeMs())
+		timings.Batchd = msToDuration(t.BatchdMs())
+		timings.Prompt = msToDuration(t.PromptMs())
+	}
+	return timings
+}
+
 // SystemInfo returns the system information
 func (context *context) SystemInfo() string {
 	return fmt.Sprintf("system_info: n_threads = %d / %d | %s\n",
@@ -164,7 +182,7 @@ func (context *context) WhisperLangAutoDetect(offset_ms int, n_threads int) ([]f
 }
 
 // Process new sample data and return any errors
-func (context *context) Process(data []float32, cb SegmentCallback) error {
+func (context *context) Process(data []float32, cb SegmentCallback, progress ProgressCallback, abort AbortCallback) error {
 	if context.model.ctx == nil {
 		return ErrInternalAppError
 	}
@@ -173,21 +191,8 @@ func (context *context) Process(data []float32, cb SegmentCallback) error {
 		context.params.SetSingleSegment(true)
 	}
 
-	// We don't do parallel processing at the moment
-	processors := context.params.Threads() * 0
-	if processors > 1 {
-		if err := context.model.ctx.Whisper_full_parallel(context.params, data, processors, nil, func(new int) {
-			if cb != nil {
-				num_segments := context.model.ctx.Whisper_full_n_segments()
-				s0 := num_segments - new
-				for i := s0; i < num_segments; i++ {
-					cb(toSegment(context.model.ctx, i))
-				}
-			}
-		}); err != nil {
-			return err
-		}
-	} else if err := context.model.ctx.Whisper_full(context.params, data, nil, func(new int) {
+	// Segment callback
+	newSegment := func(new int) {
 		if cb != nil {
 			num_segments := context.model.ctx.Whisper_full_n_segments()
 			s0 := num_segments - new
@@ -195,7 +200,37 @@ func (context *context) Process(data []float32, cb SegmentCallback) error {
 				cb(toSegment(context.model.ctx, i))
 			}
 		}
-	}); err != nil {
+	}
+
+	// Abort is checked both before the encoder starts and during the
+	// computation, and once it has fired it keeps reporting true
+	aborted := false
+	var encoderBegin, abortCallback func() bool
+	if abort != nil {
+		abortCallback = func() bool {
+			if !aborted && abort() {
+				aborted = true
+			}
+			return aborted
+		}
+		encoderBegin = func() bool {
+			return !abortCallback()
+		}
+	}
+
+	// We don't do parallel processing at the moment
+	var err error
+	start := time.Now()
+	defer func() { context.total = time.Since(start) }()
+	processors := context.params.Threads() * 0
+	if processors > 1 {
+		err = context.model.ctx.Whisper_full_parallel(context.params, data, processors, encoderBegin, newSegment, progress, abortCallback)
+	} else {
+		err = context.model.ctx.Whisper_full(context.params, data, encoderBegin, newSegment, progress, abortCallback)
+	}
+	if aborted {
+		return ErrProcessingAborted
+	} else if err != nil {
 		return err
 	}
 
@@ -291,6 +326,8 @@ func toSegment(ctx *whisper.Context, n int) Segment {
 		Start:  time.Duration(ctx.Whisper_full_get_segment_t0(n)) * time.Millisecond * 10,
 		End:    time.Duration(ctx.Whisper_full_get_segment_t1(n)) * time.Millisecond * 10,
 		Tokens: toTokens(ctx, n),
+
+		SpeakerTurnNext: ctx.Whisper_full_get_segment_speaker_turn_next(n),
 	}
 }
 
@@ -309,3 +346,7 @@ func toTokens(ctx *whisper.Context, n int) []Token {
 	}
 	return result
 }
+
+func msToDuration(ms float32) time.Duration {
+	return time.Duration(float64(ms) * float64(time.Millisecond))
+}
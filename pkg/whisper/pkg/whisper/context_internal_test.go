@@ -0,0 +1,40 @@
+package whisper
+
+import (
+	"testing"
+	"time"
+
+	// Packages
+	assert "github.com/stretchr/testify/assert"
+)
+
+func Test_Context_000(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, test := range []struct {
+		offset, duration time.Duration
+		want             []string
+	}{
+		{0, 0, []string{" offset_ms=0", " duration_ms=0"}},
+		{90 * time.Second, 0, []string{" offset_ms=90000", " duration_ms=0"}},
+		{0, 30 * time.Second, []string{" offset_ms=0", " duration_ms=30000"}},
+		{1500 * time.Millisecond, 2500 * time.Millisecond, []string{" offset_ms=1500", " duration_ms=2500"}},
+	} {
+		context := &context{model: &model{}}
+		context.SetOffset(test.offset)
+		context.SetDuration(test.duration)
+		for _, want := range test.want {
+			assert.Contains(context.params.String(), want)
+		}
+	}
+}
+
+func Test_Context_001(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(1500*time.Microsecond, msToDuration(1.5))
+	assert.Equal(time.Duration(0), msToDuration(0))
+
+	// Without a model only the wall clock time is known
+	context := &context{model: &model{}, total: time.Second}
+	assert.Equal(Timings{Total: time.Second}, context.Timings())
+}
@@ -12,6 +12,15 @@ import (
 // time. It is called during the Process function
 type SegmentCallback func(Segment)
 
+// ProgressCallback is the callback function for reporting progress. It is
+// called during the Process function with the percentage of audio processed
+type ProgressCallback func(int)
+
+// AbortCallback is the callback function for cancelling processing. It is
+// polled during the Process function, and processing stops as soon as it
+// returns true
+type AbortCallback func() bool
+
 // Model is the interface to a whisper model. Create a new model with the
 // function whisper.New(string)
 type Model interface {
@@ -37,18 +46,20 @@ type Context interface {
 	SetOffset(time.Duration)   // Set offset
 	SetDuration(time.Duration) // Set duration
 	SetThreads(uint)           // Set number of threads to use
-	SetSpeedup(bool)           // Set speedup flag
 	SetNoContext(bool)
 	SetTokenThreshold(float32)    // Set timestamp token probability threshold
 	SetTokenSumThreshold(float32) // Set timestamp token sum probability threshold
 	SetMaxSegmentLength(uint)     // Set max segment length in characters
 	SetTokenTimestamps(bool)      // Set token timestamps flag
 	SetMaxTokensPerSegment(uint)  // Set max tokens per segment (0 = no limit)
+	SetSpeakerTurns(bool)         // Set tinydiarize speaker turn detection flag
 
 	// Process mono audio data and return any errors.
 	// If defined, newly generated segments are passed to the
-	// callback function during processing.
-	Process([]float32, SegmentCallback) error
+	// segment callback function during processing, progress is reported
+	// to the progress callback, and processing is cancelled with
+	// ErrProcessingAborted when the abort callback returns true.
+	Process([]float32, SegmentCallback, ProgressCallback, AbortCallback) error
 
 	// After process is called, return segments until the end of the stream
 	// is reached, when io.EOF is returned.
@@ -66,10 +77,24 @@ type Context interface {
 	// Timings
 	PrintTimings()
 	ResetTimings()
+	Timings() Timings
 
 	SystemInfo() string
 }
 
+// Timings of the last call to Process. As whisper reports them, each stage
+// is the mean time of one run of it, not the total for the call. Total is
+// the wall clock time of Process. Stages which the library does not report
+// are zero
+type Timings struct {
+	Sample time.Duration `json:"sample"` // Sampling a token
+	Encode time.Duration `json:"encode"` // Running the encoder on a window of audio
+	Decode time.Duration `json:"decode"` // Running the decoder on one token
+	Batchd time.Duration `json:"batchd"` // Running the decoder on a batch of tokens
+	Prompt time.Duration `json:"prompt"` // Processing a prompt
+	Total  time.Duration `json:"total"`  // Wall clock time of Process
+}
+
 // Segment is the text result of a speech recognition.
 type Segment struct {
 	// Segment Number
@@ -83,6 +108,10 @@ type Segment struct {
 
 	// The tokens of the segment.
 	Tokens []Token
+
+	// True when the speaker changes after the segment. Only set by models
+	// trained with tinydiarize, when speaker turns are enabled.
+	SpeakerTurnNext bool
 }
 
 // Token is a text or special token
@@ -92,3 +121,17 @@ type Token struct {
 	P          float32
 	Start, End time.Duration
 }
+
+// Word is one or more text tokens which make up a single word. Use
+// Segment.Words() to merge the tokens of a segment into words
+type Word struct {
+	// The text of the word, including trailing punctuation
+	Text string
+
+	// Time beginning and end timestamps for the word, these are only
+	// populated when token timestamps are enabled on the context
+	Start, End time.Duration
+
+	// The mean probability of the tokens in the word
+	P float32
+}
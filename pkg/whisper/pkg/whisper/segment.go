@@ -0,0 +1,59 @@
+package whisper
+
+import (
+	"strings"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// PUBLIC METHODS
+
+// Words merges the text tokens of the segment into words. A token which
+// starts with a space begins a new word, any other token is appended to
+// the current word. Special tokens, which whisper.cpp renders as [_XXX_],
+// are skipped
+func (segment Segment) Words() []Word {
+	var result []Word
+	var n int
+	for _, token := range segment.Tokens {
+		if token.IsSpecial() || token.Text == "" {
+			continue
+		}
+		if len(result) == 0 || strings.HasPrefix(token.Text, " ") {
+			// Close the previous word
+			if len(result) > 0 {
+				result[len(result)-1].P /= float32(n)
+			}
+			result = append(result, Word{
+				Text:  strings.TrimLeft(token.Text, " "),
+				Start: token.Start,
+				End:   token.End,
+				P:     token.P,
+			})
+			n = 1
+		} else {
+			word := &result[len(result)-1]
+			word.Text += token.Text
+			word.End = token.End
+			word.P += token.P
+			n++
+		}
+	}
+	if len(result) > 0 {
+		result[len(result)-1].P /= float32(n)
+	}
+
+	// Drop words which are only whitespace
+	words := result[:0]
+	for _, word := range result {
+		if strings.TrimSpace(word.Text) != "" {
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+// IsSpecial returns true for special tokens, such as timestamps or the
+// begin token, which whisper.cpp renders as [_XXX_]
+func (token Token) IsSpecial() bool {
+	return strings.HasPrefix(token.Text, "[_") && strings.HasSuffix(token.Text, "]")
+}
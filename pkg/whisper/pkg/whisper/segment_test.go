@@ -0,0 +1,52 @@
+package whisper_test
+
+import (
+	"testing"
+	"time"
+
+	// Packages
+	whisper "github.com/ggerganov/whisper.cpp/bindings/go/pkg/whisper"
+	assert "github.com/stretchr/testify/assert"
+)
+
+func Test_Segment_000(t *testing.T) {
+	assert := assert.New(t)
+	ms := time.Millisecond
+	segment := whisper.Segment{
+		Tokens: []whisper.Token{
+			{Id: 50363, Text: "[_BEG_]", P: 1},
+			{Id: 1, Text: " And", P: 0.9, Start: 0, End: 300 * ms},
+			{Id: 2, Text: " so", P: 0.8, Start: 300 * ms, End: 500 * ms},
+			{Id: 3, Text: " my", P: 1, Start: 500 * ms, End: 700 * ms},
+			{Id: 4, Text: " fell", P: 0.6, Start: 700 * ms, End: 900 * ms},
+			{Id: 5, Text: "ow", P: 0.8, Start: 900 * ms, End: 1000 * ms},
+			{Id: 6, Text: ",", P: 1, Start: 1000 * ms, End: 1000 * ms},
+			{Id: 50413, Text: "[_TT_100]", P: 1},
+		},
+	}
+
+	words := segment.Words()
+	if assert.Len(words, 4) {
+		assert.Equal(whisper.Word{Text: "And", Start: 0, End: 300 * ms, P: 0.9}, words[0])
+		assert.Equal("so", words[1].Text)
+		assert.Equal("fellow,", words[3].Text)
+		assert.Equal(700*ms, words[3].Start)
+		assert.Equal(1000*ms, words[3].End)
+		assert.InDelta(0.8, words[3].P, 0.0001)
+	}
+}
+
+func Test_Segment_001(t *testing.T) {
+	assert := assert.New(t)
+
+	// No tokens or only special tokens
+	assert.Empty(whisper.Segment{}.Words())
+	assert.Empty(whisper.Segment{Tokens: []whisper.Token{{Text: "[_BEG_]"}, {Text: " "}}}.Words())
+
+	// A first token without a leading space still starts a word
+	words := whisper.Segment{Tokens: []whisper.Token{{Text: "Hello", P: 0.5}, {Text: " world", P: 1}}}.Words()
+	if assert.Len(words, 2) {
+		assert.Equal("Hello", words[0].Text)
+		assert.Equal(float32(0.5), words[0].P)
+	}
+}
@@ -0,0 +1,9 @@
+// whisper_get_timings allocates the timings it returns with new, so they
+// must be released with delete rather than free. The struct is trivially
+// destructible, so releasing its storage is the same as deleting it, and
+// whisper.h does not need to be on the C++ include path
+#include <new>
+
+extern "C" void whisper_free_timings(void* timings) {
+    ::operator delete(timings);
+}
@@ -2,6 +2,7 @@ package whisper
 
 import (
 	"errors"
+	"sync"
 	"unsafe"
 )
 
@@ -14,7 +15,12 @@ import (
 #include <stdlib.h>
 
 extern void callNewSegment(void* user_data, int new);
+extern void callProgress(void* user_data, int progress);
 extern bool callEncoderBegin(void* user_data);
+extern bool callAbort(void* user_data);
+
+// Release timings returned by whisper_get_timings, in timings.cpp
+extern void whisper_free_timings(void* timings);
 
 // Text segment callback
 // Called on every newly generated text segment
@@ -25,6 +31,14 @@ static void whisper_new_segment_cb(struct whisper_context* ctx, struct whisper_s
     }
 }
 
+// Progress callback
+// Called periodically with the percentage of the audio processed so far
+static void whisper_progress_cb(struct whisper_context* ctx, struct whisper_state* state, int progress, void* user_data) {
+    if(user_data != NULL && ctx != NULL) {
+        callProgress(user_data, progress);
+    }
+}
+
 // Encoder begin callback
 // If not NULL, called before the encoder starts
 // If it returns false, the computation is aborted
@@ -35,15 +49,38 @@ static bool whisper_encoder_begin_cb(struct whisper_context* ctx, struct whisper
     return false;
 }
 
+// Abort callback
+// If not NULL, called periodically during the computation
+// If it returns true, the computation is aborted
+static bool whisper_abort_cb(void* user_data) {
+    if(user_data != NULL) {
+        return callAbort(user_data);
+    }
+    return false;
+}
+
 // Get default parameters and set callbacks
+// The callbacks do nothing until whisper_full_params_set_user_data is called
 static struct whisper_full_params whisper_full_default_params_cb(struct whisper_context* ctx, enum whisper_sampling_strategy strategy) {
 	struct whisper_full_params params = whisper_full_default_params(strategy);
 	params.new_segment_callback = whisper_new_segment_cb;
-	params.new_segment_callback_user_data = (void*)(ctx);
+	params.new_segment_callback_user_data = NULL;
+	params.progress_callback = whisper_progress_cb;
+	params.progress_callback_user_data = NULL;
 	params.encoder_begin_callback = whisper_encoder_begin_cb;
-	params.encoder_begin_callback_user_data = (void*)(ctx);
+	params.encoder_begin_callback_user_data = NULL;
+	params.abort_callback = whisper_abort_cb;
+	params.abort_callback_user_data = NULL;
 	return params;
 }
+
+// Set the handle passed to the callbacks for one call to whisper_full
+static void whisper_full_params_set_user_data(struct whisper_full_params* params, void* user_data) {
+	params->new_segment_callback_user_data = user_data;
+	params->progress_callback_user_data = user_data;
+	params->encoder_begin_callback_user_data = user_data;
+	params->abort_callback_user_data = user_data;
+}
 */
 import "C"
 
@@ -56,6 +93,7 @@ type (
 	TokenData        C.struct_whisper_token_data
 	SamplingStrategy C.enum_whisper_sampling_strategy
 	Params           C.struct_whisper_full_params
+	Timings          C.struct_whisper_timings
 )
 
 ///////////////////////////////////////////////////////////////////////////////
@@ -276,6 +314,21 @@ func (ctx *Context) Whisper_reset_timings() {
 	C.whisper_reset_timings((*C.struct_whisper_context)(ctx))
 }
 
+// Performance information for the last call to whisper_full, or nil if
+// it is not available. Each stage is the mean time of one run of it, such as
+// decoding one token
+func (ctx *Context) Whisper_get_timings() *Timings {
+	t := C.whisper_get_timings((*C.struct_whisper_context)(ctx))
+	if t == nil {
+		return nil
+	}
+	// whisper.cpp allocates the timings with new on each call, and leaves
+	// them to the caller to delete
+	defer C.whisper_free_timings(unsafe.Pointer(t))
+	timings := Timings(*t)
+	return &timings
+}
+
 // Print system information
 func Whisper_print_system_info() string {
 	return C.GoString(C.whisper_print_system_info())
@@ -289,11 +342,16 @@ func (ctx *Context) Whisper_full_default_params(strategy SamplingStrategy) Param
 
 // Run the entire model: PCM -> log mel spectrogram -> encoder -> decoder -> text
 // Uses the specified decoding strategy to obtain the text.
-func (ctx *Context) Whisper_full(params Params, samples []float32, encoderBeginCallback func() bool, newSegmentCallback func(int)) error {
-	registerEncoderBeginCallback(ctx, encoderBeginCallback)
-	registerNewSegmentCallback(ctx, newSegmentCallback)
-	defer registerEncoderBeginCallback(ctx, nil)
-	defer registerNewSegmentCallback(ctx, nil)
+// The progress callback receives the percentage of audio processed, and the
+// abort callback can return true to stop the computation mid-decode.
+func (ctx *Context) Whisper_full(params Params, samples []float32, encoderBeginCallback func() bool, newSegmentCallback func(int), progressCallback func(int), abortCallback func() bool) error {
+	handle := registerCallbacks(&params, &callbacks{
+		newSegment:   newSegmentCallback,
+		progress:     progressCallback,
+		encoderBegin: encoderBeginCallback,
+		abort:        abortCallback,
+	})
+	defer unregisterCallbacks(handle)
 	if C.whisper_full((*C.struct_whisper_context)(ctx), (C.struct_whisper_full_params)(params), (*C.float)(&samples[0]), C.int(len(samples))) == 0 {
 		return nil
 	} else {
@@ -304,11 +362,14 @@ func (ctx *Context) Whisper_full(params Params, samples []float32, encoderBeginC
 // Split the input audio in chunks and process each chunk separately using whisper_full()
 // It seems this approach can offer some speedup in some cases.
 // However, the transcription accuracy can be worse at the beginning and end of each chunk.
-func (ctx *Context) Whisper_full_parallel(params Params, samples []float32, processors int, encoderBeginCallback func() bool, newSegmentCallback func(int)) error {
-	registerEncoderBeginCallback(ctx, encoderBeginCallback)
-	registerNewSegmentCallback(ctx, newSegmentCallback)
-	defer registerEncoderBeginCallback(ctx, nil)
-	defer registerNewSegmentCallback(ctx, nil)
+func (ctx *Context) Whisper_full_parallel(params Params, samples []float32, processors int, encoderBeginCallback func() bool, newSegmentCallback func(int), progressCallback func(int), abortCallback func() bool) error {
+	handle := registerCallbacks(&params, &callbacks{
+		newSegment:   newSegmentCallback,
+		progress:     progressCallback,
+		encoderBegin: encoderBeginCallback,
+		abort:        abortCallback,
+	})
+	defer unregisterCallbacks(handle)
 
 	if C.whisper_full_parallel((*C.struct_whisper_context)(ctx), (C.struct_whisper_full_params)(params), (*C.float)(&samples[0]), C.int(len(samples)), C.int(processors)) == 0 {
 		return nil
@@ -333,6 +394,12 @@ func (ctx *Context) Whisper_full_get_segment_t1(segment int) int64 {
 	return int64(C.whisper_full_get_segment_t1((*C.struct_whisper_context)(ctx), C.int(segment)))
 }
 
+// Return true when the speaker changes after the specified segment. Only
+// tinydiarize models detect speaker turns.
+func (ctx *Context) Whisper_full_get_segment_speaker_turn_next(segment int) bool {
+	return bool(C.whisper_full_get_segment_speaker_turn_next((*C.struct_whisper_context)(ctx), C.int(segment)))
+}
+
 // Get the text of the specified segment.
 func (ctx *Context) Whisper_full_get_segment_text(segment int) string {
 	return C.GoString(C.whisper_full_get_segment_text((*C.struct_whisper_context)(ctx), C.int(segment)))
@@ -367,38 +434,67 @@ func (ctx *Context) Whisper_full_get_token_p(segment int, token int) float32 {
 ///////////////////////////////////////////////////////////////////////////////
 // CALLBACKS
 
+// callbacks are the Go functions called during one call to whisper_full,
+// which are nil when not set
+type callbacks struct {
+	newSegment   func(int)
+	progress     func(int)
+	encoderBegin func() bool
+	abort        func() bool
+}
+
 var (
-	cbNewSegment   = make(map[unsafe.Pointer]func(int))
-	cbEncoderBegin = make(map[unsafe.Pointer]func() bool)
+	// Callbacks are keyed by a handle allocated for each call, rather than
+	// by the whisper context, which is shared by every caller of a model
+	cbMutex sync.RWMutex
+	cbCalls = make(map[unsafe.Pointer]*callbacks)
 )
 
-func registerNewSegmentCallback(ctx *Context, fn func(int)) {
-	if fn == nil {
-		delete(cbNewSegment, unsafe.Pointer(ctx))
-	} else {
-		cbNewSegment[unsafe.Pointer(ctx)] = fn
-	}
+// registerCallbacks allocates a handle for the callbacks of one call, and
+// sets it as the user data of the callbacks in params. The handle is C
+// memory, so it can be passed to C and compared when called back
+func registerCallbacks(params *Params, cb *callbacks) unsafe.Pointer {
+	handle := C.malloc(1)
+	C.whisper_full_params_set_user_data((*C.struct_whisper_full_params)(params), handle)
+	cbMutex.Lock()
+	defer cbMutex.Unlock()
+	cbCalls[handle] = cb
+	return handle
 }
 
-func registerEncoderBeginCallback(ctx *Context, fn func() bool) {
-	if fn == nil {
-		delete(cbEncoderBegin, unsafe.Pointer(ctx))
-	} else {
-		cbEncoderBegin[unsafe.Pointer(ctx)] = fn
-	}
+// unregisterCallbacks forgets the callbacks of a call and frees its handle
+func unregisterCallbacks(handle unsafe.Pointer) {
+	cbMutex.Lock()
+	delete(cbCalls, handle)
+	cbMutex.Unlock()
+	C.free(handle)
+}
+
+// callbacksFor returns the callbacks for a handle, or nil
+func callbacksFor(user_data unsafe.Pointer) *callbacks {
+	cbMutex.RLock()
+	defer cbMutex.RUnlock()
+	return cbCalls[user_data]
 }
 
 //export callNewSegment
 func callNewSegment(user_data unsafe.Pointer, new C.int) {
-	if fn, ok := cbNewSegment[user_data]; ok {
-		fn(int(new))
+	if cb := callbacksFor(user_data); cb != nil && cb.newSegment != nil {
+		cb.newSegment(int(new))
+	}
+}
+
+//export callProgress
+func callProgress(user_data unsafe.Pointer, progress C.int) {
+	if cb := callbacksFor(user_data); cb != nil && cb.progress != nil {
+		cb.progress(int(progress))
 	}
 }
 
 //export callEncoderBegin
 func callEncoderBegin(user_data unsafe.Pointer) C.bool {
-	if fn, ok := cbEncoderBegin[user_data]; ok {
-		if fn() {
+	if cb := callbacksFor(user_data); cb != nil && cb.encoderBegin != nil {
+		if cb.encoderBegin() {
 			return C.bool(true)
 		} else {
 			return C.bool(false)
@@ -407,6 +503,14 @@ func callEncoderBegin(user_data unsafe.Pointer) C.bool {
 	return true
 }
 
+//export callAbort
+func callAbort(user_data unsafe.Pointer) C.bool {
+	if cb := callbacksFor(user_data); cb != nil && cb.abort != nil && cb.abort() {
+		return C.bool(true)
+	}
+	return false
+}
+
 func (t TokenData) T0() int64 {
 	return int64(t.t0)
 }
@@ -414,3 +518,23 @@ func (t TokenData) T0() int64 {
 func (t TokenData) T1() int64 {
 	return int64(t.t1)
 }
+
+func (t *Timings) SampleMs() float32 {
+	return float32(t.sample_ms)
+}
+
+func (t *Timings) EncodeMs() float32 {
+	return float32(t.encode_ms)
+}
+
+func (t *Timings) DecodeMs() float32 {
+	return float32(t.decode_ms)
+}
+
+func (t *Timings) BatchdMs() float32 {
+	return float32(t.batchd_ms)
+}
+
+func (t *Timings) PromptMs() float32 {
+	return float32(t.prompt_ms)
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+	"sort"
+	"strings"
+
+	// Packages
+	whisper "github.com/ggerganov/whisper.cpp/bindings/go/pkg/whisper"
+)
+
+// Filter cleans up 16kHz mono samples before they are transcribed
+type Filter interface {
+	// Apply returns the filtered samples, which may reuse the samples
+	Apply(samples []float32) []float32
+}
+
+// HighPass removes rumble below a frequency, such as wind and engine noise
+type HighPass struct {
+	// Frequency in Hz
+	Cutoff float32
+}
+
+// NoiseGate is a spectral noise gate. The spectrum of the noise is measured
+// on the quietest frames of the audio, and each frequency of every frame is
+// turned down where it is not louder than the noise
+type NoiseGate struct {
+	// Fraction of the frames, quietest first, which the noise is measured on
+	Quietest float64
+
+	// A frequency is kept when it is at least this many times louder than
+	// the noise
+	Threshold float64
+
+	// Gain of the frequencies which are turned down
+	Reduction float64
+}
+
+// Normalize makes the loudness of speech the same for all audio
+type Normalize struct {
+	// Loudness of the speech in dBFS RMS
+	Level float64
+
+	// The most gain in dB, so that noise is not made louder than speech
+	MaxGain float64
+
+	// Highest peak amplitude after the gain
+	Peak float64
+}
+
+// Filter names, in the order they are applied
+const (
+	FilterHighPass  = "highpass"
+	FilterDenoise   = "denoise"
+	FilterNormalize = "normalize"
+
+	// No filters, for chats which turn off the default filters
+	FilterNone = "none"
+)
+
+const (
+	// Samples in each frame of the noise gate, and the step between them
+	gateFrame = 512
+	gateStep  = gateFrame / 2
+
+	// Length of the frames normalization measures the loudness on, and the
+	// loudness in dBFS below which a frame is silence
+	levelFrame   = whisper.SampleRate / 20
+	levelSilence = -60
+)
+
+var (
+	// The filters which can be selected
+	filterNames = []string{FilterHighPass, FilterDenoise, FilterNormalize}
+
+	// The filter for each name
+	filters = map[string]Filter{
+		FilterHighPass:  HighPass{Cutoff: 100},
+		FilterDenoise:   NoiseGate{Quietest: 0.1, Threshold: 3, Reduction: 0.1},
+		FilterNormalize: Normalize{Level: -20, MaxGain: 30, Peak: 0.95},
+	}
+)
+
+// Apply returns the samples with frequencies below the cutoff removed
+func (f HighPass) Apply(samples []float32) []float32 {
+	return highPass(samples, f.Cutoff)
+}
+
+// Apply returns the samples with the noise turned down
+func (f NoiseGate) Apply(samples []float32) []float32 {
+	if len(samples) < gateFrame {
+		return samples
+	}
+
+	// Pad half a frame at the start, and up to a whole step and half a
+	// frame at the end, so every sample is in two frames
+	padded := make([]float64, (len(samples)+gateStep-1)/gateStep*gateStep+gateFrame)
+	for i, sample := range samples {
+		padded[gateFrame/2+i] = float64(sample)
+	}
+	window := make([]float64, gateFrame)
+	for i := range window {
+		window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/gateFrame)
+	}
+	frames := (len(padded)-gateFrame)/gateStep + 1
+	spectrum := func(frame int) []complex128 {
+		x := make([]complex128, gateFrame)
+		for i := range x {
+			x[i] = complex(padded[frame*gateStep+i]*window[i], 0)
+		}
+		fft(x, false)
+		return x
+	}
+
+	// Measure the noise on the quietest frames
+	energies := make([]float64, frames)
+	order := make([]int, frames)
+	for frame := range energies {
+		for _, sample := range padded[frame*gateStep : frame*gateStep+gateFrame] {
+			energies[frame] += sample * sample
+		}
+		order[frame] = frame
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return energies[order[i]] < energies[order[j]]
+	})
+	quiet := int(math.Ceil(f.Quietest * float64(frames)))
+	noise := make([]float64, gateFrame)
+	for _, frame := range order[:quiet] {
+		for i, v := range spectrum(frame) {
+			noise[i] += cmplx.Abs(v) / float64(quiet)
+		}
+	}
+
+	// Turn down the frequencies of each frame which are not above the
+	// noise, and add the frames back together. The windows of overlapping
+	// frames add up to one
+	result := make([]float64, len(padded))
+	for frame := 0; frame < frames; frame++ {
+		x := spectrum(frame)
+		for i, v := range x {
+			if cmplx.Abs(v) < f.Threshold*noise[i] {
+				x[i] = v * complex(f.Reduction, 0)
+			}
+		}
+		fft(x, true)
+		for i, v := range x {
+			result[frame*gateStep+i] += real(v)
+		}
+	}
+	for i := range samples {
+		samples[i] = float32(result[gateFrame/2+i])
+	}
+	return samples
+}
+
+// Apply returns the samples with a gain which brings the loudness of the
+// speech to the level
+func (f Normalize) Apply(samples []float32) []float32 {
+	// The loudness is measured on the frames which are not silence
+	var sum, n, peak float64
+	for at := 0; at < len(samples); at += levelFrame {
+		frame := samples[at:min(at+levelFrame, len(samples))]
+		var energy float64
+		for _, sample := range frame {
+			energy += float64(sample) * float64(sample)
+			peak = math.Max(peak, math.Abs(float64(sample)))
+		}
+		if decibels(math.Sqrt(energy/float64(len(frame)))) > levelSilence {
+			sum += energy
+			n += float64(len(frame))
+		}
+	}
+	if n == 0 {
+		return samples
+	}
+	gain := math.Min(f.Level-decibels(math.Sqrt(sum/n)), f.MaxGain)
+	if f.Peak > 0 {
+		gain = math.Min(gain, decibels(f.Peak/peak))
+	}
+	scale := float32(math.Pow(10, gain/20))
+	for i := range samples {
+		samples[i] *= scale
+	}
+	return samples
+}
+
+// parseFilters checks a comma-separated list of filter names, and returns
+// them in the order they are applied. The empty list and "none" are no
+// filters
+func parseFilters(list string) (string, error) {
+	selected := make(map[string]bool)
+	for _, name := range strings.Split(strings.ToLower(list), ",") {
+		if name = strings.TrimSpace(name); name == "" || name == FilterNone {
+			continue
+		} else if !isInSet(name, filterNames) {
+			return "", fmt.Errorf("unknown filter %q, filters are %s", name, strings.Join(filterNames, ", "))
+		}
+		selected[name] = true
+	}
+	var result []string
+	for _, name := range filterNames {
+		if selected[name] {
+			result = append(result, name)
+		}
+	}
+	return strings.Join(result, ","), nil
+}
+
+// preprocess applies the filters in a comma-separated list to a copy of the
+// samples, in order
+func preprocess(samples []float32, list string) []float32 {
+	if list == "" || list == FilterNone {
+		return samples
+	}
+	samples = append([]float32(nil), samples...)
+	for _, name := range strings.Split(list, ",") {
+		if filter, exists := filters[name]; exists {
+			samples = filter.Apply(samples)
+		}
+	}
+	return samples
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// PRIVATE METHODS
+
+// highPass returns a copy of the samples with a first order high pass filter
+// applied
+func highPass(samples []float32, cutoff float32) []float32 {
+	result := append([]float32(nil), samples...)
+	if cutoff <= 0 || len(result) == 0 {
+		return result
+	}
+	rc := 1 / (2 * math.Pi * float64(cutoff))
+	dt := 1 / float64(whisper.SampleRate)
+	alpha := float32(rc / (rc + dt))
+	y := result[0]
+	for i := 1; i < len(result); i++ {
+		y = alpha * (y + samples[i] - samples[i-1])
+		result[i] = y
+	}
+	return result
+}
+
+// fft computes the discrete fourier transform of x in place, or the inverse
+// transform. The length of x must be a power of two
+func fft(x []complex128, inverse bool) {
+	n := len(x)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	sign := -1.0
+	if inverse {
+		sign = 1
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, sign*2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				a, b := x[start+k], x[start+k+size/2]*w
+				x[start+k], x[start+k+size/2] = a+b, a-b
+				w *= step
+			}
+		}
+	}
+	if inverse {
+		for i := range x {
+			x[i] /= complex(float64(n), 0)
+		}
+	}
+}
+
+// decibels returns an amplitude in dB
+func decibels(amplitude float64) float64 {
+	return 20 * math.Log10(amplitude)
+}
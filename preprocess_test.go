@@ -0,0 +1,145 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	// Packages
+	whisper "github.com/ggerganov/whisper.cpp/bindings/go/pkg/whisper"
+	assert "github.com/stretchr/testify/assert"
+)
+
+// speech returns bursts of a 300Hz tone, half a second on and half a second
+// off, lasting d
+func speech(amplitude float64, d time.Duration) []float32 {
+	samples := voice(300, amplitude, d)
+	for i := range samples {
+		if i/(whisper.SampleRate/2)%2 == 1 {
+			samples[i] = 0
+		}
+	}
+	return samples
+}
+
+// mix returns the sum of the samples
+func mix(a, b []float32) []float32 {
+	result := make([]float32, len(a))
+	for i := range result {
+		result[i] = a[i] + b[i]
+	}
+	return result
+}
+
+// snr returns the ratio in dB of the clean samples to the difference of the
+// samples from them
+func snr(clean, samples []float32) float64 {
+	var signal, noise float64
+	for i := range clean {
+		signal += float64(clean[i]) * float64(clean[i])
+		noise += float64(samples[i]-clean[i]) * float64(samples[i]-clean[i])
+	}
+	return 10 * math.Log10(signal/noise)
+}
+
+// amplitude returns the amplitude of the samples at a frequency
+func amplitude(samples []float32, frequency float64) float64 {
+	var re, im float64
+	for i, sample := range samples {
+		phase := 2 * math.Pi * frequency * float64(i) / whisper.SampleRate
+		re += float64(sample) * math.Cos(phase)
+		im += float64(sample) * math.Sin(phase)
+	}
+	return 2 * math.Hypot(re, im) / float64(len(samples))
+}
+
+// level returns the RMS level of the samples in dBFS
+func level(samples []float32) float64 {
+	var sum float64
+	for _, sample := range samples {
+		sum += float64(sample) * float64(sample)
+	}
+	return decibels(math.Sqrt(sum / float64(len(samples))))
+}
+
+func Test_Preprocess_000(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, test := range []struct{ list, want, err string }{
+		{"", "", ""},
+		{"none", "", ""},
+		{"normalize, HighPass", "highpass,normalize", ""},
+		{"denoise,denoise", "denoise", ""},
+		{"echo", "", `unknown filter "echo", filters are highpass, denoise, normalize`},
+	} {
+		list, err := parseFilters(test.list)
+		if test.err != "" {
+			assert.EqualError(err, test.err)
+		} else if assert.NoError(err) {
+			assert.Equal(test.want, list)
+		}
+	}
+
+	// The samples are not changed
+	samples := voice(300, 0.01, time.Second)
+	original := append([]float32(nil), samples...)
+	assert.NotEqual(original, preprocess(samples, "highpass,denoise,normalize"))
+	assert.Equal(original, samples)
+	assert.Equal(original, preprocess(samples, ""))
+}
+
+func Test_Preprocess_001(t *testing.T) {
+	assert := assert.New(t)
+
+	// Rumble is turned down, and speech is kept
+	clean := voice(300, 0.3, 2*time.Second)
+	noisy := mix(clean, voice(30, 0.3, 2*time.Second))
+	before := decibels(amplitude(noisy, 30) / amplitude(noisy, 300))
+	filtered := preprocess(noisy, FilterHighPass)
+	after := decibels(amplitude(filtered, 30) / amplitude(filtered, 300))
+	t.Logf("rumble to speech before %.1fdB, after %.1fdB", before, after)
+	assert.Less(after, before-8)
+	assert.InDelta(0, decibels(amplitude(filtered, 300)/amplitude(clean, 300)), 1)
+}
+
+func Test_Preprocess_002(t *testing.T) {
+	assert := assert.New(t)
+
+	// Background noise is turned down between and under the speech
+	random := rand.New(rand.NewSource(1))
+	clean := speech(0.3, 4*time.Second)
+	noise := make([]float32, len(clean))
+	for i := range noise {
+		noise[i] = float32(0.02 * random.NormFloat64())
+	}
+	noisy := mix(clean, noise)
+	filtered := preprocess(noisy, FilterDenoise)
+	t.Logf("SNR before %.1fdB, after %.1fdB", snr(clean, noisy), snr(clean, filtered))
+	assert.Greater(snr(clean, filtered), snr(clean, noisy)+10)
+
+	// Silent audio and audio shorter than a frame are unchanged
+	assert.Equal(silence(time.Second), preprocess(silence(time.Second), FilterDenoise))
+	short := voice(300, 0.3, 10*time.Millisecond)
+	assert.Equal(short, preprocess(short, FilterDenoise))
+}
+
+func Test_Preprocess_003(t *testing.T) {
+	assert := assert.New(t)
+
+	// Quiet speech is brought to the level, ignoring the silence
+	quiet := speech(0.01, 2*time.Second)
+	normalized := preprocess(quiet, FilterNormalize)
+	t.Logf("speech level before %.1fdBFS, after %.1fdBFS", level(quiet[:whisper.SampleRate/2]), level(normalized[:whisper.SampleRate/2]))
+	assert.InDelta(-20, level(normalized[:whisper.SampleRate/2]), 0.5)
+
+	// The gain is limited by the peaks, and by the most gain
+	spike := speech(0.01, 2*time.Second)
+	spike[100] = 0.5
+	assert.InDelta(0.95, preprocess(spike, FilterNormalize)[100], 0.001)
+	faint := speech(0.0025, 2*time.Second)
+	assert.InDelta(level(faint[:whisper.SampleRate/2])+30, level(preprocess(faint, FilterNormalize)[:whisper.SampleRate/2]), 0.1)
+
+	// Silence is unchanged
+	assert.Equal(silence(time.Second), preprocess(silence(time.Second), FilterNormalize))
+}
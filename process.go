@@ -1,28 +1,31 @@
 package main
 
 import (
-	"crypto/rand"
-	"encoding/hex"
+	"context"
 	"fmt"
 	"io"
-	"path/filepath"
-
-	//"io"
-	"os"
+	"sync"
 	"time"
 
 	// Package imports
 	whisper "github.com/ggerganov/whisper.cpp/bindings/go/pkg/whisper"
-	wav "github.com/go-audio/wav"
-	// wav "github.com/go-audio/wav"
-	// "github.com/go-delve/delve/pkg/terminal/colorize"
 	"github.com/imdario/mergo"
 )
 
 type WhisperProcessor struct {
+	sync.Mutex
+	backend Backend
+	load    AudioLoader
+	loadAll ChannelLoader
+	name    string
+	cache   *Cache
+	metrics *Metrics
 	model   whisper.Model
 	context whisper.Context
 	params  WhisperParams
+
+	// Tells speakers apart when the model does not detect speaker turns
+	diarizer Diarizer
 }
 
 type WhisperParams struct {
@@ -32,17 +35,23 @@ type WhisperParams struct {
 	offset     time.Duration
 	duration   time.Duration
 	threads    uint
-	speedup    bool
 	max_len    uint
 	max_tokens uint
 	word_thold float64
 	tokens     bool
 	colorize   bool
 	out        string
+	speakers   bool
+	channels   bool
+	filters    string
 }
 
-func WPInit() *WhisperProcessor {
+func WPInit(backend Backend) *WhisperProcessor {
 	return &WhisperProcessor{
+		backend:  backend,
+		load:     loadAudio,
+		loadAll:  loadChannels,
+		diarizer: defaultDiarizer,
 		params: WhisperParams{
 			language:   "auto",
 			no_context: true,
@@ -50,27 +59,35 @@ func WPInit() *WhisperProcessor {
 			offset:     0,
 			duration:   0,
 			threads:    0,
-			speedup:    false,
 			max_len:    0,
 			word_thold: 0,
 			tokens:     false,
 			colorize:   false,
 			out:        "",
+			speakers:   false,
+			channels:   false,
+			filters:    "",
 		},
 	}
 }
 func (wp *WhisperProcessor) LoadModel(modelfile string) (err error) {
-	wp.model, err = whisper.New(modelfile)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		return err
-	}
-	//defer wp.model.Close()
+	wp.model, err = wp.backend.Load(modelfile)
+	return err
+}
 
+// Close frees the model once any job in progress has finished
+func (wp *WhisperProcessor) Close() error {
+	wp.Lock()
+	defer wp.Unlock()
+	if wp.model == nil {
+		return nil
+	}
+	err := wp.model.Close()
+	wp.model, wp.context = nil, nil
 	return err
 }
 
-func (wp *WhisperProcessor) PrepareModel(newparams WhisperParams) (err error) {
+func (wp *WhisperProcessor) PrepareModel(ctx context.Context, newparams WhisperParams) (err error) {
 	// Set the parameters
 	params := wp.params
 	if err := mergo.Merge(&params, newparams, mergo.WithOverride); err != nil {
@@ -80,94 +97,199 @@ func (wp *WhisperProcessor) PrepareModel(newparams WhisperParams) (err error) {
 	wp.params = params
 
 	wp.context, err = wp.model.NewContext()
-	fmt.Printf("Setting language to %q\n", wp.params.language)
-	if err := wp.context.SetLanguage(wp.params.language); err != nil {
+	if err != nil {
+		return err
+	}
+	loggerFrom(ctx).Debug("preparing model",
+		"model", wp.name,
+		"language", wp.params.language,
+		"translate", wp.params.translate,
+		"offset", wp.params.offset,
+		"duration", wp.params.duration,
+		"no_context", wp.params.no_context,
+		"threads", wp.params.threads,
+		"max_len", wp.params.max_len,
+		"max_tokens", wp.params.max_tokens,
+		"word_threshold", wp.params.word_thold,
+		"speakers", wp.params.speakers,
+	)
+	if err := configure(wp.context, wp.params); err != nil {
 		return err
 	}
-	fmt.Printf("Setting translate to %v\n", wp.params.translate)
-	wp.context.SetTranslate(wp.params.translate)
-	if wp.params.offset != 0 {
-		fmt.Printf("Setting offset to %v\n", wp.params.offset)
-		wp.context.SetOffset(wp.params.offset)
+	wp.context.SetSpeakerTurns(wp.params.speakers && tinydiarize(wp.name))
+
+	loggerFrom(ctx).Debug("system info", "info", wp.context.SystemInfo())
+
+	return err
+}
+
+// configure applies params to a context
+func configure(context whisper.Context, params WhisperParams) error {
+	if err := context.SetLanguage(params.language); err != nil {
+		return err
 	}
-	if wp.params.duration != 0 {
-		fmt.Printf("Setting duration to %v\n", wp.params.duration)
-		wp.context.SetDuration(wp.params.duration)
+	context.SetTranslate(params.translate)
+	if params.offset != 0 {
+		context.SetOffset(params.offset)
 	}
-	fmt.Printf("Setting speedup to %v\n", wp.params.speedup)
-	wp.context.SetSpeedup(wp.params.speedup)
-	fmt.Printf("Setting no_context to %v\n", wp.params.no_context)
-	wp.context.SetNoContext(wp.params.no_context)
-	if wp.params.threads != 0 {
-		fmt.Printf("Setting threads to %v\n", wp.params.threads)
-		wp.context.SetThreads(wp.params.threads)
+	if params.duration != 0 {
+		context.SetDuration(params.duration)
 	}
-	if wp.params.max_len != 0 {
-		fmt.Printf("Setting max_len to %v\n", wp.params.max_len)
-		wp.context.SetMaxSegmentLength(wp.params.max_len)
+	context.SetNoContext(params.no_context)
+	context.SetTokenTimestamps(true)
+	if params.threads != 0 {
+		context.SetThreads(params.threads)
 	}
-	if wp.params.max_tokens != 0 {
-		fmt.Printf("Setting max_tokens to %v\n", wp.params.max_tokens)
-		wp.context.SetMaxTokensPerSegment(wp.params.max_tokens)
+	if params.max_len != 0 {
+		context.SetMaxSegmentLength(params.max_len)
 	}
-	if wp.params.word_thold != 0 {
-		fmt.Printf("Setting word_threshold to %v\n", wp.params.word_thold)
-		wp.context.SetTokenThreshold(float32(wp.params.word_thold))
+	if params.max_tokens != 0 {
+		context.SetMaxTokensPerSegment(params.max_tokens)
 	}
-
-	fmt.Printf("\n%s\n", wp.context.SystemInfo())
-
-	return err
+	if params.word_thold != 0 {
+		context.SetTokenThreshold(float32(params.word_thold))
+	}
+	return nil
 }
 
-func (wp *WhisperProcessor) Transcribe(file string) (output string, err error) {
-	var data []float32
-	var cb whisper.SegmentCallback
+// Transcribe loads the audio at file, which can be a local file or a URL,
+// and transcribes it
+func (wp *WhisperProcessor) Transcribe(ctx context.Context, file string) (*Transcript, error) {
+	data, err := wp.load(ctx, file)
+	if err != nil {
+		return nil, err
+	}
+	return wp.TranscribeSamples(ctx, data, nil)
+}
 
-	tmpfile := tempFileName("", ".wav")
-	// Convert the received audio to 16kHz WAV format
-	err = convertToWav(file, tmpfile)
+// Process loads the audio at file and transcribes it with params. The
+// offset and duration in params are checked against the length of the
+// audio. Calls are serialised, since a model only runs one job at a time.
+// Segments are passed to cb, which may be nil, as they are decoded. When
+// params choose channels, each channel of the audio is transcribed on its
+// own, and segments are only passed to cb for mono audio
+func (wp *WhisperProcessor) Process(ctx context.Context, params WhisperParams, file string, cb whisper.SegmentCallback) (*Transcript, error) {
+	log := loggerFrom(ctx)
+	start := time.Now()
+	channels, err := wp.loadAudio(ctx, file, params.channels)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		return "", err
+		return nil, err
+	}
+	data := channels[0]
+	log.Debug("audio loaded", "samples", len(data), "channels", len(channels), "elapsed", time.Since(start))
+	wp.metrics.Stage("load", time.Since(start))
+	length := time.Duration(len(data)) * time.Second / whisper.SampleRate
+	if err := validateWindow(params.offset, params.duration, length); err != nil {
+		return nil, err
 	}
 
-	output = ""
+	// Repeated audio is returned from the cache
+	var key string
+	if wp.cache != nil {
+		for _, channel := range channels[1:] {
+			data = append(data[:len(data):len(data)], channel...)
+		}
+		key = contentKey(data, params, wp.name)
+		if transcript, ok := wp.cache.Get(key); ok {
+			log.Debug("audio found in cache")
+			return transcript, nil
+		}
+	}
 
-	// Open the file
-	fmt.Printf("Loading %q\n", tmpfile)
-	fh, err := os.Open(tmpfile)
+	// Clean up the audio, before waiting for the model
+	if params.filters != "" {
+		start := time.Now()
+		for i := range channels {
+			channels[i] = preprocess(channels[i], params.filters)
+		}
+		log.Debug("audio preprocessed", "filters", params.filters, "elapsed", time.Since(start))
+		wp.metrics.Stage("preprocess", time.Since(start))
+	}
+
+	wp.Lock()
+	defer wp.Unlock()
+	if err := wp.PrepareModel(ctx, params); err != nil {
+		return nil, err
+	}
+	transcript, err := wp.transcribeChannels(ctx, channels, cb)
+	if err == nil {
+		wp.metrics.Timings(transcript.Timings)
+	}
+	if err == nil && key != "" {
+		if err := wp.cache.Put(key, transcript); err != nil {
+			log.Warn("caching transcript", "error", err)
+		} else {
+			transcript.addCacheKey(key)
+		}
+	}
+	return transcript, err
+}
+
+// NewContext returns a context for the model configured with params, apart
+// from the context used by jobs. It is run with ProcessContext
+func (wp *WhisperProcessor) NewContext(params WhisperParams) (whisper.Context, error) {
+	wp.Lock()
+	defer wp.Unlock()
+	if wp.model == nil {
+		return nil, fmt.Errorf("model %s is not loaded", wp.name)
+	}
+	context, err := wp.model.NewContext()
 	if err != nil {
-		fmt.Println(err)
-		return "", err
+		return nil, err
 	}
-	defer fh.Close()
+	if err := configure(context, params); err != nil {
+		return nil, err
+	}
+	return context, nil
+}
 
-	// Decode the WAV file - load the full buffer
-	dec := wav.NewDecoder(fh)
-	if buf, err := dec.FullPCMBuffer(); err != nil {
-		fmt.Println(err)
-		return "", err
-	} else if dec.SampleRate != whisper.SampleRate {
-		fmt.Println(fmt.Errorf("unsupported sample rate: %d", dec.SampleRate))
-		return "", err
-	} else if dec.NumChans != 1 {
-		fmt.Println(fmt.Errorf("unsupported number of channels: %d", dec.NumChans))
-		return "", err
-	} else {
-		data = buf.AsFloat32Buffer().Data
+// ProcessContext runs a context from NewContext on samples, taking turns
+// with the jobs using the model. Segments are passed to cb as they are
+// decoded
+func (wp *WhisperProcessor) ProcessContext(ctx context.Context, context whisper.Context, data []float32, cb whisper.SegmentCallback) error {
+	wp.Lock()
+	defer wp.Unlock()
+	if wp.model == nil {
+		return fmt.Errorf("model %s is not loaded", wp.name)
 	}
+	abort := func() bool {
+		return ctx.Err() != nil
+	}
+	return context.Process(data, cb, nil, abort)
+}
+
+// TranscribeSamples transcribes 16kHz mono samples with the prepared
+// context. Segments are passed to cb, which may be nil, as they are decoded
+func (wp *WhisperProcessor) TranscribeSamples(ctx context.Context, data []float32, cb whisper.SegmentCallback) (*Transcript, error) {
+	log := loggerFrom(ctx)
 
 	// Process the data
-	fmt.Printf("  ...processing %q\n", tmpfile)
+	log.Debug("processing", "samples", len(data))
 	wp.context.ResetTimings()
-	if err := wp.context.Process(data, cb); err != nil {
-		fmt.Println(err)
-		return "", err
+	progress := func(p int) {
+		log.Debug("progress", "percent", p)
+	}
+	abort := func() bool {
+		return ctx.Err() != nil
+	}
+	if err := wp.context.Process(data, cb, progress, abort); err != nil {
+		return nil, err
 	}
 
-	wp.context.PrintTimings()
-
+	transcript := &Transcript{
+		Model:    wp.name,
+		Language: wp.context.Language(),
+		Duration: time.Duration(len(data)) * time.Second / whisper.SampleRate,
+		Timings:  wp.context.Timings(),
+	}
+	log.Debug("timings",
+		"sample", transcript.Timings.Sample,
+		"encode", transcript.Timings.Encode,
+		"decode", transcript.Timings.Decode,
+		"batchd", transcript.Timings.Batchd,
+		"prompt", transcript.Timings.Prompt,
+		"total", transcript.Timings.Total,
+	)
 	for {
 		segment, err := wp.context.NextSegment()
 		if err == io.EOF {
@@ -175,23 +297,63 @@ func (wp *WhisperProcessor) Transcribe(file string) (output string, err error) {
 		} else if err != nil {
 			break
 		}
-		fmt.Printf("[%6s->%6s]", segment.Start.Truncate(time.Millisecond), segment.End.Truncate(time.Millisecond))
+		log.Debug("segment", "start", segment.Start, "end", segment.End, "text", segment.Text)
+		transcript.Segments = append(transcript.Segments, segment)
 
-		fmt.Println(" ", segment.Text)
+	}
+	if wp.params.speakers {
+		transcript.Speakers = wp.speakers(data, transcript.Segments)
+	}
 
-		output += segment.Text
+	return transcript, nil
+}
 
-	}
+///////////////////////////////////////////////////////////////////////////////
+// PRIVATE METHODS
 
-	os.Remove(tmpfile)
+// loadAudio returns the samples of each channel of the audio at file, or of
+// the audio mixed down to mono when channels is false
+func (wp *WhisperProcessor) loadAudio(ctx context.Context, file string, channels bool) ([][]float32, error) {
+	if channels {
+		result, err := wp.loadAll(ctx, file)
+		if err == nil && len(result) == 0 {
+			err = fmt.Errorf("no audio channels in %s", file)
+		}
+		return result, err
+	}
+	data, err := wp.load(ctx, file)
+	if err != nil {
+		return nil, err
+	}
+	return [][]float32{data}, nil
+}
 
-	return output, err
+// transcribeChannels transcribes the samples of each channel with the
+// prepared context, and merges the transcripts
+func (wp *WhisperProcessor) transcribeChannels(ctx context.Context, channels [][]float32, cb whisper.SegmentCallback) (*Transcript, error) {
+	if len(channels) == 1 {
+		return wp.TranscribeSamples(ctx, channels[0], cb)
+	}
+	transcripts := make([]*Transcript, 0, len(channels))
+	for i, data := range channels {
+		transcript, err := wp.TranscribeSamples(withLogger(ctx, loggerFrom(ctx).With("channel", i+1)), data, nil)
+		if err != nil {
+			return nil, err
+		}
+		transcripts = append(transcripts, transcript)
+	}
+	return mergeChannels(transcripts), nil
 }
 
-func tempFileName(prefix, suffix string) string {
-	randBytes := make([]byte, 16)
-	rand.Read(randBytes)
-	return filepath.Join(os.TempDir(), prefix+hex.EncodeToString(randBytes)+suffix)
+// speakers returns the speaker of each segment, from the speaker turns when
+// the model detects them, or else from the diarizer
+func (wp *WhisperProcessor) speakers(data []float32, segments []whisper.Segment) []int {
+	start := time.Now()
+	defer func() { wp.metrics.Stage("speakers", time.Since(start)) }()
+	if tinydiarize(wp.name) || wp.diarizer == nil {
+		return TurnDiarizer{}.Speakers(data, segments)
+	}
+	return wp.diarizer.Speakers(data, segments)
 }
 
 /*
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	// Packages
+	whisper "github.com/ggerganov/whisper.cpp/bindings/go/pkg/whisper"
+	fakewhisper "github.com/skrashevich/whisper.cpp-telegram/pkg/fake-whisper"
+	assert "github.com/stretchr/testify/assert"
+)
+
+// newTestProcessor returns a processor with a loaded fake model, which reads
+// ten seconds of silence for any file
+func newTestProcessor(t *testing.T, texts ...string) (*WhisperProcessor, *fakewhisper.Backend) {
+	t.Helper()
+	backend := fakewhisper.New(fakewhisper.Segments(2*time.Second, texts...)...)
+	wp := WPInit(backend)
+	wp.load = func(context.Context, string) ([]float32, error) {
+		return seconds(10), nil
+	}
+	if err := wp.LoadModel("ggml-tiny.bin"); err != nil {
+		t.Fatal(err)
+	}
+	return wp, backend
+}
+
+func seconds(n int) []float32 {
+	return make([]float32, n*whisper.SampleRate)
+}
+
+func Test_Process_000(t *testing.T) {
+	assert := assert.New(t)
+	wp, backend := newTestProcessor(t, "Hello", "world")
+
+	assert.NoError(wp.PrepareModel(context.Background(), WhisperParams{language: "auto"}))
+	transcript, err := wp.TranscribeSamples(context.Background(), seconds(4), nil)
+	assert.NoError(err)
+	assert.Equal("Hello world", transcript.Text())
+	assert.Equal(4*time.Second, transcript.Duration)
+	assert.Equal("auto", transcript.Language)
+	assert.True(backend.Contexts[0].TokenTimestamps())
+}
+
+func Test_Process_001(t *testing.T) {
+	assert := assert.New(t)
+	wp, backend := newTestProcessor(t)
+
+	assert.NoError(wp.PrepareModel(context.Background(), WhisperParams{
+		language:   "de",
+		translate:  true,
+		offset:     time.Second,
+		duration:   3 * time.Second,
+		threads:    2,
+		max_len:    40,
+		max_tokens: 8,
+	}))
+	assert.Len(backend.Contexts, 1)
+	fake := backend.Contexts[0]
+	assert.Equal("de", fake.Language())
+	assert.True(fake.Translate())
+	assert.Equal(time.Second, fake.Offset())
+	assert.Equal(3*time.Second, fake.Duration())
+	assert.Equal(uint(2), fake.Threads())
+	assert.Equal(uint(40), fake.MaxSegmentLength())
+	assert.Equal(uint(8), fake.MaxTokensPerSegment())
+
+	// Unsupported languages are rejected
+	assert.ErrorIs(wp.PrepareModel(context.Background(), WhisperParams{language: "xx"}), whisper.ErrUnsupportedLanguage)
+}
+
+func Test_Process_002(t *testing.T) {
+	assert := assert.New(t)
+	backend := fakewhisper.New()
+	backend.LoadErr = whisper.ErrUnableToLoadModel
+
+	wp := WPInit(backend)
+	assert.ErrorIs(wp.LoadModel("ggml-tiny.bin"), whisper.ErrUnableToLoadModel)
+}
+
+func Test_Process_003(t *testing.T) {
+	assert := assert.New(t)
+	wp, backend := newTestProcessor(t, "one", "two", "three", "four", "five", "six")
+
+	// The loader is used to read the file
+	assert.NoError(wp.PrepareModel(context.Background(), WhisperParams{language: "auto"}))
+	transcript, err := wp.Transcribe(context.Background(), "voice.oga")
+	assert.NoError(err)
+	assert.Equal("one two three four five", transcript.Text())
+	assert.Equal(10*whisper.SampleRate, backend.Contexts[0].ProcessedSamples())
+
+	// Loader and processing errors are returned
+	wp.load = func(context.Context, string) ([]float32, error) {
+		return nil, errors.New("conversion failed")
+	}
+	_, err = wp.Transcribe(context.Background(), "voice.oga")
+	assert.EqualError(err, "conversion failed")
+
+	backend.ProcessErr = whisper.ErrProcessingFailed
+	_, err = wp.TranscribeSamples(context.Background(), seconds(1), nil)
+	assert.ErrorIs(err, whisper.ErrProcessingFailed)
+}
+
+func Test_Process_004(t *testing.T) {
+	assert := assert.New(t)
+
+	// Ten seconds of audio with a one second segment per word
+	words := []string{"zero", "one", "two", "three", "four", "five", "six", "seven", "eight", "nine", "ten"}
+	backend := fakewhisper.New(fakewhisper.Segments(time.Second, words...)...)
+	wp := WPInit(backend)
+	wp.load = func(context.Context, string) ([]float32, error) {
+		return seconds(10), nil
+	}
+	assert.NoError(wp.LoadModel("ggml-tiny.bin"))
+
+	for _, test := range []struct {
+		offset, duration time.Duration
+		want             string
+		err              string
+	}{
+		{0, 0, "zero one two three four five six seven eight nine", ""},
+		{8 * time.Second, 0, "eight nine", ""},
+		{0, 3 * time.Second, "zero one two", ""},
+		{2 * time.Second, 2 * time.Second, "two three", ""},
+		{1500 * time.Millisecond, time.Second, "two", ""},
+		{7 * time.Second, time.Minute, "seven eight nine", ""},
+		{10 * time.Second, 0, "", "offset 10s is beyond the end of the audio (10s)"},
+		{-time.Second, 0, "", "offset -1s is negative"},
+	} {
+		transcript, err := wp.Process(context.Background(), WhisperParams{language: "auto", offset: test.offset, duration: test.duration}, "voice.oga", nil)
+		if test.err != "" {
+			assert.EqualError(err, test.err)
+			continue
+		}
+		if assert.NoError(err) {
+			assert.Equal(test.want, transcript.Text(), "offset=%v duration=%v", test.offset, test.duration)
+		}
+		fake := backend.Contexts[len(backend.Contexts)-1]
+		assert.Equal(test.offset, fake.Offset())
+		assert.Equal(test.duration, fake.Duration())
+	}
+}
+
+// speakerFunc is a diarizer which calls a function
+type speakerFunc func([]float32, []whisper.Segment) []int
+
+func (fn speakerFunc) Speakers(samples []float32, segments []whisper.Segment) []int {
+	return fn(samples, segments)
+}
+
+func Test_Process_005(t *testing.T) {
+	assert := assert.New(t)
+	wp, backend := newTestProcessor(t, "one", "two", "three")
+	backend.Segments[0].SpeakerTurnNext = true
+
+	// Speakers are not detected unless asked for
+	transcript, err := wp.Process(context.Background(), WhisperParams{language: "auto"}, "voice.oga", nil)
+	assert.NoError(err)
+	assert.Nil(transcript.Speakers)
+	assert.False(backend.Contexts[0].SpeakerTurns())
+
+	// Models without speaker turns use the diarizer on the samples
+	wp.diarizer = speakerFunc(func(samples []float32, segments []whisper.Segment) []int {
+		assert.Len(samples, 10*whisper.SampleRate)
+		return []int{1, 2, 1}
+	})
+	transcript, err = wp.Process(context.Background(), WhisperParams{language: "auto", speakers: true}, "voice.oga", nil)
+	assert.NoError(err)
+	assert.Equal([]int{1, 2, 1}, transcript.Speakers)
+	assert.False(backend.Contexts[1].SpeakerTurns())
+
+	// Tinydiarize models report speaker turns
+	wp.name = "ggml-small.en-tdrz"
+	transcript, err = wp.Process(context.Background(), WhisperParams{language: "auto", speakers: true}, "voice.oga", nil)
+	assert.NoError(err)
+	assert.Equal([]int{1, 2, 2}, transcript.Speakers)
+	assert.True(backend.Contexts[2].SpeakerTurns())
+}
+
+func Test_Process_006(t *testing.T) {
+	assert := assert.New(t)
+	wp, backend := newTestProcessor(t, "one", "two")
+	wp.loadAll = func(context.Context, string) ([][]float32, error) {
+		return [][]float32{seconds(4), seconds(4)}, nil
+	}
+
+	// Each channel is transcribed, and segments are not passed on
+	var segments []whisper.Segment
+	transcript, err := wp.Process(context.Background(), WhisperParams{language: "auto", channels: true}, "call.wav", func(segment whisper.Segment) {
+		segments = append(segments, segment)
+	})
+	assert.NoError(err)
+	assert.Equal("one one two two", transcript.Text())
+	assert.Equal([]int{1, 2, 1, 2}, transcript.Channels)
+	assert.Empty(segments)
+	assert.Equal(4*whisper.SampleRate, backend.Contexts[0].ProcessedSamples())
+
+	// Mono audio is transcribed as usual
+	wp.loadAll = func(context.Context, string) ([][]float32, error) {
+		return [][]float32{seconds(4)}, nil
+	}
+	transcript, err = wp.Process(context.Background(), WhisperParams{language: "auto", channels: true}, "voice.oga", func(segment whisper.Segment) {
+		segments = append(segments, segment)
+	})
+	assert.NoError(err)
+	assert.Equal("one two", transcript.Text())
+	assert.Nil(transcript.Channels)
+	assert.Len(segments, 2)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"html"
+	"sync"
+	"time"
+
+	// Packages
+	whisper "github.com/ggerganov/whisper.cpp/bindings/go/pkg/whisper"
+	"gopkg.in/telebot.v3"
+)
+
+const (
+	// Number of characters of the transcript shown while it is in progress,
+	// within the telegram limit of 4096 characters for a message
+	maxProgress = 3500
+)
+
+// messenger sends and edits telegram messages and looks up chat members, and
+// is implemented by telebot.Bot
+type messenger interface {
+	Send(to telebot.Recipient, what interface{}, opts ...interface{}) (*telebot.Message, error)
+	Edit(msg telebot.Editable, what interface{}, opts ...interface{}) (*telebot.Message, error)
+	ChatMemberOf(chat, user telebot.Recipient) (*telebot.ChatMember, error)
+}
+
+// Progress shows a transcript while it is in progress. A placeholder
+// message is sent with the first segment and edited as more segments
+// arrive, at most once per interval to stay within the telegram rate limits
+type Progress struct {
+	sync.Mutex
+	telegram messenger
+	chat     telebot.Recipient
+	options  *telebot.SendOptions
+	interval time.Duration
+	segments []string
+	msg      *telebot.Message
+	last     time.Time
+	timer    *time.Timer
+	done     bool
+
+	// Held while talking to telegram, so edits do not overlap
+	sending sync.Mutex
+}
+
+// NewProgress returns progress which is shown in chat, with the placeholder
+// sent with options
+func NewProgress(telegram messenger, chat telebot.Recipient, options *telebot.SendOptions, interval time.Duration) *Progress {
+	if options == nil {
+		options = &telebot.SendOptions{}
+	}
+	return &Progress{telegram: telegram, chat: chat, options: options, interval: interval}
+}
+
+// Segment adds a segment to the transcript. It is a whisper segment
+// callback, so it returns straight away and telegram is updated later
+func (p *Progress) Segment(segment whisper.Segment) {
+	p.Lock()
+	defer p.Unlock()
+	if p.done {
+		return
+	}
+	p.segments = append(p.segments, segment.Text)
+	if p.timer != nil {
+		return
+	}
+	wait := p.interval - time.Since(p.last)
+	if wait < 0 {
+		wait = 0
+	}
+	p.timer = time.AfterFunc(wait, p.update)
+}
+
+// Finish stops updating the placeholder and replaces it with text. It
+// returns false if there is no placeholder or it could not be edited, in
+// which case the text should be sent as a new message
+func (p *Progress) Finish(text string, opts ...interface{}) bool {
+	if p == nil {
+		return false
+	}
+	p.Cancel()
+
+	// Wait for an update in flight
+	p.sending.Lock()
+	defer p.sending.Unlock()
+	if p.msg == nil {
+		return false
+	}
+	_, err := p.telegram.Edit(p.msg, text, opts...)
+	return err == nil
+}
+
+// Cancel stops updating the placeholder, leaving it as it is
+func (p *Progress) Cancel() {
+	if p == nil {
+		return
+	}
+	p.Lock()
+	defer p.Unlock()
+	p.done = true
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// PRIVATE METHODS
+
+// update sends or edits the placeholder with the segments so far
+func (p *Progress) update() {
+	p.sending.Lock()
+	defer p.sending.Unlock()
+	p.Lock()
+	if p.done {
+		p.Unlock()
+		return
+	}
+	text := progressText(p.segments)
+	p.timer, p.last = nil, time.Now()
+	msg := p.msg
+	p.Unlock()
+
+	if msg == nil {
+		sent, err := p.telegram.Send(p.chat, text, p.options, telebot.ModeHTML)
+		if err != nil {
+			return
+		}
+		p.Lock()
+		p.msg = sent
+		p.Unlock()
+	} else {
+		p.telegram.Edit(msg, text, telebot.ModeHTML)
+	}
+}
+
+// progressText returns the end of the transcript so far, marked as
+// unfinished
+func progressText(segments []string) string {
+	text := []rune(joinText(segments))
+	if len(text) > maxProgress {
+		text = append([]rune("…"), text[len(text)-maxProgress:]...)
+	}
+	return html.EscapeString(string(text)) + " …"
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	// Packages
+	whisper "github.com/ggerganov/whisper.cpp/bindings/go/pkg/whisper"
+	assert "github.com/stretchr/testify/assert"
+	"gopkg.in/telebot.v3"
+)
+
+// fakeMessenger records the messages sent and the edits made to them
+type fakeMessenger struct {
+	sync.Mutex
+	sent   []interface{}
+	edits  []interface{}
+	failed bool
+
+	// Recipient IDs of the users who are chat admins
+	admins map[string]bool
+}
+
+func (m *fakeMessenger) Send(to telebot.Recipient, what interface{}, opts ...interface{}) (*telebot.Message, error) {
+	m.Lock()
+	defer m.Unlock()
+	m.sent = append(m.sent, what)
+	return &telebot.Message{ID: len(m.sent)}, nil
+}
+
+func (m *fakeMessenger) Edit(msg telebot.Editable, what interface{}, opts ...interface{}) (*telebot.Message, error) {
+	m.Lock()
+	defer m.Unlock()
+	if m.failed {
+		return nil, errors.New("message can't be edited")
+	}
+	m.edits = append(m.edits, what)
+	return nil, nil
+}
+
+func (m *fakeMessenger) ChatMemberOf(chat, user telebot.Recipient) (*telebot.ChatMember, error) {
+	m.Lock()
+	defer m.Unlock()
+	if m.admins[user.Recipient()] {
+		return &telebot.ChatMember{Role: telebot.Administrator}, nil
+	}
+	return &telebot.ChatMember{Role: telebot.Member}, nil
+}
+
+func (m *fakeMessenger) counts() (int, int) {
+	m.Lock()
+	defer m.Unlock()
+	return len(m.sent), len(m.edits)
+}
+
+func Test_Progress_000(t *testing.T) {
+	assert := assert.New(t)
+	telegram := new(fakeMessenger)
+	progress := NewProgress(telegram, &telebot.Chat{ID: 42}, nil, 50*time.Millisecond)
+
+	// The first segment is sent straight away and later ones are batched
+	progress.Segment(whisper.Segment{Text: "Hello"})
+	assert.Eventually(func() bool {
+		sent, _ := telegram.counts()
+		return sent == 1
+	}, time.Second, time.Millisecond)
+	progress.Segment(whisper.Segment{Text: " <big>"})
+	progress.Segment(whisper.Segment{Text: " world"})
+	assert.Eventually(func() bool {
+		_, edits := telegram.counts()
+		return edits == 1
+	}, time.Second, time.Millisecond)
+	assert.Equal([]interface{}{"Hello …"}, telegram.sent)
+	assert.Equal([]interface{}{"Hello &lt;big&gt; world …"}, telegram.edits)
+
+	// Finishing replaces the text, and later segments are ignored
+	assert.True(progress.Finish("Done"))
+	progress.Segment(whisper.Segment{Text: " again"})
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal([]interface{}{"Hello …"}, telegram.sent)
+	assert.Equal([]interface{}{"Hello &lt;big&gt; world …", "Done"}, telegram.edits)
+
+	// Long transcripts show the end
+	text := progressText([]string{strings.Repeat("a", maxProgress), "bc"})
+	assert.True(strings.HasPrefix(text, "…aa"))
+	assert.True(strings.HasSuffix(text, "a bc …"))
+	assert.Len([]rune(text), maxProgress+3)
+}
+
+func Test_Progress_001(t *testing.T) {
+	assert := assert.New(t)
+	telegram := new(fakeMessenger)
+
+	// Nothing to finish without a placeholder or progress
+	var progress *Progress
+	assert.False(progress.Finish("Done"))
+	progress = NewProgress(telegram, &telebot.Chat{ID: 42}, nil, time.Hour)
+	assert.False(progress.Finish("Done"))
+
+	// The text is sent again when the placeholder can't be edited
+	progress = NewProgress(telegram, &telebot.Chat{ID: 42}, nil, time.Hour)
+	progress.Segment(whisper.Segment{Text: "Hello"})
+	assert.Eventually(func() bool {
+		sent, _ := telegram.counts()
+		return sent == 1
+	}, time.Second, time.Millisecond)
+	telegram.failed = true
+	assert.False(progress.Finish("Done"))
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	// Packages
+	bolt "go.etcd.io/bbolt"
+	"gopkg.in/telebot.v3"
+)
+
+var (
+	bucketJobs = []byte("jobs")
+)
+
+const (
+	// Jobs are dropped after being resumed this many times, in case they
+	// are the reason for the restarts
+	maxAttempts = 3
+)
+
+// Queue stores the telegram jobs which have not finished in a bolt database,
+// so they can be resumed after a restart
+type Queue struct {
+	db *bolt.DB
+}
+
+// Job is a transcription of a file sent over telegram
+type Job struct {
+	ID       uint64        `json:"id"`
+	Chat     int64         `json:"chat"`
+	User     int64         `json:"user"`
+	Message  int           `json:"message"`
+	FileID   string        `json:"file_id"`
+	UniqueID string        `json:"unique_id,omitempty"`
+	Size     int64         `json:"size,omitempty"`
+	Length   time.Duration `json:"length,omitempty"`
+	Offset   time.Duration `json:"offset,omitempty"`
+	Duration time.Duration `json:"duration,omitempty"`
+	Model    string        `json:"model"`
+	Created  time.Time     `json:"created"`
+	Attempts int           `json:"attempts,omitempty"`
+
+	// Translate to english and the output format, when they differ from
+	// the defaults
+	Translate bool   `json:"translate,omitempty"`
+	Format    string `json:"format,omitempty"`
+
+	// Forum topic of the message, if any
+	Thread int `json:"thread,omitempty"`
+
+	// History record of the transcript, which is replaced when the job runs
+	// again
+	Record uint64 `json:"record,omitempty"`
+}
+
+// OpenQueue opens or creates the queue database at path
+func OpenQueue(path string) (*Queue, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketJobs)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Queue{db: db}, nil
+}
+
+// Close closes the database
+func (q *Queue) Close() error {
+	return q.db.Close()
+}
+
+// Put stores a job, setting its ID and creation time when it is new
+func (q *Queue) Put(job *Job) error {
+	return q.db.Update(func(tx *bolt.Tx) error {
+		jobs := tx.Bucket(bucketJobs)
+		if job.ID == 0 {
+			id, err := jobs.NextSequence()
+			if err != nil {
+				return err
+			}
+			job.ID = id
+		}
+		if job.Created.IsZero() {
+			job.Created = time.Now()
+		}
+		data, err := json.Marshal(job)
+		if err != nil {
+			return err
+		}
+		return jobs.Put(itob(job.ID), data)
+	})
+}
+
+// Remove deletes a job which has finished
+func (q *Queue) Remove(id uint64) error {
+	return q.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketJobs).Delete(itob(id))
+	})
+}
+
+// Jobs returns the stored jobs, oldest first
+func (q *Queue) Jobs() ([]*Job, error) {
+	var result []*Job
+	err := q.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketJobs).ForEach(func(k, v []byte) error {
+			job := new(Job)
+			if err := json.Unmarshal(v, job); err != nil {
+				return err
+			}
+			result = append(result, job)
+			return nil
+		})
+	})
+	return result, err
+}
+
+// File returns the telegram file of the job
+func (job *Job) File() *telebot.File {
+	return &telebot.File{FileID: job.FileID, UniqueID: job.UniqueID, FileSize: job.Size}
+}
+
+// message returns a message standing in for the one which requested the
+// job, to reply to when it is resumed
+func (job *Job) message() *telebot.Message {
+	return &telebot.Message{
+		ID:           job.Message,
+		Chat:         &telebot.Chat{ID: job.Chat},
+		Sender:       &telebot.User{ID: job.User},
+		ThreadID:     job.Thread,
+		TopicMessage: job.Thread != 0,
+	}
+}
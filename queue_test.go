@@ -0,0 +1,51 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	// Packages
+	assert "github.com/stretchr/testify/assert"
+)
+
+func newTestQueue(t *testing.T) *Queue {
+	t.Helper()
+	queue, err := OpenQueue(filepath.Join(t.TempDir(), "queue.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { queue.Close() })
+	return queue
+}
+
+func Test_Queue_000(t *testing.T) {
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "queue.db")
+	queue, err := OpenQueue(path)
+	assert.NoError(err)
+
+	first := &Job{Chat: 1, User: 10, Message: 5, FileID: "voice", Model: "ggml-tiny", Offset: time.Second}
+	assert.NoError(queue.Put(first))
+	assert.Equal(uint64(1), first.ID)
+	assert.False(first.Created.IsZero())
+	second := &Job{Chat: 2, User: 20, Message: 6, FileID: "audio", Model: "ggml-base"}
+	assert.NoError(queue.Put(second))
+	assert.NoError(queue.Remove(first.ID))
+	second.Attempts++
+	assert.NoError(queue.Put(second))
+	assert.NoError(queue.Close())
+
+	// Jobs survive reopening the database
+	queue, err = OpenQueue(path)
+	assert.NoError(err)
+	defer queue.Close()
+	jobs, err := queue.Jobs()
+	assert.NoError(err)
+	if assert.Len(jobs, 1) {
+		assert.Equal(uint64(2), jobs[0].ID)
+		assert.Equal("audio", jobs[0].File().FileID)
+		assert.Equal(1, jobs[0].Attempts)
+		assert.WithinDuration(second.Created, jobs[0].Created, 0)
+	}
+}
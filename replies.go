@@ -0,0 +1,59 @@
+package main
+
+import (
+	"sync"
+)
+
+const (
+	// Number of replies which are remembered
+	maxReplies = 1000
+)
+
+// Reply is a transcript sent to a chat, with the job which produced it
+type Reply struct {
+	Job        Job
+	Transcript *Transcript
+
+	// Shown under the transcript, with the time taken and the model
+	Footer string
+}
+
+// Replies remembers the latest replies, so the buttons under a reply can run
+// its job again with other parameters, or send its transcript in another
+// format. The replies are only kept in memory, so the buttons stop working
+// after a restart
+type Replies struct {
+	sync.Mutex
+	size    int
+	seq     uint64
+	replies map[uint64]Reply
+}
+
+// NewReplies returns a store which remembers the last size replies
+func NewReplies(size int) *Replies {
+	return &Replies{size: size, replies: make(map[uint64]Reply)}
+}
+
+// Add remembers a reply, and returns the ID used to get it back
+func (r *Replies) Add(reply Reply) uint64 {
+	r.Lock()
+	defer r.Unlock()
+	r.seq++
+	r.replies[r.seq] = reply
+	if r.seq > uint64(r.size) {
+		delete(r.replies, r.seq-uint64(r.size))
+	}
+	return r.seq
+}
+
+// Get returns a copy of a reply, or false if it has been forgotten. The
+// transcript is shared, and must not be changed
+func (r *Replies) Get(id uint64) (*Reply, bool) {
+	r.Lock()
+	defer r.Unlock()
+	reply, ok := r.replies[id]
+	if !ok {
+		return nil, false
+	}
+	return &reply, true
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	// Packages
+	assert "github.com/stretchr/testify/assert"
+)
+
+func Test_Replies_000(t *testing.T) {
+	assert := assert.New(t)
+	replies := NewReplies(2)
+
+	first := replies.Add(Reply{Job: Job{FileID: "first"}, Transcript: &Transcript{Model: "ggml-tiny"}, Footer: "footer"})
+	second := replies.Add(Reply{Job: Job{FileID: "second"}})
+	assert.NotEqual(first, second)
+
+	// Jobs are copied, so changing them does not change the reply
+	reply, ok := replies.Get(first)
+	if assert.True(ok) {
+		assert.Equal("first", reply.Job.FileID)
+		assert.Equal("ggml-tiny", reply.Transcript.Model)
+		assert.Equal("footer", reply.Footer)
+		reply.Job.FileID = "changed"
+	}
+	reply, _ = replies.Get(first)
+	assert.Equal("first", reply.Job.FileID)
+
+	// The oldest reply is forgotten
+	third := replies.Add(Reply{Job: Job{FileID: "third"}})
+	_, ok = replies.Get(first)
+	assert.False(ok)
+	_, ok = replies.Get(second)
+	assert.True(ok)
+	_, ok = replies.Get(third)
+	assert.True(ok)
+	_, ok = replies.Get(0)
+	assert.False(ok)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Route describes a job when choosing the model for it
+type Route struct {
+	// Length of the audio, or zero when unknown
+	Duration time.Duration
+
+	// Number of jobs waiting or running, not counting this one
+	Queue int
+
+	// Tier of the user who sent the job, or empty
+	Tier string
+}
+
+// Rule selects a model when all of its conditions match. A rule without
+// conditions always matches
+type Rule struct {
+	Model      string
+	Conditions []Condition
+}
+
+// Condition compares a field of a route with a value. The duration and
+// queue fields support the <, <=, >, >= and = operators, and the tier field
+// supports = and !=. Duration conditions never match when the length of the
+// audio is unknown
+type Condition struct {
+	Field string
+	Op    string
+	Value string
+
+	number float64
+}
+
+// Router chooses the model for a job from an ordered list of rules
+type Router struct {
+	Rules []Rule
+
+	// Tiers of users by telegram user ID
+	Tiers map[int64]string
+}
+
+var (
+	reCondition = regexp.MustCompile(`^(duration|queue|tier)\s*(<=|>=|!=|<|>|=)\s*(\S+)$`)
+)
+
+// ParseRules parses rules in the form "model:condition,condition;model:..."
+// such as "ggml-tiny:duration<=30s;ggml-base:queue>=4;ggml-medium"
+func ParseRules(spec string) ([]Rule, error) {
+	var rules []Rule
+	for _, part := range strings.Split(spec, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		model, conditions, _ := strings.Cut(part, ":")
+		rule := Rule{Model: strings.TrimSpace(model)}
+		if rule.Model == "" {
+			return nil, fmt.Errorf("rule %q has no model", part)
+		}
+		for _, condition := range strings.Split(conditions, ",") {
+			if condition = strings.TrimSpace(condition); condition == "" {
+				continue
+			}
+			c, err := parseCondition(condition)
+			if err != nil {
+				return nil, err
+			}
+			rule.Conditions = append(rule.Conditions, c)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// ParseTiers parses user tiers in the form "123=premium,456=free"
+func ParseTiers(spec string) (map[int64]string, error) {
+	tiers := make(map[int64]string)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		user, tier, ok := strings.Cut(part, "=")
+		id, err := strconv.ParseInt(strings.TrimSpace(user), 10, 64)
+		if !ok || err != nil || strings.TrimSpace(tier) == "" {
+			return nil, fmt.Errorf("invalid tier %q, expected <user id>=<tier>", part)
+		}
+		tiers[id] = strings.TrimSpace(tier)
+	}
+	return tiers, nil
+}
+
+// Model returns the model of the first rule which matches the route, or the
+// empty string if there is none
+func (r *Router) Model(route Route) string {
+	if r == nil {
+		return ""
+	}
+	for _, rule := range r.Rules {
+		if rule.Match(route) {
+			return rule.Model
+		}
+	}
+	return ""
+}
+
+// Tier returns the tier of a user, or the empty string
+func (r *Router) Tier(user int64) string {
+	if r == nil {
+		return ""
+	}
+	return r.Tiers[user]
+}
+
+// Match returns true if every condition matches the route
+func (rule Rule) Match(route Route) bool {
+	for _, c := range rule.Conditions {
+		if !c.Match(route) {
+			return false
+		}
+	}
+	return true
+}
+
+// Match returns true if the condition holds for the route
+func (c Condition) Match(route Route) bool {
+	switch c.Field {
+	case "duration":
+		if route.Duration <= 0 {
+			return false
+		}
+		return compare(c.Op, route.Duration.Seconds(), c.number)
+	case "queue":
+		return compare(c.Op, float64(route.Queue), c.number)
+	case "tier":
+		return (route.Tier == c.Value) == (c.Op == "=")
+	default:
+		return false
+	}
+}
+
+func (c Condition) String() string {
+	return c.Field + c.Op + c.Value
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// PRIVATE METHODS
+
+func parseCondition(s string) (Condition, error) {
+	match := reCondition.FindStringSubmatch(s)
+	if match == nil {
+		return Condition{}, fmt.Errorf("invalid condition %q", s)
+	}
+	c := Condition{Field: match[1], Op: match[2], Value: match[3]}
+	switch c.Field {
+	case "duration":
+		d, err := parseTimestamp(c.Value)
+		if err != nil {
+			return c, fmt.Errorf("condition %q: %w", s, err)
+		}
+		c.number = d.Seconds()
+	case "queue":
+		n, err := strconv.ParseUint(c.Value, 10, 32)
+		if err != nil {
+			return c, fmt.Errorf("condition %q: invalid queue depth", s)
+		}
+		c.number = float64(n)
+	case "tier":
+		if c.Op != "=" && c.Op != "!=" {
+			return c, fmt.Errorf("condition %q: tiers can only be compared with = or !=", s)
+		}
+	}
+	if c.Field != "tier" && c.Op == "!=" {
+		return c, fmt.Errorf("condition %q: unsupported operator %s", s, c.Op)
+	}
+	return c, nil
+}
+
+func compare(op string, a, b float64) bool {
+	switch op {
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	case "=":
+		return a == b
+	default:
+		return false
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	// Packages
+	assert "github.com/stretchr/testify/assert"
+)
+
+func Test_Routing_000(t *testing.T) {
+	assert := assert.New(t)
+	rules, err := ParseRules("ggml-tiny:duration<=30s; ggml-base:queue>=4,duration<5:00;ggml-large:tier=premium;ggml-medium")
+	assert.NoError(err)
+	if assert.Len(rules, 4) {
+		assert.Equal("ggml-tiny", rules[0].Model)
+		assert.Equal("duration<=30s", rules[0].Conditions[0].String())
+		assert.Len(rules[1].Conditions, 2)
+		assert.Empty(rules[3].Conditions)
+	}
+
+	router := &Router{Rules: rules}
+	for _, test := range []struct {
+		route Route
+		model string
+	}{
+		{Route{Duration: 3 * time.Second}, "ggml-tiny"},
+		{Route{Duration: 30 * time.Second}, "ggml-tiny"},
+		{Route{Duration: time.Minute}, "ggml-medium"},
+		{Route{Duration: time.Minute, Queue: 4}, "ggml-base"},
+		{Route{Duration: 10 * time.Minute, Queue: 4}, "ggml-medium"},
+		{Route{Duration: 10 * time.Minute, Tier: "premium"}, "ggml-large"},
+		{Route{Queue: 8}, "ggml-medium"},
+	} {
+		assert.Equal(test.model, router.Model(test.route), "%+v", test.route)
+	}
+
+	// Without rules nothing matches
+	assert.Equal("", (*Router)(nil).Model(Route{Duration: time.Second}))
+	assert.Equal("", (&Router{}).Model(Route{Duration: time.Second}))
+}
+
+func Test_Routing_001(t *testing.T) {
+	assert := assert.New(t)
+	for _, spec := range []string{
+		":duration<30s",
+		"ggml-tiny:length<30s",
+		"ggml-tiny:duration<soon",
+		"ggml-tiny:queue>=-1",
+		"ggml-tiny:queue!=1",
+		"ggml-tiny:tier>premium",
+	} {
+		_, err := ParseRules(spec)
+		assert.Error(err, spec)
+	}
+
+	tiers, err := ParseTiers("123=premium, 456=free")
+	assert.NoError(err)
+	assert.Equal(map[int64]string{123: "premium", 456: "free"}, tiers)
+	router := &Router{Tiers: tiers}
+	assert.Equal("premium", router.Tier(123))
+	assert.Equal("", router.Tier(789))
+	_, err = ParseTiers("alice=premium")
+	assert.Error(err)
+	_, err = ParseTiers("123")
+	assert.Error(err)
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Priority classes of jobs, most urgent first
+const (
+	PriorityAdmin = iota
+	PriorityShort
+	PriorityNormal
+)
+
+// Scheduler decides the order in which waiting jobs run. The most urgent
+// priority class goes first, and a job moves up a class for each aging
+// period it waits so that none are starved. Within a class, chats take turns
+// so one chat sending many files does not hold up the others, and each
+// chat's jobs run in the order they arrived
+type Scheduler struct {
+	sync.Mutex
+	slots   int
+	short   time.Duration
+	aging   time.Duration
+	seq     uint64
+	served  map[int64]uint64
+	waiting []*Ticket
+	running []*Ticket
+}
+
+// Ticket is a job waiting for its turn
+type Ticket struct {
+	Chat     int64
+	User     int64
+	Priority int
+	Length   time.Duration
+
+	queued  time.Time
+	started time.Time
+	ready   chan struct{}
+}
+
+// Position is the place of a job in the order the scheduler will run them,
+// where position 0 is running
+type Position struct {
+	Position int
+	Length   time.Duration
+
+	// Estimated time until the job is done, zero when unknown
+	ETA time.Duration
+}
+
+// NewScheduler returns a scheduler which runs up to slots jobs at once.
+// Jobs no longer than short are given priority, and jobs move up a priority
+// class for each aging period they have waited. Zero disables either
+func NewScheduler(slots int, short, aging time.Duration) *Scheduler {
+	if slots < 1 {
+		slots = 1
+	}
+	return &Scheduler{
+		slots:  slots,
+		short:  short,
+		aging:  aging,
+		served: make(map[int64]uint64),
+	}
+}
+
+// Priority returns the priority class of a job
+func (s *Scheduler) Priority(admin bool, length time.Duration) int {
+	switch {
+	case admin:
+		return PriorityAdmin
+	case s != nil && s.short > 0 && length > 0 && length <= s.short:
+		return PriorityShort
+	default:
+		return PriorityNormal
+	}
+}
+
+// Wait blocks until it is the turn of the ticket or ctx is done. The
+// returned function must be called when the job has finished, to give the
+// next job its turn
+func (s *Scheduler) Wait(ctx context.Context, t *Ticket) (func(), error) {
+	if s == nil {
+		return func() {}, nil
+	}
+	s.Lock()
+	t.queued, t.ready = time.Now(), make(chan struct{})
+	s.waiting = append(s.waiting, t)
+	s.dispatch()
+	s.Unlock()
+
+	select {
+	case <-t.ready:
+		return func() { s.finish(t) }, nil
+	case <-ctx.Done():
+		s.Lock()
+		defer s.Unlock()
+		if i := indexTicket(s.waiting, t); i >= 0 {
+			s.waiting = append(s.waiting[:i], s.waiting[i+1:]...)
+			s.forget(t.Chat)
+		} else {
+			s.remove(t)
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// Waiting returns the number of jobs waiting for their turn
+func (s *Scheduler) Waiting() int {
+	if s == nil {
+		return 0
+	}
+	s.Lock()
+	defer s.Unlock()
+	return len(s.waiting)
+}
+
+// Running returns the number of jobs which have their turn
+func (s *Scheduler) Running() int {
+	if s == nil {
+		return 0
+	}
+	s.Lock()
+	defer s.Unlock()
+	return len(s.running)
+}
+
+// Positions returns the jobs of a user, running jobs first and then the
+// waiting jobs in the order they will run. The ETA is estimated from the
+// length of the jobs and rtf, the time taken per second of audio
+func (s *Scheduler) Positions(user int64, rtf float64) []Position {
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	estimate := func(length time.Duration) time.Duration {
+		return time.Duration(float64(length) * rtf)
+	}
+
+	// Each slot is free once the job using it is done
+	free := make([]time.Duration, s.slots)
+	var result []Position
+	for i, t := range s.running {
+		left := estimate(t.Length) - now.Sub(t.started)
+		if left < 0 {
+			left = 0
+		}
+		if i < len(free) {
+			free[i] = left
+		}
+		if t.User == user {
+			result = append(result, Position{Length: t.Length, ETA: left})
+		}
+	}
+	for i, t := range s.order(now) {
+		sort.Slice(free, func(i, j int) bool { return free[i] < free[j] })
+		free[0] += estimate(t.Length)
+		if t.User == user {
+			result = append(result, Position{Position: i + 1, Length: t.Length, ETA: free[0]})
+		}
+	}
+	if rtf <= 0 {
+		for i := range result {
+			result[i].ETA = 0
+		}
+	}
+	return result
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// PRIVATE METHODS
+
+// dispatch starts waiting jobs while there are free slots. It must be called
+// with the lock held
+func (s *Scheduler) dispatch() {
+	now := time.Now()
+	for len(s.running) < s.slots && len(s.waiting) > 0 {
+		i := s.next(s.waiting, s.served, now)
+		t := s.waiting[i]
+		s.waiting = append(s.waiting[:i], s.waiting[i+1:]...)
+		s.seq++
+		s.served[t.Chat] = s.seq
+		t.started = now
+		s.running = append(s.running, t)
+		close(t.ready)
+	}
+}
+
+func (s *Scheduler) finish(t *Ticket) {
+	s.Lock()
+	defer s.Unlock()
+	s.remove(t)
+}
+
+// remove ends the turn of a running job. It must be called with the lock
+// held
+func (s *Scheduler) remove(t *Ticket) {
+	if i := indexTicket(s.running, t); i >= 0 {
+		s.running = append(s.running[:i], s.running[i+1:]...)
+	}
+	s.forget(t.Chat)
+	s.dispatch()
+}
+
+// forget removes when a chat was last served once it has no jobs waiting or
+// running, so the scheduler does not remember every chat it has seen. The
+// chat takes its turn like a new chat when it sends another job. It must be
+// called with the lock held
+func (s *Scheduler) forget(chat int64) {
+	for _, tickets := range [][]*Ticket{s.running, s.waiting} {
+		for _, t := range tickets {
+			if t.Chat == chat {
+				return
+			}
+		}
+	}
+	delete(s.served, chat)
+}
+
+// next returns the index of the waiting job to run next, given when each
+// chat was last served
+func (s *Scheduler) next(waiting []*Ticket, served map[int64]uint64, now time.Time) int {
+	best := -1
+	var bestPriority int
+	for i, t := range waiting {
+		priority := s.effective(t, now)
+		switch {
+		case best < 0, priority < bestPriority:
+		case priority > bestPriority:
+			continue
+		case served[t.Chat] < served[waiting[best].Chat]:
+		case served[t.Chat] == served[waiting[best].Chat] && t.queued.Before(waiting[best].queued):
+		default:
+			continue
+		}
+		best, bestPriority = i, priority
+	}
+	return best
+}
+
+// order returns the waiting jobs in the order they will run if no other
+// jobs arrive. It must be called with the lock held
+func (s *Scheduler) order(now time.Time) []*Ticket {
+	served := make(map[int64]uint64, len(s.served))
+	for chat, seq := range s.served {
+		served[chat] = seq
+	}
+	waiting := append([]*Ticket(nil), s.waiting...)
+	result := make([]*Ticket, 0, len(waiting))
+	for seq := s.seq; len(waiting) > 0; {
+		i := s.next(waiting, served, now)
+		t := waiting[i]
+		waiting = append(waiting[:i], waiting[i+1:]...)
+		seq++
+		served[t.Chat] = seq
+		result = append(result, t)
+	}
+	return result
+}
+
+// effective returns the priority class of a job after aging
+func (s *Scheduler) effective(t *Ticket, now time.Time) int {
+	priority := t.Priority
+	if s.aging > 0 {
+		priority -= int(now.Sub(t.queued) / s.aging)
+	}
+	if priority < PriorityAdmin {
+		priority = PriorityAdmin
+	}
+	return priority
+}
+
+func indexTicket(tickets []*Ticket, t *Ticket) int {
+	for i, other := range tickets {
+		if other == t {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	// Packages
+	assert "github.com/stretchr/testify/assert"
+)
+
+// enqueue waits for a turn in the background, sending name to order when
+// the turn comes and then finishing straight away
+func enqueue(t *testing.T, s *Scheduler, ticket *Ticket, name string, order chan string) {
+	t.Helper()
+	waiting := s.Waiting()
+	go func() {
+		finish, err := s.Wait(context.Background(), ticket)
+		if err == nil {
+			order <- name
+			finish()
+		}
+	}()
+	for s.Waiting() == waiting {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func Test_Scheduler_000(t *testing.T) {
+	assert := assert.New(t)
+	s := NewScheduler(1, 30*time.Second, 0)
+	assert.Equal(PriorityAdmin, s.Priority(true, time.Minute))
+	assert.Equal(PriorityShort, s.Priority(false, 10*time.Second))
+	assert.Equal(PriorityNormal, s.Priority(false, time.Minute))
+	assert.Equal(PriorityNormal, s.Priority(false, 0))
+
+	// The first job runs straight away
+	hold, err := s.Wait(context.Background(), &Ticket{Chat: 1, User: 1, Priority: PriorityNormal, Length: time.Minute})
+	assert.NoError(err)
+	assert.Equal(1, s.Running())
+
+	// One chat sends several files before the others send theirs
+	order := make(chan string, 6)
+	enqueue(t, s, &Ticket{Chat: 1, User: 1, Priority: PriorityNormal, Length: time.Minute}, "a1", order)
+	enqueue(t, s, &Ticket{Chat: 1, User: 1, Priority: PriorityNormal, Length: time.Minute}, "a2", order)
+	enqueue(t, s, &Ticket{Chat: 1, User: 1, Priority: PriorityNormal, Length: time.Minute}, "a3", order)
+	enqueue(t, s, &Ticket{Chat: 2, User: 2, Priority: PriorityNormal, Length: time.Minute}, "b1", order)
+	enqueue(t, s, &Ticket{Chat: 3, User: 3, Priority: PriorityShort, Length: 10 * time.Second}, "c1", order)
+	enqueue(t, s, &Ticket{Chat: 4, User: 4, Priority: PriorityAdmin, Length: time.Minute}, "d1", order)
+	assert.Equal(6, s.Waiting())
+
+	// Positions are estimated from the real time factor
+	positions := s.Positions(1, 0.5)
+	if assert.Len(positions, 4) {
+		assert.Equal(0, positions[0].Position)
+		assert.InDelta(30*time.Second, positions[0].ETA, float64(time.Second))
+		assert.Equal(4, positions[1].Position)
+		assert.InDelta(125*time.Second, positions[1].ETA, float64(time.Second))
+		assert.Equal(6, positions[3].Position)
+		assert.InDelta(185*time.Second, positions[3].ETA, float64(time.Second))
+	}
+	assert.Zero(s.Positions(1, 0)[1].ETA)
+	assert.Empty(s.Positions(5, 0.5))
+
+	// Admins go first, then short media, then chats take turns
+	hold()
+	var result []string
+	for range [6]int{} {
+		result = append(result, <-order)
+	}
+	assert.Equal([]string{"d1", "c1", "b1", "a1", "a2", "a3"}, result)
+	assert.Equal(0, s.Running())
+}
+
+func Test_Scheduler_001(t *testing.T) {
+	assert := assert.New(t)
+	s := NewScheduler(1, 0, time.Minute)
+
+	// A job which has waited long enough catches up with an admin job
+	now := time.Now()
+	s.waiting = []*Ticket{
+		{Chat: 2, Priority: PriorityAdmin, queued: now},
+		{Chat: 1, Priority: PriorityNormal, queued: now.Add(-3 * time.Minute)},
+		{Chat: 3, Priority: PriorityNormal, queued: now.Add(-time.Minute)},
+	}
+	assert.Equal(1, s.next(s.waiting, s.served, now))
+	order := s.order(now)
+	assert.Equal([]int64{1, 2, 3}, []int64{order[0].Chat, order[1].Chat, order[2].Chat})
+	s.waiting = nil
+
+	// A job which is cancelled while waiting gives up its place
+	hold, err := s.Wait(context.Background(), &Ticket{Chat: 1})
+	assert.NoError(err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = s.Wait(ctx, &Ticket{Chat: 2})
+	assert.ErrorIs(err, context.DeadlineExceeded)
+	assert.Equal(0, s.Waiting())
+	hold()
+	assert.Equal(0, s.Running())
+
+	// Chats without jobs are forgotten
+	assert.Empty(s.served)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"sync"
+
+	// Packages
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	bucketSettings = []byte("settings")
+)
+
+// ChatSettings are the options chosen in a chat
+type ChatSettings struct {
+	// Model used for jobs from the chat, or empty for the default
+	Model string `json:"model,omitempty"`
+
+	// Language transcripts from the chat are translated to, or empty
+	Translate string `json:"translate,omitempty"`
+
+	// Output format of transcripts in the chat, or empty for the default
+	Format string `json:"format,omitempty"`
+
+	// Group mode of the chat, or empty for the default
+	Group string `json:"group,omitempty"`
+
+	// Transcripts are sent without a notification
+	Silent bool `json:"silent,omitempty"`
+
+	// Segments of transcripts are labelled with their speaker
+	Speakers bool `json:"speakers,omitempty"`
+
+	// Each channel of stereo audio is transcribed separately
+	Channels bool `json:"channels,omitempty"`
+
+	// Comma-separated filters applied to audio from the chat, FilterNone
+	// for no filters, or empty for the default
+	Filters string `json:"filters,omitempty"`
+}
+
+// Settings holds the settings of every chat, and saves them in a bolt
+// database when there is one
+type Settings struct {
+	sync.RWMutex
+	chats map[int64]ChatSettings
+	db    *bolt.DB
+}
+
+// NewSettings returns settings which are only kept in memory
+func NewSettings() *Settings {
+	return &Settings{chats: make(map[int64]ChatSettings)}
+}
+
+// OpenSettings returns the settings stored in a bolt database, which are
+// saved to it whenever they are updated. The database is closed by its owner
+func OpenSettings(db *bolt.DB) (*Settings, error) {
+	s := NewSettings()
+	if err := db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(bucketSettings)
+		if err != nil {
+			return err
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			var settings ChatSettings
+			if err := json.Unmarshal(v, &settings); err != nil {
+				return err
+			}
+			s.chats[int64(btoi(k))] = settings
+			return nil
+		})
+	}); err != nil {
+		return nil, err
+	}
+	s.db = db
+	return s, nil
+}
+
+// Get returns the settings for a chat
+func (s *Settings) Get(chat int64) ChatSettings {
+	s.RLock()
+	defer s.RUnlock()
+	return s.chats[chat]
+}
+
+// Update changes the settings for a chat, and saves them. The change is kept
+// in memory when it cannot be saved
+func (s *Settings) Update(chat int64, fn func(*ChatSettings)) ChatSettings {
+	s.Lock()
+	defer s.Unlock()
+	settings := s.chats[chat]
+	fn(&settings)
+	s.chats[chat] = settings
+	if err := s.save(chat, settings); err != nil {
+		slog.Error("saving settings", "chat", chat, "error", err)
+	}
+	return settings
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// PRIVATE METHODS
+
+// save stores the settings of a chat in the database, if any. Chats with
+// the default settings are removed
+func (s *Settings) save(chat int64, settings ChatSettings) error {
+	if s.db == nil {
+		return nil
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketSettings)
+		if settings == (ChatSettings{}) {
+			return bucket.Delete(itob(uint64(chat)))
+		}
+		data, err := json.Marshal(settings)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(itob(uint64(chat)), data)
+	})
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	// Packages
+	assert "github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+)
+
+func Test_Settings_000(t *testing.T) {
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "settings.db")
+	open := func() (*bolt.DB, *Settings) {
+		db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+		if !assert.NoError(err) {
+			t.FailNow()
+		}
+		settings, err := OpenSettings(db)
+		if !assert.NoError(err) {
+			t.FailNow()
+		}
+		return db, settings
+	}
+
+	// Settings are saved when they are updated
+	db, settings := open()
+	settings.Update(-100, func(s *ChatSettings) {
+		s.Group, s.Silent, s.Filters = GroupMention, true, FilterNone
+	})
+	settings.Update(42, func(s *ChatSettings) { s.Model = "ggml-base" })
+	settings.Update(7, func(s *ChatSettings) { s.Format = FormatSRT })
+	settings.Update(7, func(s *ChatSettings) { s.Format = "" })
+	assert.NoError(db.Close())
+
+	// And loaded when the database is opened again
+	db, settings = open()
+	defer db.Close()
+	assert.Equal(ChatSettings{Group: GroupMention, Silent: true, Filters: FilterNone}, settings.Get(-100))
+	assert.Equal(ChatSettings{Model: "ggml-base"}, settings.Get(42))
+	assert.Equal(ChatSettings{}, settings.Get(7))
+	assert.NoError(db.View(func(tx *bolt.Tx) error {
+		assert.Equal(2, tx.Bucket(bucketSettings).Stats().KeyN)
+		return nil
+	}))
+
+	// Settings without a database are kept in memory
+	settings = NewSettings()
+	settings.Update(42, func(s *ChatSettings) { s.Speakers = true })
+	assert.True(settings.Get(42).Speakers)
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	// Packages
+	whisper "github.com/ggerganov/whisper.cpp/bindings/go/pkg/whisper"
+)
+
+// Diarizer tells the speakers of a transcript apart. Implementations can
+// cluster voice embeddings, or simpler features of the audio
+type Diarizer interface {
+	// Speakers returns the speaker of each segment, numbered from 1 in the
+	// order they first speak. The samples are the 16kHz mono audio the
+	// segments were transcribed from
+	Speakers(samples []float32, segments []whisper.Segment) []int
+}
+
+// TurnDiarizer starts a new speaker after every segment which whisper marks
+// as a speaker turn. Models trained with tinydiarize mark the turns, but
+// cannot tell when a speaker returns, so each turn is a new speaker
+type TurnDiarizer struct{}
+
+// VoiceDiarizer clusters segments by the pitch and loudness of the voice.
+// It is a rough guess which works best for a few speakers with different
+// voices, for models which do not detect speaker turns
+type VoiceDiarizer struct {
+	// The most speakers to tell apart
+	MaxSpeakers int
+
+	// A segment further than this from every speaker so far is a new
+	// speaker. A semitone of pitch is a distance of 0.25, and a decibel of
+	// loudness 0.1
+	Distance float64
+
+	// Segments shorter than this are too short to measure, and are given to
+	// the speaker before them
+	MinLength time.Duration
+}
+
+const (
+	// Pitch range of voices in Hz
+	minPitch = 60
+	maxPitch = 400
+
+	// Length of the frames the pitch is measured on, and the step between
+	// them. Frames are downsampled to pitchRate first
+	pitchFrame = 40 * time.Millisecond
+	pitchStep  = 100 * time.Millisecond
+	pitchRate  = 8000
+
+	// A frame with a normalised autocorrelation below this is not voiced
+	voicing = 0.5
+)
+
+var (
+	// The diarizer used for models which do not detect speaker turns
+	defaultDiarizer Diarizer = VoiceDiarizer{MaxSpeakers: 4, Distance: 1.5, MinLength: 500 * time.Millisecond}
+)
+
+// Speakers returns a new speaker after each speaker turn
+func (TurnDiarizer) Speakers(samples []float32, segments []whisper.Segment) []int {
+	result := make([]int, len(segments))
+	speaker := 1
+	for i, segment := range segments {
+		result[i] = speaker
+		if segment.SpeakerTurnNext {
+			speaker++
+		}
+	}
+	return result
+}
+
+// Speakers gives each segment to the nearest speaker so far, or to a new
+// speaker when none is near enough
+func (v VoiceDiarizer) Speakers(samples []float32, segments []whisper.Segment) []int {
+	result := make([]int, len(segments))
+	var centres [][2]float64
+	var counts []int
+	previous := 0
+	for i, segment := range segments {
+		features, ok := v.features(samples, segment)
+		if !ok {
+			result[i] = previous
+			continue
+		}
+		nearest, distance := -1, math.Inf(1)
+		for j, centre := range centres {
+			if d := math.Hypot(features[0]-centre[0], features[1]-centre[1]); d < distance {
+				nearest, distance = j, d
+			}
+		}
+		if nearest < 0 || (distance > v.Distance && len(centres) < v.MaxSpeakers) {
+			centres = append(centres, features)
+			counts = append(counts, 1)
+			nearest = len(centres) - 1
+		} else {
+			// Move the centre towards the segment, so it follows the speaker
+			counts[nearest]++
+			n := float64(counts[nearest])
+			centres[nearest][0] += (features[0] - centres[nearest][0]) / n
+			centres[nearest][1] += (features[1] - centres[nearest][1]) / n
+		}
+		result[i] = nearest + 1
+		previous = result[i]
+	}
+
+	// Segments before the first one measured belong to its speaker
+	first := 1
+	for _, speaker := range result {
+		if speaker != 0 {
+			first = speaker
+			break
+		}
+	}
+	for i := range result {
+		if result[i] != 0 {
+			break
+		}
+		result[i] = first
+	}
+	return result
+}
+
+// speakerLabel returns the label shown before the text of a speaker
+func speakerLabel(speaker int) string {
+	return "Speaker " + strconv.Itoa(speaker)
+}
+
+// tinydiarize returns true when a model was trained to detect speaker turns.
+// These models are named with a tdrz suffix
+func tinydiarize(model string) bool {
+	return strings.Contains(model, "tdrz")
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// PRIVATE METHODS
+
+// features returns the median pitch of the voiced frames of a segment, and
+// their loudness, scaled so that the distance between them is comparable.
+// It returns false when the segment is too short or not voiced
+func (v VoiceDiarizer) features(samples []float32, segment whisper.Segment) ([2]float64, bool) {
+	if segment.End-segment.Start < v.MinLength {
+		return [2]float64{}, false
+	}
+	from, to := sampleIndex(segment.Start, len(samples)), sampleIndex(segment.End, len(samples))
+	frame, step := sampleIndex(pitchFrame, math.MaxInt), sampleIndex(pitchStep, math.MaxInt)
+	var pitches, levels []float64
+	for at := from; at+frame <= to; at += step {
+		if pitch, level, ok := framePitch(samples[at : at+frame]); ok {
+			pitches = append(pitches, pitch)
+			levels = append(levels, level)
+		}
+	}
+	if len(pitches) == 0 {
+		return [2]float64{}, false
+	}
+	semitones := 12 * math.Log2(median(pitches)/100)
+	decibels := 20 * math.Log10(median(levels))
+	return [2]float64{semitones / 4, decibels / 10}, true
+}
+
+// framePitch returns the pitch of a frame from the peak of its
+// autocorrelation, with its RMS level. It returns false when the frame is
+// not voiced
+func framePitch(frame []float32) (float64, float64, bool) {
+	// Downsample by averaging, which also filters out high frequencies
+	factor := whisper.SampleRate / pitchRate
+	x := make([]float64, len(frame)/factor)
+	var mean float64
+	for i := range x {
+		for _, sample := range frame[i*factor : (i+1)*factor] {
+			x[i] += float64(sample)
+		}
+		x[i] /= float64(factor)
+		mean += x[i]
+	}
+	mean /= float64(len(x))
+	var energy float64
+	for i := range x {
+		x[i] -= mean
+		energy += x[i] * x[i]
+	}
+	if energy == 0 {
+		return 0, 0, false
+	}
+	level := math.Sqrt(energy / float64(len(x)))
+
+	// The period is the first peak of the normalised autocorrelation which
+	// is nearly as high as the highest, so multiples of it are not chosen
+	minLag, maxLag := pitchRate/maxPitch, pitchRate/minPitch
+	if maxLag >= len(x) {
+		maxLag = len(x) - 1
+	}
+	r := make([]float64, maxLag+2)
+	best := 0.0
+	for lag := minLag; lag <= maxLag; lag++ {
+		var sum, e1, e2 float64
+		for i := 0; i+lag < len(x); i++ {
+			sum += x[i] * x[i+lag]
+			e1 += x[i] * x[i]
+			e2 += x[i+lag] * x[i+lag]
+		}
+		if e1 > 0 && e2 > 0 {
+			r[lag] = sum / math.Sqrt(e1*e2)
+		}
+		best = math.Max(best, r[lag])
+	}
+	if best < voicing {
+		return 0, level, false
+	}
+	for lag := minLag; lag <= maxLag; lag++ {
+		if r[lag] >= 0.9*best && r[lag] >= r[lag-1] && r[lag] >= r[lag+1] {
+			return float64(pitchRate) / float64(lag), level, true
+		}
+	}
+	return 0, level, false
+}
+
+// sampleIndex returns the index of the sample at a time, up to n
+func sampleIndex(d time.Duration, n int) int {
+	i := int(d * whisper.SampleRate / time.Second)
+	if i > n {
+		return n
+	}
+	return i
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	return sorted[len(sorted)/2]
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	// Packages
+	whisper "github.com/ggerganov/whisper.cpp/bindings/go/pkg/whisper"
+	fakewhisper "github.com/skrashevich/whisper.cpp-telegram/pkg/fake-whisper"
+	assert "github.com/stretchr/testify/assert"
+)
+
+// voice returns a sine wave at a pitch in Hz lasting d
+func voice(pitch, amplitude float64, d time.Duration) []float32 {
+	samples := make([]float32, d*whisper.SampleRate/time.Second)
+	for i := range samples {
+		samples[i] = float32(amplitude * math.Sin(2*math.Pi*pitch*float64(i)/whisper.SampleRate))
+	}
+	return samples
+}
+
+func Test_Speakers_000(t *testing.T) {
+	assert := assert.New(t)
+
+	segments := fakewhisper.Segments(time.Second, "one", "two", "three", "four")
+	segments[1].SpeakerTurnNext = true
+	segments[2].SpeakerTurnNext = true
+	assert.Equal([]int{1, 1, 2, 3}, TurnDiarizer{}.Speakers(nil, segments))
+	assert.Empty(TurnDiarizer{}.Speakers(nil, nil))
+
+	assert.True(tinydiarize("ggml-small.en-tdrz"))
+	assert.False(tinydiarize("ggml-small.en"))
+	assert.Equal("Speaker 2", speakerLabel(2))
+}
+
+func Test_Speakers_001(t *testing.T) {
+	assert := assert.New(t)
+	diarizer := defaultDiarizer
+
+	// Two voices taking turns, then a segment too short to measure
+	var samples []float32
+	for _, pitch := range []float64{110, 220, 110, 220, 220} {
+		samples = append(samples, voice(pitch, 0.3, 2*time.Second)...)
+	}
+	segments := fakewhisper.Segments(2*time.Second, "one", "two", "three", "four")
+	segments = append(segments, fakewhisper.Segment(4, 8*time.Second, 8200*time.Millisecond, "five"))
+	assert.Equal([]int{1, 2, 1, 2, 2}, diarizer.Speakers(samples, segments))
+
+	// Silence is given to the first speaker measured
+	samples = append(silence(2*time.Second), voice(150, 0.3, 2*time.Second)...)
+	assert.Equal([]int{1, 1}, diarizer.Speakers(samples, fakewhisper.Segments(2*time.Second, "one", "two")))
+
+	// The same voice is one speaker, and there are never more than the most
+	samples = voice(150, 0.3, 6*time.Second)
+	assert.Equal([]int{1, 1, 1}, diarizer.Speakers(samples, fakewhisper.Segments(2*time.Second, "one", "two", "three")))
+	samples = nil
+	for _, pitch := range []float64{80, 120, 180, 270, 390} {
+		samples = append(samples, voice(pitch, 0.3, 2*time.Second)...)
+	}
+	speakers := diarizer.Speakers(samples, fakewhisper.Segments(2*time.Second, "one", "two", "three", "four", "five"))
+	assert.Equal([]int{1, 2, 3, 4}, speakers[:4])
+	assert.Equal(4, speakers[4])
+}
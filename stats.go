@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	// Packages
+	whisper "github.com/ggerganov/whisper.cpp/bindings/go/pkg/whisper"
+)
+
+// Stats accumulates totals over the jobs handled since the bot started
+type Stats struct {
+	sync.Mutex
+	started time.Time
+	jobs    int
+	cached  int
+	failed  int
+	audio   time.Duration
+	elapsed time.Duration
+
+	// Sum of the whisper timings of each job, which are divided by the jobs
+	// for the mean time of one run of each stage
+	timings whisper.Timings
+}
+
+// NewStats returns empty stats starting now
+func NewStats() *Stats {
+	return &Stats{started: time.Now()}
+}
+
+// Done counts a job which produced a transcript in elapsed
+func (s *Stats) Done(transcript *Transcript, elapsed time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+	s.jobs++
+	if transcript.Cached {
+		s.cached++
+		return
+	}
+	s.audio += transcript.Duration
+	s.elapsed += elapsed
+	s.timings.Sample += transcript.Timings.Sample
+	s.timings.Encode += transcript.Timings.Encode
+	s.timings.Decode += transcript.Timings.Decode
+	s.timings.Batchd += transcript.Timings.Batchd
+	s.timings.Prompt += transcript.Timings.Prompt
+	s.timings.Total += transcript.Timings.Total
+}
+
+// Failed counts a job which failed
+func (s *Stats) Failed() {
+	s.Lock()
+	defer s.Unlock()
+	s.jobs++
+	s.failed++
+}
+
+// String returns a summary of the stats
+func (s *Stats) String() string {
+	s.Lock()
+	defer s.Unlock()
+	lines := []string{
+		fmt.Sprintf("Uptime: %v", time.Since(s.started).Round(time.Second)),
+		fmt.Sprintf("Jobs: %d (%d cached, %d failed)", s.jobs, s.cached, s.failed),
+	}
+	if transcribed := s.jobs - s.cached - s.failed; transcribed > 0 {
+		line := fmt.Sprintf("Audio: %s", clock(s.audio))
+		if s.audio > 0 {
+			line += fmt.Sprintf(", real time factor %.2f", s.realTimeFactor())
+		}
+		lines = append(lines, line)
+		if t := formatTimings(s.timings, transcribed); t != "" {
+			lines = append(lines, "Whisper per run: "+t)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// RealTimeFactor returns the time taken to transcribe each second of audio,
+// or zero before any audio has been transcribed
+func (s *Stats) RealTimeFactor() float64 {
+	s.Lock()
+	defer s.Unlock()
+	return s.realTimeFactor()
+}
+
+func (s *Stats) realTimeFactor() float64 {
+	if s.audio <= 0 {
+		return 0
+	}
+	return s.elapsed.Seconds() / s.audio.Seconds()
+}
+
+// formatTimings returns the non-zero stages of whisper timings divided by n,
+// to three significant figures. Each stage is the mean time of one run of it
+func formatTimings(t whisper.Timings, n int) string {
+	var parts []string
+	for _, stage := range []struct {
+		name string
+		d    time.Duration
+	}{
+		{"encode", t.Encode},
+		{"decode", t.Decode},
+		{"batchd", t.Batchd},
+		{"prompt", t.Prompt},
+		{"sample", t.Sample},
+	} {
+		if stage.d > 0 {
+			d := stage.d / time.Duration(n)
+			precision := time.Duration(1)
+			for d/precision >= 1000 {
+				precision *= 10
+			}
+			parts = append(parts, fmt.Sprintf("%s %v", stage.name, d.Round(precision)))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	// Packages
+	whisper "github.com/ggerganov/whisper.cpp/bindings/go/pkg/whisper"
+	"github.com/gorilla/websocket"
+)
+
+// Stream message types
+const (
+	StreamPartial = "partial"
+	StreamFinal   = "final"
+	StreamError   = "error"
+)
+
+// Stream input formats
+const (
+	StreamPCM  = "pcm"
+	StreamOpus = "opus"
+)
+
+// streamMessage is sent to streaming clients. Partial messages hold the
+// segments decoded so far in the current window, which replace those of
+// the previous partial message. Final messages hold the segments of a
+// window which will not change again
+type streamMessage struct {
+	Type     string        `json:"type"`
+	Text     string        `json:"text,omitempty"`
+	Segments []jsonSegment `json:"segments,omitempty"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// Streamer transcribes a continuous stream of audio with a sliding window.
+// The window is decoded each time step of new audio has arrived, and is
+// finalized when the speech in it ends or it reaches the maximum length
+type Streamer struct {
+	wp      *WhisperProcessor
+	context whisper.Context
+	vad     VAD
+	step    time.Duration
+	length  time.Duration
+	send    func(streamMessage) error
+
+	// Time in the stream of the start of the window
+	offset time.Duration
+	window []float32
+	fresh  int
+}
+
+// NewStreamer returns a streamer which decodes with a context from
+// wp.NewContext and sends messages with send
+func NewStreamer(wp *WhisperProcessor, context whisper.Context, send func(streamMessage) error) *Streamer {
+	return &Streamer{
+		wp:      wp,
+		context: context,
+		vad:     defaultVAD,
+		step:    2 * time.Second,
+		length:  15 * time.Second,
+		send:    send,
+	}
+}
+
+// Run transcribes the samples received until in is closed, when the last
+// window is finalized
+func (s *Streamer) Run(ctx context.Context, in <-chan []float32) error {
+	step := int(s.step * whisper.SampleRate / time.Second)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case samples, ok := <-in:
+			if !ok {
+				return s.decode(ctx, true)
+			}
+			s.add(samples)
+		}
+
+		// Catch up with audio which arrived while decoding
+		for more := true; more; {
+			select {
+			case samples, ok := <-in:
+				if !ok {
+					return s.decode(ctx, true)
+				}
+				s.add(samples)
+			default:
+				more = false
+			}
+		}
+		if s.fresh >= step {
+			if err := s.decode(ctx, false); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// PRIVATE METHODS
+
+func (s *Streamer) add(samples []float32) {
+	s.window = append(s.window, samples...)
+	s.fresh += len(samples)
+}
+
+// decode transcribes the window, sending partial segments as they are
+// decoded. The window is finalized and a new one started when last is set,
+// the speech has ended or the window is full
+func (s *Streamer) decode(ctx context.Context, last bool) error {
+	s.fresh = 0
+	if s.vad.Silent(s.window) {
+		s.skip()
+		return nil
+	}
+	length := time.Duration(len(s.window)) * time.Second / whisper.SampleRate
+	final := last || length >= s.length || s.vad.Ended(s.window)
+
+	var segments []whisper.Segment
+	start := time.Now()
+	err := s.wp.ProcessContext(ctx, s.context, s.window, func(segment whisper.Segment) {
+		segments = append(segments, segment)
+		if !final {
+			if err := s.send(s.message(StreamPartial, segments)); err != nil {
+				loggerFrom(ctx).Debug("sending partial segments", "error", err)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	loggerFrom(ctx).Debug("decoded window", "offset", s.offset, "length", length, "final", final, "segments", len(segments), "elapsed", time.Since(start))
+	if !final {
+		return nil
+	}
+	message := s.message(StreamFinal, segments)
+	s.skip()
+	if len(segments) == 0 {
+		return nil
+	}
+	return s.send(message)
+}
+
+// skip starts a new window after the current one
+func (s *Streamer) skip() {
+	s.offset += time.Duration(len(s.window)) * time.Second / whisper.SampleRate
+	s.window = s.window[:0]
+}
+
+func (s *Streamer) message(kind string, segments []whisper.Segment) streamMessage {
+	message := streamMessage{Type: kind, Segments: make([]jsonSegment, 0, len(segments))}
+	texts := make([]string, 0, len(segments))
+	for _, segment := range segments {
+		message.Segments = append(message.Segments, newJSONSegment(segment, s.offset))
+		texts = append(texts, segment.Text)
+	}
+	message.Text = joinText(texts)
+	return message
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// WEBSOCKET
+
+var upgrader = websocket.Upgrader{}
+
+// handleStream transcribes audio streamed over a WebSocket. Binary messages
+// hold the audio, which is 16kHz mono 16-bit little endian PCM by default,
+// or any stream ffmpeg can decode such as ogg or webm opus with
+// input=opus. The language and model query parameters are as for
+// /transcribe. Partial and final segments are sent back as JSON text
+// messages. The text message "stop" or closing the socket ends the stream
+func (api *API) handleStream(w http.ResponseWriter, r *http.Request) {
+	job := newJobID()
+	log := slog.Default().With("job", job, "remote", r.RemoteAddr)
+	w.Header().Set("X-Job-ID", job)
+
+	params, err := api.requestParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	model, err := api.requestModel(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	params.offset, params.duration = 0, 0
+	input := r.URL.Query().Get("input")
+	if input == "" {
+		input = StreamPCM
+	} else if input != StreamPCM && input != StreamOpus {
+		http.Error(w, "input must be pcm or opus", http.StatusBadRequest)
+		return
+	}
+
+	// Streams are cancelled when shutting down, like jobs
+	lifetime, done, err := api.lifecycle.Begin(nil)
+	if err != nil {
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer done()
+	ctx, cancel := context.WithCancel(lifetime)
+	defer cancel()
+
+	if model == "" {
+		model = api.router.Model(Route{Queue: api.models.Pending() + api.scheduler.Waiting()})
+	}
+	wp, release, err := api.models.Acquire(model)
+	if err != nil {
+		log.Error("acquiring model", "model", model, "error", err)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer release()
+	streamContext, err := wp.NewContext(params)
+	if err != nil {
+		log.Error("creating context", "model", wp.name, "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, w.Header())
+	if err != nil {
+		log.Info("upgrading to websocket", "error", err)
+		return
+	}
+	defer conn.Close()
+	log = log.With("model", wp.name, "input", input)
+	ctx = withLogger(ctx, log)
+	log.Info("stream started")
+
+	// Messages are only written by the streamer until it returns
+	send := func(message streamMessage) error {
+		data, err := json.Marshal(message)
+		if err != nil {
+			return err
+		}
+		return conn.WriteMessage(websocket.TextMessage, data)
+	}
+	samples := make(chan []float32, 64)
+	streamer := NewStreamer(wp, streamContext, send)
+	result := make(chan error, 1)
+	go func() {
+		err := streamer.Run(ctx, samples)
+		if err != nil {
+			cancel()
+		}
+		result <- err
+	}()
+
+	// Reading stops when the stream fails or is cancelled
+	defer context.AfterFunc(ctx, func() {
+		conn.SetReadDeadline(time.Now())
+	})()
+
+	// Encoded audio goes through ffmpeg
+	var decoder pcmDecoder
+	var stdin io.WriteCloser
+	if input == StreamOpus {
+		in, out, wait, err := startDecoder(ctx)
+		if err != nil {
+			log.Error("starting decoder", "error", err)
+			send(streamMessage{Type: StreamError, Error: err.Error()})
+			return
+		}
+		stdin = in
+		go func() {
+			defer close(samples)
+			buf := make([]byte, 32<<10)
+			for {
+				n, err := out.Read(buf)
+				if n > 0 {
+					select {
+					case samples <- decoder.Decode(buf[:n]):
+					case <-ctx.Done():
+					}
+				}
+				if err != nil {
+					break
+				}
+			}
+			if err := wait(); err != nil && ctx.Err() == nil {
+				log.Warn("decoding stream", "error", err)
+			}
+		}()
+	}
+
+	var audio int64
+	for ctx.Err() == nil {
+		kind, data, err := conn.ReadMessage()
+		if err != nil {
+			var closeErr *websocket.CloseError
+			if !errors.As(err, &closeErr) {
+				log.Debug("reading stream", "error", err)
+			}
+			break
+		}
+		if kind == websocket.TextMessage && strings.TrimSpace(string(data)) == "stop" {
+			break
+		} else if kind != websocket.BinaryMessage {
+			continue
+		}
+		audio += int64(len(data))
+		if stdin != nil {
+			if _, err := stdin.Write(data); err != nil {
+				log.Warn("decoding stream", "error", err)
+				break
+			}
+		} else {
+			select {
+			case samples <- decoder.Decode(data):
+			case <-ctx.Done():
+			}
+		}
+	}
+	if stdin != nil {
+		stdin.Close()
+	} else {
+		close(samples)
+	}
+	api.metrics.Downloaded("stream", audio)
+
+	if err := <-result; err != nil {
+		log.Error("streaming", "error", err)
+		send(streamMessage{Type: StreamError, Error: err.Error()})
+	}
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	log.Info("stream finished", "bytes", audio, "length", streamer.offset)
+}
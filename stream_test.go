@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	// Packages
+	"github.com/gorilla/websocket"
+	assert "github.com/stretchr/testify/assert"
+)
+
+// pcm returns samples as 16-bit little endian PCM
+func pcm(samples []float32) []byte {
+	var buf bytes.Buffer
+	for _, sample := range samples {
+		binary.Write(&buf, binary.LittleEndian, int16(sample*32767))
+	}
+	return buf.Bytes()
+}
+
+func Test_Stream_000(t *testing.T) {
+	assert := assert.New(t)
+	models, _ := newTestModels(t, "one", "two", "three")
+	wp, release, err := models.Acquire("")
+	assert.NoError(err)
+	defer release()
+	streamContext, err := wp.NewContext(WhisperParams{language: "auto"})
+	assert.NoError(err)
+	var messages []streamMessage
+	streamer := NewStreamer(wp, streamContext, func(message streamMessage) error {
+		messages = append(messages, message)
+		return nil
+	})
+	ctx := context.Background()
+
+	// Silence is skipped without decoding
+	streamer.add(silence(2 * time.Second))
+	assert.NoError(streamer.decode(ctx, false))
+	assert.Empty(messages)
+	assert.Equal(2*time.Second, streamer.offset)
+
+	// Partial segments are sent while speech goes on
+	streamer.add(tone(3 * time.Second))
+	assert.NoError(streamer.decode(ctx, false))
+	if assert.Len(messages, 2) {
+		assert.Equal(StreamPartial, messages[0].Type)
+		assert.Equal("one", messages[0].Text)
+		assert.Equal("one two", messages[1].Text)
+		assert.Equal(2.0, messages[1].Segments[0].Start)
+		assert.Equal(6.0, messages[1].Segments[1].End)
+	}
+
+	// The window is finalized when the speech ends
+	streamer.add(silence(1500 * time.Millisecond))
+	assert.NoError(streamer.decode(ctx, false))
+	if assert.Len(messages, 3) {
+		assert.Equal(StreamFinal, messages[2].Type)
+		assert.Equal("one two three", messages[2].Text)
+		assert.Equal(6.0, messages[2].Segments[2].Start)
+	}
+	assert.Equal(6500*time.Millisecond, streamer.offset)
+	assert.Empty(streamer.window)
+}
+
+func Test_Stream_001(t *testing.T) {
+	assert := assert.New(t)
+	api, _ := newTestAPI(t, "one", "two", "three")
+	server := httptest.NewServer(api)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/stream"
+
+	// Unknown inputs and models are refused
+	_, resp, err := websocket.DefaultDialer.Dial(url+"?input=mp3", nil)
+	assert.Error(err)
+	if assert.NotNil(resp) {
+		assert.Equal(400, resp.StatusCode)
+	}
+	_, resp, err = websocket.DefaultDialer.Dial(url+"?model=huge", nil)
+	assert.Error(err)
+	if assert.NotNil(resp) {
+		assert.Equal(400, resp.StatusCode)
+	}
+
+	// PCM is sent in chunks, then the stream is stopped
+	conn, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if !assert.NoError(err) {
+		return
+	}
+	defer conn.Close()
+	assert.NotEmpty(resp.Header.Get("X-Job-ID"))
+	audio := append(tone(3*time.Second), silence(time.Second)...)
+	for chunk := 0; chunk < len(audio); chunk += 8000 {
+		assert.NoError(conn.WriteMessage(websocket.BinaryMessage, pcm(audio[chunk:chunk+8000])))
+	}
+	assert.NoError(conn.WriteMessage(websocket.TextMessage, []byte("stop")))
+
+	// Partial segments come first, and the stream ends with the final ones
+	var messages []streamMessage
+	for {
+		var message streamMessage
+		if err := conn.ReadJSON(&message); err != nil {
+			assert.True(websocket.IsCloseError(err, websocket.CloseNormalClosure), err)
+			break
+		}
+		messages = append(messages, message)
+	}
+	if assert.NotEmpty(messages) {
+		last := messages[len(messages)-1]
+		assert.Equal(StreamFinal, last.Type)
+		assert.Equal("one two", last.Text)
+		for _, message := range messages[:len(messages)-1] {
+			assert.Equal(StreamPartial, message.Type)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	// Package imports
+	whisper "github.com/ggerganov/whisper.cpp/bindings/go/pkg/whisper"
+)
+
+// Transcript is the result of transcribing audio
+type Transcript struct {
+	// Name of the model which produced the transcript
+	Model string
+
+	// Spoken language, or "auto" when it was detected
+	Language string
+
+	// Length of the audio which was transcribed
+	Duration time.Duration
+
+	// Segments in order
+	Segments []whisper.Segment
+
+	// Speaker of each segment numbered from 1, or nil when speakers were
+	// not detected
+	Speakers []int `json:",omitempty"`
+
+	// Channel of each segment numbered from 1, or nil when the channels of
+	// the audio were not transcribed separately
+	Channels []int `json:",omitempty"`
+
+	// Time spent in each stage of whisper
+	Timings whisper.Timings
+
+	// Keys of the cache entries holding the transcript, so they can be
+	// removed when its user forgets it
+	CacheKeys []string `json:",omitempty"`
+
+	// True when the transcript came from the cache
+	Cached bool `json:"-"`
+}
+
+// Text returns the text of all segments
+func (t *Transcript) Text() string {
+	texts := make([]string, 0, len(t.Segments))
+	for _, segment := range t.Segments {
+		texts = append(texts, segment.Text)
+	}
+	return joinText(texts)
+}
+
+// Speaker returns the speaker of a segment, or zero when speakers were not
+// detected
+func (t *Transcript) Speaker(segment int) int {
+	if segment < 0 || segment >= len(t.Speakers) {
+		return 0
+	}
+	return t.Speakers[segment]
+}
+
+// Channel returns the channel of a segment, or zero when the channels were
+// not transcribed separately
+func (t *Transcript) Channel(segment int) int {
+	if segment < 0 || segment >= len(t.Channels) {
+		return 0
+	}
+	return t.Channels[segment]
+}
+
+// Labelled returns true when segments are labelled with their speaker or
+// channel
+func (t *Transcript) Labelled() bool {
+	return len(t.Speakers) > 0 || len(t.Channels) > 0
+}
+
+// Label returns the label shown before the text of a segment, with its
+// channel and speaker when they are known
+func (t *Transcript) Label(segment int) string {
+	var labels []string
+	if channel := t.Channel(segment); channel > 0 {
+		labels = append(labels, "Channel "+strconv.Itoa(channel))
+	}
+	if speaker := t.Speaker(segment); speaker > 0 {
+		labels = append(labels, speakerLabel(speaker))
+	}
+	return strings.Join(labels, ", ")
+}
+
+// Confidence summarises the probabilities of the text tokens in a segment or
+// transcript. Both values are zero when there are no text tokens
+type Confidence struct {
+	Mean float32 `json:"mean"`
+	Min  float32 `json:"min"`
+}
+
+// Confidence returns the mean and minimum token probability over all segments
+func (t *Transcript) Confidence() Confidence {
+	var tokens []whisper.Token
+	for _, segment := range t.Segments {
+		tokens = append(tokens, segment.Tokens...)
+	}
+	return tokenConfidence(tokens)
+}
+
+// segmentConfidence returns the mean and minimum token probability of a segment
+func segmentConfidence(segment whisper.Segment) Confidence {
+	return tokenConfidence(segment.Tokens)
+}
+
+func tokenConfidence(tokens []whisper.Token) Confidence {
+	var result Confidence
+	var sum float32
+	var n int
+	for _, token := range tokens {
+		if token.IsSpecial() || strings.TrimSpace(token.Text) == "" {
+			continue
+		}
+		if n == 0 || token.P < result.Min {
+			result.Min = token.P
+		}
+		sum += token.P
+		n++
+	}
+	if n > 0 {
+		result.Mean = sum / float32(n)
+	}
+	return result
+}
+
+// highlight returns the HTML-escaped text of a segment, with every word
+// below the probability threshold wrapped in the marker. The marker is a
+// format string such as "<i>%s</i>", and is not escaped so it may contain
+// HTML tags. Words are marked where they are found in the text, so the
+// spacing of the text is kept for languages which do not separate words
+// with spaces. A threshold of zero disables highlighting
+func highlight(segment whisper.Segment, threshold float32, marker string) string {
+	if threshold <= 0 || marker == "" {
+		return html.EscapeString(segment.Text)
+	}
+	var result strings.Builder
+	text := segment.Text
+	for _, word := range segment.Words() {
+		i := strings.Index(text, word.Text)
+		if i < 0 {
+			continue
+		}
+		result.WriteString(html.EscapeString(text[:i]))
+		if word.P < threshold {
+			result.WriteString(fmt.Sprintf(marker, html.EscapeString(word.Text)))
+		} else {
+			result.WriteString(html.EscapeString(word.Text))
+		}
+		text = text[i+len(word.Text):]
+	}
+	result.WriteString(html.EscapeString(text))
+	return result.String()
+}
+
+// mergeChannels returns the transcripts of each channel of audio as one,
+// with the segments in order of their start and labelled with their channel
+func mergeChannels(transcripts []*Transcript) *Transcript {
+	type channelSegment struct {
+		whisper.Segment
+		channel, speaker int
+	}
+	var segments []channelSegment
+	result := *transcripts[0]
+	result.Segments, result.Speakers, result.Timings = nil, nil, whisper.Timings{}
+	for i, transcript := range transcripts {
+		for j, segment := range transcript.Segments {
+			segments = append(segments, channelSegment{segment, i + 1, transcript.Speaker(j)})
+		}
+		if transcript.Duration > result.Duration {
+			result.Duration = transcript.Duration
+		}
+		// The stages are the mean time of a run, so they are averaged over
+		// the channels, while the total time adds up
+		n := time.Duration(len(transcripts))
+		result.Timings.Sample += transcript.Timings.Sample / n
+		result.Timings.Encode += transcript.Timings.Encode / n
+		result.Timings.Decode += transcript.Timings.Decode / n
+		result.Timings.Batchd += transcript.Timings.Batchd / n
+		result.Timings.Prompt += transcript.Timings.Prompt / n
+		result.Timings.Total += transcript.Timings.Total
+	}
+	sort.SliceStable(segments, func(i, j int) bool {
+		return segments[i].Start < segments[j].Start
+	})
+	result.Channels = make([]int, 0, len(segments))
+	for i, segment := range segments {
+		segment.Num = i
+		result.Segments = append(result.Segments, segment.Segment)
+		result.Channels = append(result.Channels, segment.channel)
+		if len(transcripts[0].Speakers) > 0 {
+			result.Speakers = append(result.Speakers, segment.speaker)
+		}
+	}
+	return &result
+}
+
+// joinText joins the text of segments with single spaces. Whisper starts
+// most segments with a space, but not all of them
+func joinText(texts []string) string {
+	result := make([]string, 0, len(texts))
+	for _, text := range texts {
+		if text = strings.TrimSpace(text); text != "" {
+			result = append(result, text)
+		}
+	}
+	return strings.Join(result, " ")
+}
+
+// segmentWords returns the words of a segment. When the model did not
+// provide token timestamps, the words are spread evenly over the segment
+func segmentWords(segment whisper.Segment) []whisper.Word {
+	words := segment.Words()
+	timed := false
+	for _, word := range words {
+		if word.End > 0 {
+			timed = true
+			break
+		}
+	}
+	if !timed && len(words) > 0 {
+		step := (segment.End - segment.Start) / time.Duration(len(words))
+		for i := range words {
+			words[i].Start = segment.Start + time.Duration(i)*step
+			words[i].End = words[i].Start + step
+		}
+	}
+	for i := range words {
+		words[i].Start = clamp(words[i].Start, segment.Start, segment.End)
+		words[i].End = clamp(words[i].End, words[i].Start, segment.End)
+	}
+	return words
+}
+
+func clamp(v, lo, hi time.Duration) time.Duration {
+	if v < lo {
+		return lo
+	}
+	if v > hi && hi >= lo {
+		return hi
+	}
+	return v
+}
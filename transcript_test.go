@@ -0,0 +1,104 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	// Packages
+	whisper "github.com/ggerganov/whisper.cpp/bindings/go/pkg/whisper"
+	fakewhisper "github.com/skrashevich/whisper.cpp-telegram/pkg/fake-whisper"
+	assert "github.com/stretchr/testify/assert"
+)
+
+func Test_Transcript_000(t *testing.T) {
+	assert := assert.New(t)
+
+	segment := fakewhisper.Segment(0, 0, 2*time.Second, "And so my")
+	segment.Tokens[2].P = 0.3
+	transcript := &Transcript{Segments: []whisper.Segment{
+		segment,
+		fakewhisper.Segment(1, 2*time.Second, 4*time.Second, "fellow Americans"),
+	}}
+
+	// Special tokens are ignored
+	confidence := segmentConfidence(segment)
+	assert.InDelta(0.7, confidence.Mean, 0.0001)
+	assert.InDelta(0.3, confidence.Min, 0.0001)
+
+	confidence = transcript.Confidence()
+	assert.InDelta((0.9*5+0.3)/6, confidence.Mean, 0.0001)
+	assert.InDelta(0.3, confidence.Min, 0.0001)
+
+	assert.Equal(Confidence{}, (&Transcript{}).Confidence())
+}
+
+func Test_Transcript_001(t *testing.T) {
+	assert := assert.New(t)
+
+	segment := fakewhisper.Segment(0, 0, 2*time.Second, "Tom & Jerry")
+	segment.Tokens[1].P = 0.3
+
+	assert.Equal("<i>Tom</i> &amp; Jerry", highlight(segment, 0.5, "<i>%s</i>"))
+	assert.Equal("Tom(?) &amp; Jerry", highlight(segment, 0.5, "%s(?)"))
+
+	// Words are marked in the text, keeping its spacing
+	segment = whisper.Segment{Text: " 今日は 晴れ。  Good", Tokens: []whisper.Token{
+		{Id: 100, Text: "今日は", P: 0.9},
+		{Id: 101, Text: " 晴れ", P: 0.2},
+		{Id: 102, Text: "。", P: 0.2},
+		{Id: 103, Text: "  Good", P: 0.9},
+	}}
+	assert.Equal(" 今日は <i>晴れ。</i>  Good", highlight(segment, 0.5, "<i>%s</i>"))
+	segment = fakewhisper.Segment(0, 0, 2*time.Second, "Banana a")
+	segment.Tokens[2].P = 0.3
+	assert.Equal("Banana <i>a</i>", highlight(segment, 0.5, "<i>%s</i>"))
+	segment = fakewhisper.Segment(0, 0, 2*time.Second, "Tom & Jerry")
+
+	// Highlighting disabled or nothing to highlight
+	assert.Equal("Tom &amp; Jerry", highlight(segment, 0, "<i>%s</i>"))
+	assert.Equal("Tom &amp; Jerry", highlight(segment, 0.2, "<i>%s</i>"))
+}
+
+func Test_Transcript_002(t *testing.T) {
+	assert := assert.New(t)
+
+	// Segments of the channels are interleaved by their start
+	left := &Transcript{
+		Model:    "ggml-tiny",
+		Language: "en",
+		Duration: 6 * time.Second,
+		Segments: []whisper.Segment{
+			fakewhisper.Segment(0, 0, time.Second, "Hello"),
+			fakewhisper.Segment(1, 3*time.Second, 4*time.Second, "Fine thanks"),
+		},
+		Timings: whisper.Timings{Encode: time.Second, Total: 3 * time.Second},
+	}
+	right := &Transcript{
+		Model:    "ggml-tiny",
+		Language: "en",
+		Duration: 6 * time.Second,
+		Segments: []whisper.Segment{
+			fakewhisper.Segment(0, 1500*time.Millisecond, 2500*time.Millisecond, "How are you"),
+		},
+		Timings: whisper.Timings{Encode: 2 * time.Second, Total: 4 * time.Second},
+	}
+	merged := mergeChannels([]*Transcript{left, right})
+	assert.Equal("Hello How are you Fine thanks", merged.Text())
+	assert.Equal([]int{1, 2, 1}, merged.Channels)
+	assert.Nil(merged.Speakers)
+	assert.Equal(2, merged.Segments[2].Num)
+	assert.Equal(1500*time.Millisecond, merged.Timings.Encode)
+	assert.Equal(7*time.Second, merged.Timings.Total)
+	assert.Equal(6*time.Second, merged.Duration)
+	assert.Equal("Channel 2", merged.Label(1))
+	assert.Len(left.Segments, 2)
+
+	// Speakers of each channel are kept
+	left.Speakers, right.Speakers = []int{1, 2}, []int{1}
+	merged = mergeChannels([]*Transcript{left, right})
+	assert.Equal([]int{1, 1, 2}, merged.Speakers)
+	assert.Equal("Channel 1, Speaker 2", merged.Label(2))
+	assert.True(merged.Labelled())
+	assert.False(testTranscript().Labelled())
+	assert.Equal("", testTranscript().Label(0))
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Translator translates text from the source language to the target
+// language. Languages are ISO 639-1 codes, and the source can be "auto" to
+// detect it
+type Translator interface {
+	Translate(ctx context.Context, text, source, target string) (string, error)
+}
+
+// LibreTranslate is a translator which calls the /translate endpoint of a
+// LibreTranslate service, or any service with the same API
+type LibreTranslate struct {
+	url    string
+	key    string
+	client *http.Client
+}
+
+// NewLibreTranslate returns a translator for the service at url, with an
+// optional API key
+func NewLibreTranslate(url, key string) *LibreTranslate {
+	return &LibreTranslate{
+		url:    strings.TrimSuffix(url, "/"),
+		key:    key,
+		client: &http.Client{Timeout: time.Minute},
+	}
+}
+
+// Translate translates text from source to target
+func (t *LibreTranslate) Translate(ctx context.Context, text, source, target string) (string, error) {
+	if source == "" {
+		source = "auto"
+	}
+	body, err := json.Marshal(map[string]string{
+		"q":       text,
+		"source":  source,
+		"target":  target,
+		"format":  "text",
+		"api_key": t.key,
+	})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url+"/translate", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		TranslatedText string `json:"translatedText"`
+		Error          string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil && resp.StatusCode == http.StatusOK {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		if result.Error != "" {
+			return "", fmt.Errorf("translating: %s", result.Error)
+		}
+		return "", fmt.Errorf("translating: %s", resp.Status)
+	}
+	return result.TranslatedText, nil
+}
+
+// isLanguage returns true when v looks like an ISO 639-1 language code
+func isLanguage(v string) bool {
+	if len(v) != 2 {
+		return false
+	}
+	for _, r := range v {
+		if r < 'a' || r > 'z' {
+			return false
+		}
+	}
+	return true
+}
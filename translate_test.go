@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	// Packages
+	assert "github.com/stretchr/testify/assert"
+)
+
+func Test_Translate_000(t *testing.T) {
+	assert := assert.New(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]string
+		if r.URL.Path != "/translate" || json.NewDecoder(r.Body).Decode(&req) != nil {
+			http.NotFound(w, r)
+			return
+		}
+		if req["target"] != "de" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": req["target"] + " is not supported"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"translatedText": req["source"] + ":" + req["q"] + ":" + req["api_key"]})
+	}))
+	defer server.Close()
+
+	translator := NewLibreTranslate(server.URL+"/", "secret")
+	text, err := translator.Translate(context.Background(), "Hello", "", "de")
+	assert.NoError(err)
+	assert.Equal("auto:Hello:secret", text)
+	_, err = translator.Translate(context.Background(), "Hello", "en", "xx")
+	assert.EqualError(err, "translating: xx is not supported")
+
+	assert.True(isLanguage("de"))
+	assert.False(isLanguage("DE"))
+	assert.False(isLanguage("deu"))
+	assert.False(isLanguage(""))
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"math"
+	"time"
+
+	// Packages
+	whisper "github.com/ggerganov/whisper.cpp/bindings/go/pkg/whisper"
+)
+
+// VAD is a simple voice activity detector, which compares the energy at the
+// end of the audio with the energy of the whole in the same way as the
+// whisper.cpp stream example
+type VAD struct {
+	// Length of the end of the audio which is checked for silence
+	Last time.Duration
+
+	// Speech has ended when the energy of the end is below this fraction of
+	// the energy of the whole
+	Threshold float32
+
+	// Frequency in Hz of the high pass filter applied first, 0 to disable
+	Cutoff float32
+
+	// Audio with a mean amplitude below this is silence
+	Floor float32
+}
+
+var (
+	// The default voice activity detector
+	defaultVAD = VAD{Last: time.Second, Threshold: 0.6, Cutoff: 100, Floor: 0.002}
+)
+
+// Silent returns true when there is no speech in the samples
+func (v VAD) Silent(samples []float32) bool {
+	if len(samples) == 0 {
+		return true
+	}
+	return meanAmplitude(v.filter(samples)) < v.Floor
+}
+
+// Ended returns true when the samples contain speech which has ended, so
+// the audio can be cut at the end of the samples without splitting words
+func (v VAD) Ended(samples []float32) bool {
+	last := int(v.Last * whisper.SampleRate / time.Second)
+	if last >= len(samples) {
+		return false
+	}
+	filtered := v.filter(samples)
+	all := meanAmplitude(filtered)
+	if all < v.Floor {
+		return false
+	}
+	return meanAmplitude(filtered[len(filtered)-last:]) <= v.Threshold*all
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// PRIVATE METHODS
+
+// filter returns a copy of the samples with the high pass filter applied
+func (v VAD) filter(samples []float32) []float32 {
+	return highPass(samples, v.Cutoff)
+}
+
+func meanAmplitude(samples []float32) float32 {
+	if len(samples) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, sample := range samples {
+		sum += math.Abs(float64(sample))
+	}
+	return float32(sum / float64(len(samples)))
+}
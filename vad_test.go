@@ -0,0 +1,50 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	// Packages
+	whisper "github.com/ggerganov/whisper.cpp/bindings/go/pkg/whisper"
+	assert "github.com/stretchr/testify/assert"
+)
+
+// tone returns a 440Hz sine wave lasting d
+func tone(d time.Duration) []float32 {
+	samples := make([]float32, d*whisper.SampleRate/time.Second)
+	for i := range samples {
+		samples[i] = float32(0.5 * math.Sin(2*math.Pi*440*float64(i)/whisper.SampleRate))
+	}
+	return samples
+}
+
+// silence returns silence lasting d
+func silence(d time.Duration) []float32 {
+	return make([]float32, d*whisper.SampleRate/time.Second)
+}
+
+func Test_VAD_000(t *testing.T) {
+	assert := assert.New(t)
+	vad := defaultVAD
+
+	assert.True(vad.Silent(nil))
+	assert.True(vad.Silent(silence(time.Second)))
+	assert.False(vad.Silent(tone(time.Second)))
+
+	// Speech has ended when the last second is quiet
+	assert.True(vad.Ended(append(tone(2*time.Second), silence(time.Second)...)))
+	assert.False(vad.Ended(tone(3 * time.Second)))
+	assert.False(vad.Ended(append(silence(2*time.Second), tone(time.Second)...)))
+
+	// There must be speech, and more audio than is checked
+	assert.False(vad.Ended(silence(3 * time.Second)))
+	assert.False(vad.Ended(tone(time.Second)))
+
+	// A DC offset is filtered out
+	offset := make([]float32, whisper.SampleRate)
+	for i := range offset {
+		offset[i] = 0.3
+	}
+	assert.True(vad.Silent(offset))
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// Matches captions such as "from 1:30 to 3:00", "from 90" or "to 2m"
+	reWindow = regexp.MustCompile(`(?i)^\s*(?:from\s+(\S+))?\s*(?:to\s+(\S+))?\s*$`)
+)
+
+// parseTimestamp parses "90", "1:30", "1:02:03" or a Go duration such as "1m30s"
+func parseTimestamp(s string) (time.Duration, error) {
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return d, nil
+	}
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("invalid timestamp: %q", s)
+	}
+
+	// Hours and minutes are whole numbers, seconds may have a fraction
+	var whole time.Duration
+	for i, part := range parts[:len(parts)-1] {
+		n, err := strconv.ParseUint(part, 10, 32)
+		if err != nil || (i > 0 && n >= 60) {
+			return 0, fmt.Errorf("invalid timestamp: %q", s)
+		}
+		whole = whole*60 + time.Duration(n)
+	}
+	sec, err := strconv.ParseFloat(parts[len(parts)-1], 64)
+	if err != nil || sec < 0 || (len(parts) > 1 && sec >= 60) {
+		return 0, fmt.Errorf("invalid timestamp: %q", s)
+	}
+	return whole*time.Minute + time.Duration(sec*float64(time.Second)), nil
+}
+
+// parseWindow parses a caption such as "from 1:30 to 3:00" into an offset and
+// duration. It returns ok as false when the caption is not a time window,
+// including captions such as "from mom" where the words are not timestamps
+func parseWindow(caption string) (offset, duration time.Duration, ok bool, err error) {
+	m := reWindow.FindStringSubmatch(caption)
+	if m == nil || (m[1] == "" && m[2] == "") {
+		return 0, 0, false, nil
+	}
+	if m[1] != "" {
+		if offset, err = parseTimestamp(m[1]); err != nil {
+			return 0, 0, false, nil
+		}
+	}
+	if m[2] != "" {
+		end, err := parseTimestamp(m[2])
+		if err != nil {
+			return 0, 0, false, nil
+		}
+		if end <= offset {
+			return 0, 0, true, fmt.Errorf("end %v is not after start %v", end, offset)
+		}
+		duration = end - offset
+	}
+	return offset, duration, true, nil
+}
+
+// validateWindow checks an offset and duration against the length of the
+// audio. A window which runs past the end of the audio is allowed, whisper
+// stops at the end
+func validateWindow(offset, duration, length time.Duration) error {
+	switch {
+	case offset < 0:
+		return fmt.Errorf("offset %v is negative", offset)
+	case duration < 0:
+		return fmt.Errorf("duration %v is negative", duration)
+	case offset > 0 && offset >= length:
+		return fmt.Errorf("offset %v is beyond the end of the audio (%v)", offset, length.Truncate(time.Second))
+	}
+	return nil
+}
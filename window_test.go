@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	// Packages
+	assert "github.com/stretchr/testify/assert"
+)
+
+func Test_Window_000(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, test := range []struct {
+		in   string
+		want time.Duration
+		err  bool
+	}{
+		{"0", 0, false},
+		{"90", 90 * time.Second, false},
+		{"1.5", 1500 * time.Millisecond, false},
+		{"1:30", 90 * time.Second, false},
+		{"01:02:03", time.Hour + 2*time.Minute + 3*time.Second, false},
+		{"2:00.250", 2*time.Minute + 250*time.Millisecond, false},
+		{"1m30s", 90 * time.Second, false},
+		{"1:60", 0, true},
+		{"1:75:00", 0, true},
+		{"1:2:3:4", 0, true},
+		{"-5", 0, true},
+		{"-1m", 0, true},
+		{"abc", 0, true},
+		{"", 0, true},
+	} {
+		got, err := parseTimestamp(test.in)
+		if test.err {
+			assert.Error(err, test.in)
+		} else if assert.NoError(err, test.in) {
+			assert.Equal(test.want, got, test.in)
+		}
+	}
+}
+
+func Test_Window_001(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, test := range []struct {
+		caption          string
+		offset, duration time.Duration
+		ok, err          bool
+	}{
+		{"", 0, 0, false, false},
+		{"meeting notes", 0, 0, false, false},
+		{"from 1:30 to 3:00", 90 * time.Second, 90 * time.Second, true, false},
+		{"From 10 To 20", 10 * time.Second, 10 * time.Second, true, false},
+		{"from 1:30", 90 * time.Second, 0, true, false},
+		{"to 45", 0, 45 * time.Second, true, false},
+		{"from 3:00 to 1:30", 0, 0, true, true},
+		{"from soon", 0, 0, false, false},
+		{"from mom", 0, 0, false, false},
+		{"To Alice", 0, 0, false, false},
+		{"from 1:30 to Alice", 0, 0, false, false},
+	} {
+		offset, duration, ok, err := parseWindow(test.caption)
+		assert.Equal(test.ok, ok, test.caption)
+		assert.Equal(test.err, err != nil, test.caption)
+		assert.Equal(test.offset, offset, test.caption)
+		assert.Equal(test.duration, duration, test.caption)
+	}
+}
+
+func Test_Window_002(t *testing.T) {
+	assert := assert.New(t)
+	length := 2 * time.Minute
+
+	for _, test := range []struct {
+		offset, duration time.Duration
+		err              string
+	}{
+		{0, 0, ""},
+		{time.Minute, 30 * time.Second, ""},
+		{time.Minute, 5 * time.Minute, ""},
+		{-time.Second, 0, "offset -1s is negative"},
+		{0, -time.Second, "duration -1s is negative"},
+		{2 * time.Minute, 0, "offset 2m0s is beyond the end of the audio (2m0s)"},
+		{3 * time.Minute, time.Second, "offset 3m0s is beyond the end of the audio (2m0s)"},
+	} {
+		err := validateWindow(test.offset, test.duration, length)
+		if test.err == "" {
+			assert.NoError(err)
+		} else {
+			assert.EqualError(err, test.err)
+		}
+	}
+}